@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"riverplan/game"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// batchResult is one row of the batch summary.
+type batchResult struct {
+	File            string           `json:"file"`
+	Status          string           `json:"status"` // "ok", "no_solution" or "error"
+	Profit          float64          `json:"profit"`
+	Score           string           `json:"score,omitempty"` // Objective value as shown in the window
+	RiverLength     int              `json:"river_length"`
+	Start           *game.Coordinate `json:"start,omitempty"`
+	Cards           int              `json:"cards"`
+	IgnoredCards    int              `json:"ignored_cards,omitempty"` // Cards detected in a screenshot layout but not kept (see loadLayout)
+	Seconds         float64          `json:"seconds"`
+	SearchExhausted bool             `json:"search_exhausted"`   // Every (start, length) search finished before the time limit; no proof of optimality, as the search is heuristic
+	Solution        string           `json:"solution,omitempty"` // Path of the written solution file
+	Error           string           `json:"error,omitempty"`
+
+	objective  game.Objective // Objective the layout was solved for; scores of different objectives do not compare
+	scoreValue float64        // Solution's Score, the value ranked by
+}
+
+// runBatchCommand implements "riverplan batch [flags] <dir>": it solves every layout in dir,
+// several at a time, under one shared CPU budget. Each solution is written to its own file
+// and a summary of all layouts (best first) goes to stdout. It returns the process exit code.
+func runBatchCommand(args []string) int {
+	stdout, stderr := os.Stdout, os.Stderr
+
+	fs := flag.NewFlagSet("batch", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: riverplan batch [flags] <directory>")
+		fmt.Fprintln(stderr, "Solves every layout file (JSON plan, ASCII map) or screenshot in the directory.")
+		fmt.Fprintln(stderr, "Rules not given as flags are taken from each layout file.")
+		fs.PrintDefaults()
+	}
+	rules := addRuleFlags(fs)
+	timeout := fs.Duration("timeout", 0, "time limit per layout; the best solution so far is kept (0 = no limit)")
+	cpus := fs.Int("cpus", runtime.NumCPU(), "searches running at the same time over all layouts (shared CPU budget)")
+	jobs := fs.Int("jobs", 2, "layouts solved at the same time")
+	outDir := fs.String("out", "", "directory for the solution files (default: <directory>/solved)")
+	format := fs.String("format", "text", "solution file format: text (ASCII map) or json (plan file)")
+	summary := fs.String("summary", "csv", "summary format on stdout: csv or json")
+	verbose := fs.Bool("v", false, "also print the search's debug output to stderr")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(stderr, "batch: unknown format %q (want text or json)\n", *format)
+		return 2
+	}
+	if *summary != "csv" && *summary != "json" {
+		fmt.Fprintf(stderr, "batch: unknown summary format %q (want csv or json)\n", *summary)
+		return 2
+	}
+	if *cpus < 1 || *jobs < 1 {
+		fmt.Fprintln(stderr, "batch: -cpus and -jobs must be at least 1")
+		return 2
+	}
+	if err := rules.apply(&game.PlanFile{}); err != nil { // Reports bad rule flags before any work starts
+		fmt.Fprintf(stderr, "batch: %v\n", err)
+		return 2
+	}
+
+	dir := fs.Arg(0)
+	if *outDir == "" {
+		*outDir = filepath.Join(dir, "solved")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		fmt.Fprintf(stderr, "batch: %v\n", err)
+		return 1
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		files = append(files, entry.Name())
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(stderr, "batch: no files in %s\n", dir)
+		return 1
+	}
+	if err := os.MkdirAll(*outDir, 0o755); err != nil {
+		fmt.Fprintf(stderr, "batch: %v\n", err)
+		return 1
+	}
+
+	rules.searchLog = searchLog(*verbose)
+
+	fmt.Fprintf(stderr, "Solving %d file(s) from %s, %d at a time, CPU budget %d\n", len(files), dir, *jobs, *cpus)
+	slots := make(chan struct{}, *cpus) // Shared by every Solve call
+	jobSlots := make(chan struct{}, *jobs)
+	results := make([]batchResult, len(files))
+	var mu sync.Mutex // Guards finished and stderr lines
+	finished := 0
+	batchStart := time.Now()
+
+	var wg sync.WaitGroup
+	for i, name := range files {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			jobSlots <- struct{}{}
+			defer func() { <-jobSlots }()
+
+			result := solveBatchFile(filepath.Join(dir, name), rules, slots, *cpus, *timeout, *outDir, *format)
+			result.File = name
+			results[i] = result
+
+			mu.Lock()
+			defer mu.Unlock()
+			finished++
+			switch result.Status {
+			case "ok":
+				searched := "time limit reached"
+				if result.SearchExhausted {
+					searched = "search exhausted"
+				}
+				fmt.Fprintf(stderr, "[%d/%d] %s: %.2f%%, river %d tiles, %.1fs (%s)\n", finished, len(files), name, result.Profit*100, result.RiverLength, result.Seconds, searched)
+			default:
+				fmt.Fprintf(stderr, "[%d/%d] %s: %s: %s\n", finished, len(files), name, result.Status, result.Error)
+			}
+		}(i, name)
+	}
+	wg.Wait()
+	fmt.Fprintf(stderr, "Batch done in %s.\n", time.Since(batchStart).Round(time.Millisecond))
+
+	// Best first by the objective's score, so the summary doubles as a ranking
+	sort.SliceStable(results, func(i, j int) bool {
+		if (results[i].Status == "ok") != (results[j].Status == "ok") {
+			return results[i].Status == "ok"
+		}
+		if results[i].objective != results[j].objective {
+			return results[i].objective < results[j].objective
+		}
+		if results[i].scoreValue != results[j].scoreValue {
+			return results[i].scoreValue > results[j].scoreValue
+		}
+		if results[i].Profit != results[j].Profit {
+			return results[i].Profit > results[j].Profit
+		}
+		return results[i].File < results[j].File
+	})
+	if *summary == "json" {
+		err = writeBatchJSON(stdout, results)
+	} else {
+		err = writeBatchCSV(stdout, results)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "batch: writing summary: %v\n", err)
+		return 1
+	}
+	for _, result := range results {
+		if result.Status == "error" {
+			return 1
+		}
+	}
+	return 0
+}
+
+// solveBatchFile loads and solves one layout of a batch and writes its solution file.
+// File is left for the caller to fill in.
+func solveBatchFile(path string, rules *ruleFlags, slots chan struct{}, workers int, timeout time.Duration, outDir, format string) batchResult {
+	plan, ignoredCards, err := loadLayout(path)
+	if err != nil {
+		return batchResult{Status: "error", Error: err.Error()}
+	}
+	if err := rules.apply(&plan); err != nil {
+		return batchResult{Status: "error", Error: err.Error()}
+	}
+	opts := rules.solveOptions(plan)
+	opts.Workers = workers
+	opts.Slots = slots
+
+	stopChannel := make(chan struct{})
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() { close(stopChannel) })
+		defer timer.Stop()
+	}
+	complete := false
+	solveStart := time.Now()
+	best, err := game.Solve(plan.RoadGrid(), opts, func(p game.SolveProgress) {
+		complete = p.Done == p.Total // Calls are serialized by Solve
+	}, stopChannel)
+	result := batchResult{Seconds: time.Since(solveStart).Seconds(), SearchExhausted: complete, IgnoredCards: ignoredCards}
+	if err != nil {
+		result.Status = "no_solution"
+		result.Error = err.Error()
+		return result
+	}
+
+	solvedPlan(&plan, best)
+	result.Status = "ok"
+	result.Profit = best.Profit
+	result.Score = opts.Search.FormatScore(best)
+	result.objective = opts.Search.Objective
+	result.scoreValue = best.Score
+	result.RiverLength = len(best.Path)
+	result.Start = plan.Start
+	result.Cards = best.Cards
+
+	extension := ".solved.txt"
+	if format == "json" {
+		extension = ".solved.json"
+	}
+	// The source extension stays in the name, so a.txt and a.json do not overwrite each other's solution
+	solutionPath := filepath.Join(outDir, filepath.Base(path)+extension)
+	file, err := os.Create(solutionPath)
+	if err == nil {
+		err = writeSolveOutput(file, plan, best, format)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		result.Status = "error"
+		result.Error = fmt.Sprintf("writing solution: %v", err)
+		return result
+	}
+	result.Solution = solutionPath
+	return result
+}
+
+// writeBatchCSV writes the batch summary as CSV with a header row.
+func writeBatchCSV(w io.Writer, results []batchResult) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"file", "status", "profit_percent", "score", "river_length", "start", "cards", "ignored_cards", "seconds", "search_exhausted", "solution", "error"})
+	for _, r := range results {
+		start := ""
+		if r.Start != nil {
+			start = fmt.Sprintf("%d,%d", r.Start.X, r.Start.Y)
+		}
+		cw.Write([]string{
+			r.File,
+			r.Status,
+			strconv.FormatFloat(r.Profit*100, 'f', 2, 64),
+			r.Score,
+			strconv.Itoa(r.RiverLength),
+			start,
+			strconv.Itoa(r.Cards),
+			strconv.Itoa(r.IgnoredCards),
+			strconv.FormatFloat(r.Seconds, 'f', 3, 64),
+			strconv.FormatBool(r.SearchExhausted),
+			r.Solution,
+			r.Error,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeBatchJSON writes the batch summary as an indented JSON array.
+func writeBatchJSON(w io.Writer, results []batchResult) error {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
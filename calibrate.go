@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"riverplan/detect"
+	"riverplan/game"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+// gridProfilesFile is where the grid calibrations are stored, one per screenshot resolution
+// (see detect.Profiles). Imports at a calibrated resolution use it instead of finding the grid.
+const gridProfilesFile = "grid-profiles.json"
+
+// gridCalibration is the state of the calibration wizard (StateCalibratingGrid): the full
+// screenshot of the reviewed detection and the grid corners picked on it so far.
+type gridCalibration struct {
+	source            image.Image
+	sourceDescription string
+	image             *ebiten.Image    // source, created on the first Draw
+	corners           []image.Point    // Picked corners in screenshot pixels (at most 2)
+	location          *detect.Location // The calibrated grid once both corners are picked and valid
+	problem           string           // Why the picked corners were rejected
+}
+
+// view returns how the screenshot is fitted into the game area: its scale and the offset of
+// its top-left corner.
+func (c *gridCalibration) view() (scale, offsetX, offsetY float64) {
+	bounds := c.source.Bounds()
+	scale = math.Min(float64(gameAreaWidth)/float64(bounds.Dx()), float64(screenHeight)/float64(bounds.Dy()))
+	offsetX = (float64(gameAreaWidth) - float64(bounds.Dx())*scale) / 2
+	offsetY = (float64(screenHeight) - float64(bounds.Dy())*scale) / 2
+	return scale, offsetX, offsetY
+}
+
+// loadGridProfiles reads the stored grid calibrations. Problems are logged and give no profiles,
+// so a broken file never blocks detection.
+func loadGridProfiles() detect.Profiles {
+	profiles, err := detect.LoadProfiles(gridProfilesFile)
+	if err != nil {
+		log.Printf("Error loading grid profiles: %v", err)
+		return detect.Profiles{}
+	}
+	return profiles
+}
+
+// detectOptions returns the detection options of the window: the grid calibrations and,
+// when turned on in the review, the debug dumps.
+func (g *Game) detectOptions() detect.Options {
+	opts := detect.Options{Profiles: g.gridProfiles}
+	if g.detectDebugDumps {
+		opts.DebugDir = detectionDebugDir
+	}
+	return opts
+}
+
+// startGridCalibration opens the calibration wizard on the screenshot of the reviewed detection.
+// NOTE: g.mu is assumed to be HELD by the caller
+func (g *Game) startGridCalibration() {
+	if g.review == nil || g.review.result.Source == nil {
+		return
+	}
+	g.calibration = &gridCalibration{source: g.review.result.Source, sourceDescription: g.review.source}
+	g.gameState = StateCalibratingGrid
+	fmt.Printf("[DEBUG] Grid calibration started on a %s screenshot\n", detect.ResolutionKey(g.calibration.source.Bounds()))
+	g.updateButtonsForState()
+	g.updateCalculationStatus()
+}
+
+// calibrationStatus returns the status text shown by the calibration wizard.
+func (g *Game) calibrationStatus() string {
+	c := g.calibration
+	status := fmt.Sprintf("Calibrate grid (%s screenshot).\n", detect.ResolutionKey(c.source.Bounds()))
+	switch {
+	case len(c.corners) == 0:
+		status += "Click the outer top-left corner of the grid."
+	case len(c.corners) == 1:
+		status += "Click the outer bottom-right corner of the grid."
+	case c.location != nil:
+		status += fmt.Sprintf("Grid %v, tiles %.1f px.\nCheck the lines, then Save. Click again to redo.", c.location.Rect, c.location.Pitch)
+	default:
+		status += c.problem + "\nClick again to redo."
+	}
+	return status
+}
+
+// pickCalibrationCorner records a click at (x, y) in the game area as the next grid corner.
+// A click after both corners were picked starts over.
+// NOTE: g.mu is assumed to be HELD by the caller
+func (g *Game) pickCalibrationCorner(x, y int) {
+	c := g.calibration
+	scale, offsetX, offsetY := c.view()
+	bounds := c.source.Bounds()
+	corner := image.Point{
+		X: bounds.Min.X + int((float64(x)-offsetX)/scale+0.5),
+		Y: bounds.Min.Y + int((float64(y)-offsetY)/scale+0.5),
+	}
+	if !corner.In(bounds.Inset(-1)) { // The far edge itself is a valid corner
+		return
+	}
+	if len(c.corners) == 2 {
+		c.corners, c.location, c.problem = nil, nil, ""
+	}
+	c.corners = append(c.corners, corner)
+	if len(c.corners) == 2 {
+		loc, err := detect.Calibrate(bounds, c.corners[0], c.corners[1])
+		if err != nil {
+			c.problem = err.Error()
+		} else {
+			c.location = &loc
+		}
+		fmt.Printf("[DEBUG] Calibration corners %v: location %v, problem %q\n", c.corners, c.location, c.problem)
+	}
+	g.updateButtonsForState()
+	g.updateCalculationStatus()
+}
+
+// saveGridCalibration stores the calibrated grid as the profile of the screenshot's resolution
+// and runs the detection again with it, back in the review.
+// NOTE: g.mu is assumed to be HELD by the caller
+func (g *Game) saveGridCalibration() {
+	c := g.calibration
+	if c.location == nil {
+		return
+	}
+	g.gridProfiles.Set(c.source.Bounds(), *c.location)
+	if err := detect.SaveProfiles(gridProfilesFile, g.gridProfiles); err != nil {
+		log.Printf("Error saving grid profiles: %v", err)
+		c.problem = fmt.Sprintf("Calibration not saved: %v", err)
+		g.calculationStatus = c.problem
+		return
+	}
+	log.Printf("Saved grid calibration %v for %s screenshots to %s", c.location.Rect, detect.ResolutionKey(c.source.Bounds()), gridProfilesFile)
+
+	result, err := detect.Detect(c.source, g.detectOptions())
+	if err != nil {
+		log.Printf("Error detecting road with the new calibration: %v", err)
+		g.calculationStatus = fmt.Sprintf("Grid Detect Err with calibration: %v", err)
+		return
+	}
+	previous := g.review.previous
+	g.calibration = nil
+	g.processDetectedImage(result, c.sourceDescription)
+	g.review.previous = previous // Discarding still returns to where the user started
+}
+
+// cancelGridCalibration closes the wizard and returns to the review unchanged.
+// NOTE: g.mu is assumed to be HELD by the caller
+func (g *Game) cancelGridCalibration() {
+	g.calibration = nil
+	g.gameState = StateReviewingDetection
+	g.updateButtonsForState()
+	g.updateCalculationStatus()
+}
+
+// appendCalibrationButtons adds the buttons of the calibration wizard.
+func (g *Game) appendCalibrationButtons(buttonMinX, buttonMaxX int) {
+	if g.calibration.location != nil {
+		g.buttons = append(g.buttons, Button{
+			Rect:    image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+			Text:    "Save Calibration",
+			OnClick: func(g *Game) { g.saveGridCalibration() },
+		})
+	}
+	g.buttons = append(g.buttons, Button{
+		Rect:    image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+		Text:    "Cancel Calibration",
+		OnClick: func(g *Game) { g.cancelGridCalibration() },
+	})
+}
+
+// drawGridCalibration draws the full screenshot into the game area with the picked corners
+// and, once both are picked, the grid lines they give.
+// NOTE: g.mu is assumed to be HELD by the caller
+func (g *Game) drawGridCalibration(gameArea *ebiten.Image) {
+	c := g.calibration
+	if c.image == nil {
+		c.image = ebiten.NewImageFromImage(c.source)
+	}
+	scale, offsetX, offsetY := c.view()
+	op := &ebiten.DrawImageOptions{}
+	op.GeoM.Scale(scale, scale)
+	op.GeoM.Translate(offsetX, offsetY)
+	op.Filter = ebiten.FilterLinear
+	gameArea.DrawImage(c.image, op)
+
+	bounds := c.source.Bounds()
+	toScreen := func(x, y float64) (float64, float64) {
+		return offsetX + (x-float64(bounds.Min.X))*scale, offsetY + (y-float64(bounds.Min.Y))*scale
+	}
+	if c.location != nil {
+		rect := c.location.Rect
+		lineColor := color.RGBA{R: 0, G: 255, B: 255, A: 255}
+		cellWidth := float64(rect.Dx()) / game.GridWidth
+		cellHeight := float64(rect.Dy()) / game.GridHeight
+		for i := 0; i <= game.GridWidth; i++ {
+			x1, y1 := toScreen(float64(rect.Min.X)+float64(i)*cellWidth, float64(rect.Min.Y))
+			x2, y2 := toScreen(float64(rect.Min.X)+float64(i)*cellWidth, float64(rect.Max.Y))
+			ebitenutil.DrawLine(gameArea, x1, y1, x2, y2, lineColor)
+		}
+		for i := 0; i <= game.GridHeight; i++ {
+			x1, y1 := toScreen(float64(rect.Min.X), float64(rect.Min.Y)+float64(i)*cellHeight)
+			x2, y2 := toScreen(float64(rect.Max.X), float64(rect.Min.Y)+float64(i)*cellHeight)
+			ebitenutil.DrawLine(gameArea, x1, y1, x2, y2, lineColor)
+		}
+	}
+	for _, corner := range c.corners {
+		x, y := toScreen(float64(corner.X), float64(corner.Y))
+		ebitenutil.DrawRect(gameArea, x-3, y-3, 7, 7, color.RGBA{R: 255, G: 0, B: 0, A: 255}) // Red marker on each picked corner
+	}
+}
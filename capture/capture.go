@@ -0,0 +1,29 @@
+// Package capture grabs the pixels of the running game's window, so the road detector (see the
+// detect package) can read the board without a manual screenshot. Windows are found by title
+// through X11 (github.com/jezek/xgb); on platforms without X11 every call fails.
+//
+// FakeWindow shows a screenshot in a window with the game's title. Under Xvfb it stands in for
+// the game, so capturing can be tried without Loop Hero running (see "riverplan capture -fake").
+package capture
+
+import "errors"
+
+// DefaultWindowTitle is the title of the Loop Hero window.
+const DefaultWindowTitle = "Loop Hero"
+
+// ErrWindowNotFound is returned when no visible window has the wanted title.
+var ErrWindowNotFound = errors.New("window not found")
+
+// Options selects the window to capture.
+type Options struct {
+	Display string // X display, e.g. ":99" for Xvfb (default: $DISPLAY)
+	Title   string // Part of the window title to look for (default: DefaultWindowTitle)
+}
+
+// title returns the window title to look for.
+func (o Options) title() string {
+	if o.Title == "" {
+		return DefaultWindowTitle
+	}
+	return o.Title
+}
@@ -0,0 +1,21 @@
+//go:build !linux && !freebsd && !netbsd && !openbsd
+
+package capture
+
+import (
+	"errors"
+	"image"
+)
+
+// errNoX11 is returned by every call on platforms without X11.
+var errNoX11 = errors.New("capturing the game window needs X11, which this platform does not have")
+
+// Window is not implemented on this platform.
+func Window(opts Options) (image.Image, error) {
+	return nil, errNoX11
+}
+
+// FakeWindow is not implemented on this platform.
+func FakeWindow(opts Options, img image.Image) (func() error, error) {
+	return nil, errNoX11
+}
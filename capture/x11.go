@@ -0,0 +1,368 @@
+//go:build linux || freebsd || netbsd || openbsd
+
+package capture
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"log"
+	"math/bits"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+const (
+	// maxWindowTitle is how many bytes of a window title are read (in 4-byte units).
+	maxWindowTitle = 256
+	// putImageHeader is the size of a PutImage request without its pixels, in bytes.
+	putImageHeader = 24
+	// fakeWindowTimeout is how long FakeWindow waits for its window to be shown.
+	fakeWindowTimeout = 5 * time.Second
+)
+
+// Window finds the largest visible window on the X display whose title contains opts.Title and
+// returns its pixels. The pixels are read from the screen (the root window), so the window must
+// not be covered by other windows; the parts outside the screen are cut off.
+func Window(opts Options) (image.Image, error) {
+	conn, err := connect(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	setup := xproto.Setup(conn)
+	screen := setup.DefaultScreen(conn)
+
+	title := opts.title()
+	window, err := findWindow(conn, screen.Root, title)
+	if err != nil {
+		return nil, err
+	}
+	geometry, err := xproto.GetGeometry(conn, xproto.Drawable(window)).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("reading the size of window %q: %w", title, err)
+	}
+	origin, err := xproto.TranslateCoordinates(conn, window, screen.Root, 0, 0).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("reading the position of window %q: %w", title, err)
+	}
+	rect := image.Rect(int(origin.DstX), int(origin.DstY), int(origin.DstX)+int(geometry.Width), int(origin.DstY)+int(geometry.Height))
+	rect = rect.Intersect(image.Rect(0, 0, int(screen.WidthInPixels), int(screen.HeightInPixels)))
+	if rect.Empty() {
+		return nil, fmt.Errorf("window %q is off the screen", title)
+	}
+
+	reply, err := xproto.GetImage(conn, xproto.ImageFormatZPixmap, xproto.Drawable(screen.Root),
+		int16(rect.Min.X), int16(rect.Min.Y), uint16(rect.Dx()), uint16(rect.Dy()), 0xffffffff).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("grabbing the pixels of window %q: %w", title, err)
+	}
+	format, err := newPixelFormat(setup, screen.RootDepth, screen.RootVisual)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Captured window %q (%d) at %v, depth %d", title, window, rect, reply.Depth)
+	return format.decode(reply.Data, rect.Dx(), rect.Dy())
+}
+
+// FakeWindow opens a window titled opts.Title showing img, standing in for the game on a test
+// display such as Xvfb. It returns once the window is shown; the returned function closes it.
+func FakeWindow(opts Options, img image.Image) (func() error, error) {
+	bounds := img.Bounds()
+	if bounds.Empty() || bounds.Dx() > 0xffff || bounds.Dy() > 0xffff {
+		return nil, fmt.Errorf("a %dx%d image does not fit in a window", bounds.Dx(), bounds.Dy())
+	}
+	conn, err := connect(opts)
+	if err != nil {
+		return nil, err
+	}
+	setup := xproto.Setup(conn)
+	screen := setup.DefaultScreen(conn)
+	format, err := newPixelFormat(setup, screen.RootDepth, screen.RootVisual)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	width, height := bounds.Dx(), bounds.Dy()
+	data := format.encode(img)
+	stride := format.stride(width)
+
+	window, err := xproto.NewWindowId(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	err = xproto.CreateWindowChecked(conn, screen.RootDepth, window, screen.Root, 0, 0, uint16(width), uint16(height), 0,
+		xproto.WindowClassInputOutput, screen.RootVisual, xproto.CwBackPixel|xproto.CwEventMask,
+		[]uint32{screen.BlackPixel, xproto.EventMaskExposure}).Check()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("creating window: %w", err)
+	}
+	if err := setWindowTitle(conn, window, opts.title()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	gc, err := xproto.NewGcontextId(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	xproto.CreateGC(conn, gc, xproto.Drawable(window), 0, nil)
+
+	// A request may not exceed the server's maximum length, so the image is sent in bands of rows
+	rowsPerRequest := (int(setup.MaximumRequestLength)*4 - putImageHeader) / stride
+	if rowsPerRequest < 1 {
+		conn.Close()
+		return nil, fmt.Errorf("a %d pixel wide row does not fit in one X request", width)
+	}
+	paint := func() {
+		for y := 0; y < height; y += rowsPerRequest {
+			rows := min(rowsPerRequest, height-y)
+			xproto.PutImage(conn, xproto.ImageFormatZPixmap, xproto.Drawable(window), gc, uint16(width), uint16(rows),
+				0, int16(y), 0, screen.RootDepth, data[y*stride:(y+rows)*stride])
+		}
+		xproto.GetInputFocus(conn).Reply() // Round trip, so the pixels are on the screen when it returns
+	}
+
+	shown := make(chan struct{})
+	go func() {
+		first := true
+		for {
+			event, err := conn.WaitForEvent()
+			if event == nil && err == nil {
+				return // Connection closed
+			}
+			if expose, ok := event.(xproto.ExposeEvent); ok && expose.Count == 0 {
+				paint()
+				if first {
+					close(shown)
+					first = false
+				}
+			}
+		}
+	}()
+	xproto.MapWindow(conn, window)
+
+	select {
+	case <-shown:
+	case <-time.After(fakeWindowTimeout):
+		conn.Close()
+		return nil, fmt.Errorf("window %q was not shown within %v", opts.title(), fakeWindowTimeout)
+	}
+	log.Printf("Fake window %q (%d) shows a %dx%d image", opts.title(), window, width, height)
+	return func() error {
+		conn.Close()
+		return nil
+	}, nil
+}
+
+// connect opens the X display of opts.
+func connect(opts Options) (*xgb.Conn, error) {
+	display := opts.Display
+	if display == "" {
+		display = os.Getenv("DISPLAY")
+	}
+	conn, err := xgb.NewConnDisplay(display)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to X display %q: %w", display, err)
+	}
+	return conn, nil
+}
+
+// findWindow returns the largest viewable window below root whose title contains title.
+func findWindow(conn *xgb.Conn, root xproto.Window, title string) (xproto.Window, error) {
+	netWMName, err := internAtom(conn, "_NET_WM_NAME")
+	if err != nil {
+		return 0, err
+	}
+	var best xproto.Window
+	bestArea := 0
+	queue := []xproto.Window{root}
+	for len(queue) > 0 {
+		window := queue[0]
+		queue = queue[1:]
+		if tree, err := xproto.QueryTree(conn, window).Reply(); err == nil {
+			queue = append(queue, tree.Children...)
+		}
+		if window == root || !strings.Contains(windowTitle(conn, window, netWMName), title) {
+			continue
+		}
+		attributes, err := xproto.GetWindowAttributes(conn, window).Reply()
+		if err != nil || attributes.MapState != xproto.MapStateViewable {
+			continue
+		}
+		geometry, err := xproto.GetGeometry(conn, xproto.Drawable(window)).Reply()
+		if err != nil {
+			continue // Destroyed meanwhile
+		}
+		if area := int(geometry.Width) * int(geometry.Height); area > bestArea {
+			best, bestArea = window, area
+		}
+	}
+	if bestArea == 0 {
+		return 0, fmt.Errorf("%w: no visible window titled %q", ErrWindowNotFound, title)
+	}
+	return best, nil
+}
+
+// windowTitle returns the title of window: _NET_WM_NAME (UTF-8) if set, otherwise WM_NAME.
+func windowTitle(conn *xgb.Conn, window xproto.Window, netWMName xproto.Atom) string {
+	for _, property := range []xproto.Atom{netWMName, xproto.AtomWmName} {
+		reply, err := xproto.GetProperty(conn, false, window, property, xproto.GetPropertyTypeAny, 0, maxWindowTitle).Reply()
+		if err == nil && reply.ValueLen > 0 {
+			return string(reply.Value)
+		}
+	}
+	return ""
+}
+
+// setWindowTitle sets both title properties of window.
+func setWindowTitle(conn *xgb.Conn, window xproto.Window, title string) error {
+	netWMName, err := internAtom(conn, "_NET_WM_NAME")
+	if err != nil {
+		return err
+	}
+	utf8String, err := internAtom(conn, "UTF8_STRING")
+	if err != nil {
+		return err
+	}
+	xproto.ChangeProperty(conn, xproto.PropModeReplace, window, xproto.AtomWmName, xproto.AtomString, 8, uint32(len(title)), []byte(title))
+	xproto.ChangeProperty(conn, xproto.PropModeReplace, window, netWMName, utf8String, 8, uint32(len(title)), []byte(title))
+	return nil
+}
+
+// internAtom returns the atom called name.
+func internAtom(conn *xgb.Conn, name string) (xproto.Atom, error) {
+	reply, err := xproto.InternAtom(conn, false, uint16(len(name)), name).Reply()
+	if err != nil {
+		return 0, fmt.Errorf("looking up atom %s: %w", name, err)
+	}
+	return reply.Atom, nil
+}
+
+// pixelFormat describes how the X server lays out the pixels of ZPixmap images.
+type pixelFormat struct {
+	bytesPerPixel    int
+	scanlinePad      int // Rows are padded to a multiple of this many bits
+	msbFirst         bool
+	red, green, blue channel
+}
+
+// channel is where one colour channel sits in a pixel value.
+type channel struct {
+	mask  uint32
+	shift int
+	max   uint32 // mask >> shift
+}
+
+// newChannel returns the channel of mask.
+func newChannel(mask uint32) channel {
+	shift := bits.TrailingZeros32(mask)
+	return channel{mask: mask, shift: shift, max: mask >> shift}
+}
+
+// newPixelFormat returns the pixel format of images of depth with the given visual. Only
+// true colour visuals with whole bytes per pixel are supported, which covers every usual display.
+func newPixelFormat(setup *xproto.SetupInfo, depth byte, visualID xproto.Visualid) (pixelFormat, error) {
+	format := pixelFormat{msbFirst: setup.ImageByteOrder != xproto.ImageOrderLSBFirst}
+	for _, f := range setup.PixmapFormats {
+		if f.Depth == depth {
+			format.bytesPerPixel = int(f.BitsPerPixel) / 8
+			format.scanlinePad = int(f.ScanlinePad)
+		}
+	}
+	if format.bytesPerPixel < 2 || format.bytesPerPixel > 4 {
+		return format, fmt.Errorf("unsupported X pixel format for depth %d", depth)
+	}
+	for _, screen := range setup.Roots {
+		for _, d := range screen.AllowedDepths {
+			for _, visual := range d.Visuals {
+				if visual.VisualId != visualID {
+					continue
+				}
+				if visual.Class != xproto.VisualClassTrueColor && visual.Class != xproto.VisualClassDirectColor {
+					return format, fmt.Errorf("unsupported X visual class %d (only true colour)", visual.Class)
+				}
+				format.red, format.green, format.blue = newChannel(visual.RedMask), newChannel(visual.GreenMask), newChannel(visual.BlueMask)
+				return format, nil
+			}
+		}
+	}
+	return format, fmt.Errorf("X visual %d not found", visualID)
+}
+
+// stride returns the length of an image row of width pixels, in bytes.
+func (f pixelFormat) stride(width int) int {
+	rowBits := width * f.bytesPerPixel * 8
+	return (rowBits + f.scanlinePad - 1) / f.scanlinePad * f.scanlinePad / 8
+}
+
+// decode converts ZPixmap data into an RGBA image.
+func (f pixelFormat) decode(data []byte, width, height int) (*image.RGBA, error) {
+	stride := f.stride(width)
+	if len(data) < stride*height {
+		return nil, fmt.Errorf("X image has %d bytes, want %d for %dx%d pixels", len(data), stride*height, width, height)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pixel := data[y*stride+x*f.bytesPerPixel:][:f.bytesPerPixel]
+			var value uint32
+			for i, b := range pixel {
+				if f.msbFirst {
+					value = value<<8 | uint32(b)
+				} else {
+					value |= uint32(b) << (8 * i)
+				}
+			}
+			i := img.PixOffset(x, y)
+			img.Pix[i+0] = f.red.get(value)
+			img.Pix[i+1] = f.green.get(value)
+			img.Pix[i+2] = f.blue.get(value)
+			img.Pix[i+3] = 255
+		}
+	}
+	return img, nil
+}
+
+// encode converts img into ZPixmap data, the inverse of decode.
+func (f pixelFormat) encode(img image.Image) []byte {
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	stride := f.stride(bounds.Dx())
+	data := make([]byte, stride*bounds.Dy())
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			i := rgba.PixOffset(x, y)
+			value := f.red.put(rgba.Pix[i+0]) | f.green.put(rgba.Pix[i+1]) | f.blue.put(rgba.Pix[i+2])
+			pixel := data[y*stride+x*f.bytesPerPixel:][:f.bytesPerPixel]
+			for b := range pixel {
+				if f.msbFirst {
+					pixel[b] = byte(value >> (8 * (f.bytesPerPixel - 1 - b)))
+				} else {
+					pixel[b] = byte(value >> (8 * b))
+				}
+			}
+		}
+	}
+	return data
+}
+
+// get returns the channel of a pixel value as 0-255.
+func (c channel) get(value uint32) uint8 {
+	if c.max == 0 {
+		return 0
+	}
+	return uint8(((value & c.mask) >> c.shift) * 255 / c.max)
+}
+
+// put returns the pixel value bits of a 0-255 channel value.
+func (c channel) put(v uint8) uint32 {
+	return ((uint32(v)*c.max + 127) / 255 << c.shift) & c.mask
+}
@@ -0,0 +1,137 @@
+//go:build linux || freebsd || netbsd || openbsd
+
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"testing"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// testImage returns an image whose pixels all differ, with a bounds origin that is not (0, 0).
+func testImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(3, 4, 3+width, 4+height))
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 37), uint8(y * 23), uint8(x*y + 11), 255})
+		}
+	}
+	return img
+}
+
+// sameImage reports the first pixel of got, an image at (0, 0), that differs from want by more
+// than tolerance in a channel.
+func sameImage(got, want *image.RGBA, tolerance int) error {
+	if got.Rect.Dx() != want.Rect.Dx() || got.Rect.Dy() != want.Rect.Dy() {
+		return fmt.Errorf("got a %dx%d image, want %dx%d", got.Rect.Dx(), got.Rect.Dy(), want.Rect.Dx(), want.Rect.Dy())
+	}
+	for y := 0; y < want.Rect.Dy(); y++ {
+		for x := 0; x < want.Rect.Dx(); x++ {
+			g, w := got.RGBAAt(x, y), want.RGBAAt(want.Rect.Min.X+x, want.Rect.Min.Y+y)
+			for _, d := range []int{int(g.R) - int(w.R), int(g.G) - int(w.G), int(g.B) - int(w.B)} {
+				if d > tolerance || d < -tolerance {
+					return fmt.Errorf("pixel (%d, %d) is %v, want %v", x, y, g, w)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func TestPixelFormatRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		depth, bitsPerPixel byte
+		red, green, blue    uint32
+		tolerance           int // 16-bit pixels keep only the top 5 or 6 bits of a channel
+	}{
+		{24, 32, 0xff0000, 0x00ff00, 0x0000ff, 0},
+		{24, 32, 0x0000ff, 0x00ff00, 0xff0000, 0}, // BGR
+		{24, 24, 0xff0000, 0x00ff00, 0x0000ff, 0},
+		{16, 16, 0xf800, 0x07e0, 0x001f, 9},
+	} {
+		for _, byteOrder := range []byte{xproto.ImageOrderLSBFirst, xproto.ImageOrderMSBFirst} {
+			t.Run(fmt.Sprintf("depth%d-bpp%d-mask%x-order%d", tc.depth, tc.bitsPerPixel, tc.red, byteOrder), func(t *testing.T) {
+				setup := &xproto.SetupInfo{
+					ImageByteOrder: byteOrder,
+					PixmapFormats:  []xproto.Format{{Depth: tc.depth, BitsPerPixel: tc.bitsPerPixel, ScanlinePad: 32}},
+					Roots: []xproto.ScreenInfo{{AllowedDepths: []xproto.DepthInfo{{Depth: tc.depth, Visuals: []xproto.VisualInfo{
+						{VisualId: 7, Class: xproto.VisualClassTrueColor, RedMask: tc.red, GreenMask: tc.green, BlueMask: tc.blue},
+					}}}}},
+				}
+				format, err := newPixelFormat(setup, tc.depth, 7)
+				if err != nil {
+					t.Fatalf("newPixelFormat: %v", err)
+				}
+				img := testImage(5, 3) // Odd width, so rows need padding
+				data := format.encode(img)
+				if want := format.stride(5) * 3; len(data) != want {
+					t.Fatalf("encoded %d bytes, want %d", len(data), want)
+				}
+				decoded, err := format.decode(data, 5, 3)
+				if err != nil {
+					t.Fatalf("decode: %v", err)
+				}
+				if err := sameImage(decoded, img, tc.tolerance); err != nil {
+					t.Error(err)
+				}
+				if _, err := format.decode(data[:len(data)-1], 5, 3); err == nil {
+					t.Error("decode accepted truncated data")
+				}
+			})
+		}
+	}
+}
+
+func TestNewPixelFormatRejectsUnsupportedVisuals(t *testing.T) {
+	setup := &xproto.SetupInfo{
+		PixmapFormats: []xproto.Format{{Depth: 8, BitsPerPixel: 8, ScanlinePad: 32}, {Depth: 24, BitsPerPixel: 32, ScanlinePad: 32}},
+		Roots: []xproto.ScreenInfo{{AllowedDepths: []xproto.DepthInfo{{Depth: 24, Visuals: []xproto.VisualInfo{
+			{VisualId: 7, Class: xproto.VisualClassPseudoColor},
+		}}}}},
+	}
+	if _, err := newPixelFormat(setup, 8, 7); err == nil {
+		t.Error("accepted 8 bits per pixel")
+	}
+	if _, err := newPixelFormat(setup, 24, 7); err == nil {
+		t.Error("accepted a pseudo colour visual")
+	}
+	if _, err := newPixelFormat(setup, 24, 8); err == nil {
+		t.Error("accepted a missing visual")
+	}
+}
+
+// TestWindowCapturesFakeWindow shows an image with FakeWindow and captures it again with Window.
+// It needs an X display, e.g. "Xvfb :99 -screen 0 640x480x24 & DISPLAY=:99 go test ./capture".
+func TestWindowCapturesFakeWindow(t *testing.T) {
+	if os.Getenv("DISPLAY") == "" {
+		t.Skip("DISPLAY is not set; run under Xvfb to test capturing")
+	}
+	opts := Options{Title: fmt.Sprintf("riverplan capture test %d", os.Getpid())}
+	if _, err := Window(opts); !errors.Is(err, ErrWindowNotFound) {
+		t.Fatalf("Window before the fake window is shown: %v, want ErrWindowNotFound", err)
+	}
+
+	img := testImage(64, 48)
+	closeWindow, err := FakeWindow(opts, img)
+	if err != nil {
+		t.Fatalf("FakeWindow: %v", err)
+	}
+	defer closeWindow()
+
+	captured, err := Window(opts)
+	if err != nil {
+		t.Fatalf("Window: %v", err)
+	}
+	rgba, ok := captured.(*image.RGBA)
+	if !ok {
+		t.Fatalf("Window returned a %T, want *image.RGBA", captured)
+	}
+	if err := sameImage(rgba, img, 9); err != nil { // 9 allows a 16-bit display
+		t.Error(err)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"riverplan/capture"
+	"riverplan/detect"
+	"syscall"
+)
+
+// runCaptureCommand implements "riverplan capture [flags]": it grabs the pixels of the game
+// window through X11, runs the screenshot detector on them and prints the board like
+// "riverplan detect". With -fake it instead shows a screenshot in a window with the game's
+// title until interrupted, so capturing can be tried on a test display such as Xvfb:
+//
+//	Xvfb :99 -screen 0 2560x1440x24 &
+//	riverplan capture -display :99 -fake shot.png &
+//	riverplan capture -display :99 -o grabbed.png
+//
+// It returns the process exit code.
+func runCaptureCommand(args []string) int {
+	stdout, stderr := os.Stdout, os.Stderr
+
+	fs := flag.NewFlagSet("capture", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: riverplan capture [flags]")
+		fmt.Fprintln(stderr, "Captures the game window through X11 and prints the detected board as an ASCII map.")
+		fmt.Fprintln(stderr, "With -fake, shows a screenshot in a window with the game's title instead (for testing under Xvfb).")
+		fs.PrintDefaults()
+	}
+	display := fs.String("display", "", "X display to use (default: $DISPLAY)")
+	title := fs.String("title", capture.DefaultWindowTitle, "part of the title of the window to capture")
+	outPath := fs.String("o", "", "also save the captured pixels to this PNG file")
+	fakePath := fs.String("fake", "", "show this screenshot in a window titled -title until interrupted, instead of capturing")
+	profilesPath := fs.String("profiles", gridProfilesFile, "grid calibrations saved by the window, used for captures of a calibrated resolution")
+	debugDir := fs.String("debug", "", "write a debug dump of the detection into a new folder under this directory")
+	verbose := fs.Bool("v", false, "print the capture and detector debug log to stderr")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(stderr)
+	}
+	opts := capture.Options{Display: *display, Title: *title}
+
+	if *fakePath != "" {
+		return runFakeGameWindow(opts, *fakePath, stdout, stderr)
+	}
+
+	img, err := capture.Window(opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "capture: %v\n", err)
+		return 1
+	}
+	if *outPath != "" {
+		if err := savePNGFile(*outPath, img); err != nil {
+			fmt.Fprintf(stderr, "capture: %v\n", err)
+			return 1
+		}
+	}
+	profiles, err := detect.LoadProfiles(*profilesPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "capture: %v\n", err)
+		return 1
+	}
+	result, err := detect.Detect(img, detect.Options{Profiles: profiles, DebugDir: *debugDir})
+	if err != nil {
+		fmt.Fprintf(stderr, "capture: %v\n", err)
+		return 1
+	}
+	if err := writeDetectResult(stdout, result); err != nil {
+		fmt.Fprintf(stderr, "capture: writing map: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runFakeGameWindow implements "riverplan capture -fake": it shows the screenshot at path in a
+// window titled like the game until the process is interrupted.
+func runFakeGameWindow(opts capture.Options, path string, stdout, stderr io.Writer) int {
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "capture: %v\n", err)
+		return 1
+	}
+	img, _, err := detect.Decode(file)
+	file.Close()
+	if err != nil {
+		fmt.Fprintf(stderr, "capture: %s: %v\n", path, err)
+		return 1
+	}
+	closeWindow, err := capture.FakeWindow(opts, img)
+	if err != nil {
+		fmt.Fprintf(stderr, "capture: %v\n", err)
+		return 1
+	}
+	defer closeWindow()
+	fmt.Fprintf(stdout, "Showing %s in a window titled %q, interrupt to close.\n", path, opts.Title)
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	<-interrupted
+	return 0
+}
+
+// savePNGFile writes img to path as a PNG file.
+func savePNGFile(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return fmt.Errorf("encoding %s: %w", path, err)
+	}
+	return file.Close()
+}
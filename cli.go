@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"riverplan/detect"
+	"riverplan/game"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// startList collects the repeatable -start flag. "all" means every valid river start.
+type startList struct {
+	starts []game.Coordinate
+	all    bool
+}
+
+func (s *startList) String() string {
+	if s.all {
+		return "all"
+	}
+	var parts []string
+	for _, c := range s.starts {
+		parts = append(parts, fmt.Sprintf("%d,%d", c.X, c.Y))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (s *startList) Set(value string) error {
+	if value == "all" {
+		s.all = true
+		return nil
+	}
+	c, err := parseCellFlag(value)
+	if err != nil {
+		return err
+	}
+	s.starts = append(s.starts, c)
+	return nil
+}
+
+// parseCellFlag parses a cell given as "x,y" on the command line.
+func parseCellFlag(value string) (game.Coordinate, error) {
+	xs, ys, found := strings.Cut(value, ",")
+	if !found {
+		return game.Coordinate{}, fmt.Errorf("cell %q is not x,y", value)
+	}
+	x, err := strconv.Atoi(strings.TrimSpace(xs))
+	if err != nil {
+		return game.Coordinate{}, fmt.Errorf("cell %q: %w", value, err)
+	}
+	y, err := strconv.Atoi(strings.TrimSpace(ys))
+	if err != nil {
+		return game.Coordinate{}, fmt.Errorf("cell %q: %w", value, err)
+	}
+	if x < 0 || x >= game.GridWidth || y < 0 || y >= game.GridHeight {
+		return game.Coordinate{}, fmt.Errorf("cell %q is outside the %dx%d grid", value, game.GridWidth, game.GridHeight)
+	}
+	return game.Coordinate{X: x, Y: y}, nil
+}
+
+// riverMaxLength returns the maximum river length to search: explicit (from a flag or a request,
+// 0 = not given), else the layout's, else defaultInitialRiverLength. An explicit length outside
+// minRiverLength-maxRiverLengthCap is an error. A layout's is ignored instead, like the window
+// does, since plan files from before the cap may hold more.
+func riverMaxLength(explicit, layout int) (int, error) {
+	switch {
+	case explicit != 0:
+		if explicit < minRiverLength || explicit > maxRiverLengthCap {
+			return 0, fmt.Errorf("max length %d is outside %d-%d", explicit, minRiverLength, maxRiverLengthCap)
+		}
+		return explicit, nil
+	case layout >= minRiverLength && layout <= maxRiverLengthCap:
+		return layout, nil
+	default:
+		return defaultInitialRiverLength, nil
+	}
+}
+
+// loadLayout reads a layout for the command line: a JSON plan file, an ASCII map, or a
+// screenshot (see detect.Decode), told apart by content. Screenshots use the window's grid
+// calibrations (see gridProfilesFile). Layouts hold only the road, so of a screenshot only the
+// detected road is kept; ignoredCards is how many detected cards (river, landscape) were dropped,
+// for the caller to report. The window's import keeps them as obstacles instead.
+func loadLayout(path string) (plan game.PlanFile, ignoredCards int, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return game.PlanFile{}, 0, err
+	}
+	if img, format, err := detect.Decode(bytes.NewReader(data)); err == nil {
+		result, err := detect.Detect(img, detect.Options{Profiles: loadGridProfiles()})
+		if err != nil {
+			return game.PlanFile{}, 0, fmt.Errorf("detecting road in %s screenshot: %w", format, err)
+		}
+		for y := 0; y < game.GridHeight; y++ {
+			for x := 0; x < game.GridWidth; x++ {
+				if t := result.Tiles[y][x]; t != game.Empty && t != game.Road && t != game.Forbidden {
+					ignoredCards++
+				}
+			}
+		}
+		return game.PlanFile{Version: game.PlanFileVersion, Roads: result.RoadTiles()}, ignoredCards, nil
+	}
+	plan, err = game.LoadPlan(path)
+	return plan, 0, err
+}
+
+// ruleFlags are the layout and rule flags shared by the solve and batch commands.
+// Flags that are not given leave the rules stored in the layout alone.
+type ruleFlags struct {
+	fs               *flag.FlagSet
+	starts           startList
+	maxLength        *int
+	noCrossAdjacency *bool
+	endBorder        *bool
+	endTarget        *string
+	endRoadDistance  *int
+	objective        *string
+	targetProfit     *float64
+	searchLog        io.Writer // Debug output of the search (see searchLog)
+}
+
+// addRuleFlags registers the rule flags on fs.
+func addRuleFlags(fs *flag.FlagSet) *ruleFlags {
+	r := &ruleFlags{fs: fs}
+	fs.Var(&r.starts, "start", "river start as x,y (repeatable), or \"all\" for every valid start (default: the layout's start, else all)")
+	r.maxLength = fs.Int("max-length", 0, fmt.Sprintf("maximum river length, %d-%d (default: the layout's, else %d)", minRiverLength, maxRiverLengthCap, defaultInitialRiverLength))
+	r.noCrossAdjacency = fs.Bool("no-cross-adjacency", false, "forbid the river from touching itself (DisableCrossRiverAdjacency)")
+	r.endBorder = fs.Bool("end-border", false, "the river must end on the grid border")
+	r.endTarget = fs.String("end-target", "", "the river must end on this cell, x,y")
+	r.endRoadDistance = fs.Int("end-road-distance", 0, "the river must end at least this many tiles from the road")
+	r.objective = fs.String("objective", "", "profit, profit_per_river, profit_per_card, fewest_cards or meadow_healing")
+	r.targetProfit = fs.Float64("target-profit", 0, "profit to reach with -objective fewest_cards, e.g. 0.5 for 50%")
+	return r
+}
+
+// apply overrides the rules of plan with the flags that were given. Call it after fs.Parse.
+func (r *ruleFlags) apply(plan *game.PlanFile) error {
+	set := map[string]bool{}
+	r.fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	explicitLength := 0
+	if set["max-length"] {
+		explicitLength = *r.maxLength
+	}
+	maxLength, err := riverMaxLength(explicitLength, plan.MaxLength)
+	if err != nil {
+		return fmt.Errorf("-max-length: %w", err)
+	}
+	plan.MaxLength = maxLength
+	if set["no-cross-adjacency"] {
+		plan.Rules.DisableCrossRiverAdjacency = *r.noCrossAdjacency
+	}
+	if set["end-border"] {
+		plan.Rules.End.OnBorder = *r.endBorder
+	}
+	if set["end-target"] {
+		plan.Rules.End.Target = nil
+		if *r.endTarget != "" {
+			target, err := parseCellFlag(*r.endTarget)
+			if err != nil {
+				return fmt.Errorf("-end-target: %w", err)
+			}
+			plan.Rules.End.Target = &target
+		}
+	}
+	if set["end-road-distance"] {
+		plan.Rules.End.MinRoadDistance = *r.endRoadDistance
+	}
+	if set["objective"] {
+		if err := plan.Rules.Objective.UnmarshalText([]byte(*r.objective)); err != nil {
+			return fmt.Errorf("-objective: %w", err)
+		}
+	}
+	if set["target-profit"] {
+		plan.Rules.TargetProfit = *r.targetProfit
+	}
+	return nil
+}
+
+// solveOptions returns the game.SolveOptions for plan (after apply). Starts given as flags
+// win over the start stored in the layout.
+func (r *ruleFlags) solveOptions(plan game.PlanFile) game.SolveOptions {
+	opts := game.SolveOptions{
+		Starts:                     r.starts.starts,
+		MaxLength:                  plan.MaxLength,
+		DisableCrossRiverAdjacency: plan.Rules.DisableCrossRiverAdjacency,
+		Search:                     plan.Rules.SearchOptions,
+	}
+	opts.Search.Log = r.searchLog
+	if !r.starts.all && len(r.starts.starts) == 0 && plan.Start != nil {
+		opts.Starts = []game.Coordinate{*plan.Start}
+	}
+	if r.starts.all {
+		opts.Starts = nil
+	}
+	return opts
+}
+
+// searchLog returns where the search's debug output goes: stderr when verbose, nowhere
+// otherwise, because stdout is reserved for results (see game.SearchOptions.Log).
+func searchLog(verbose bool) io.Writer {
+	if verbose {
+		return os.Stderr
+	}
+	return io.Discard
+}
+
+// runSolveCommand implements "riverplan solve [flags] <layout>": it solves a layout without
+// starting Ebiten, prints progress to stderr and writes the solution to stdout.
+// It returns the process exit code.
+func runSolveCommand(args []string) int {
+	stdout, stderr := os.Stdout, os.Stderr
+
+	fs := flag.NewFlagSet("solve", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: riverplan solve [flags] <layout.json | layout.txt | screenshot.png>")
+		fmt.Fprintln(stderr, "Rules not given as flags are taken from the layout file.")
+		fs.PrintDefaults()
+	}
+	rules := addRuleFlags(fs)
+	timeout := fs.Duration("timeout", 0, "stop after this long and print the best solution so far (0 = no limit)")
+	workers := fs.Int("workers", 0, "starts searched in parallel (0 = number of CPUs)")
+	format := fs.String("format", "text", "output format: text (ASCII map) or json (plan file)")
+	verbose := fs.Bool("v", false, "also print the search's debug output to stderr")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(stderr, "solve: unknown format %q (want text or json)\n", *format)
+		return 2
+	}
+
+	plan, ignoredCards, err := loadLayout(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "solve: %v\n", err)
+		return 1
+	}
+	if ignoredCards > 0 {
+		fmt.Fprintf(stderr, "solve: ignoring %d card(s) detected in the screenshot; only its road is solved\n", ignoredCards)
+	}
+	if err := rules.apply(&plan); err != nil {
+		fmt.Fprintf(stderr, "solve: %v\n", err)
+		return 2
+	}
+	rules.searchLog = searchLog(*verbose)
+	opts := rules.solveOptions(plan)
+	opts.Workers = *workers
+
+	stopChannel := make(chan struct{})
+	if *timeout > 0 {
+		timer := time.AfterFunc(*timeout, func() { close(stopChannel) })
+		defer timer.Stop()
+	}
+
+	roads := plan.RoadGrid()
+	startDescription := "all valid starts"
+	if len(opts.Starts) > 0 {
+		startDescription = fmt.Sprintf("%d start(s)", len(opts.Starts))
+	}
+	fmt.Fprintf(stderr, "Solving %d road tiles from %s, max length %d, objective %s, end %s\n",
+		len(plan.Roads), startDescription, opts.MaxLength, opts.Search.Objective, opts.Search.End)
+	calculationStart := time.Now()
+	best, err := game.Solve(roads, opts, func(p game.SolveProgress) {
+		if p.Improved {
+			fmt.Fprintf(stderr, "[%d/%d] %s: new best from %v at length %d: %.2f%% (%s), river %d tiles\n",
+				p.Done, p.Total, time.Since(calculationStart).Round(time.Millisecond), p.Start, p.Length, p.Best.Profit*100, opts.Search.FormatScore(p.Best), len(p.Best.Path))
+		} else if p.Done%25 == 0 || p.Done == p.Total {
+			fmt.Fprintf(stderr, "[%d/%d] %s\n", p.Done, p.Total, time.Since(calculationStart).Round(time.Millisecond))
+		}
+	}, stopChannel)
+	select {
+	case <-stopChannel:
+		fmt.Fprintf(stderr, "Time limit of %s reached; using the best solution so far.\n", *timeout)
+	default:
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "solve: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stderr, "Done in %s. Profit: %.2f%% (%s), river %d tiles, %s\n",
+		time.Since(calculationStart).Round(time.Millisecond), best.Profit*100, opts.Search.FormatScore(best), len(best.Path), best.Stats)
+
+	solvedPlan(&plan, best)
+	if err := writeSolveOutput(stdout, plan, best, *format); err != nil {
+		fmt.Fprintf(stderr, "solve: writing output: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// solvedPlan stores best as the solution of plan, with its first river tile as the start.
+func solvedPlan(plan *game.PlanFile, best game.RiverPathSolution) {
+	start := best.Path[0]
+	plan.Start = &start
+	plan.Solution = &game.PlanSolution{Path: best.Path, Profit: best.Profit, Stats: best.Stats, Grid: best.Grid}
+}
+
+// writeSolveOutput writes a solved plan to w as an ASCII map (with the profit as trailing
+// comment lines) or as a JSON plan file.
+func writeSolveOutput(w io.Writer, plan game.PlanFile, best game.RiverPathSolution, format string) error {
+	if format == "json" {
+		return game.WritePlanFile(w, plan)
+	}
+	if err := game.WriteASCIIMap(w, plan); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "# profit %.2f%%, river %d tiles, %d cards\n# stats %s\n", best.Profit*100, len(best.Path), best.Cards, best.Stats)
+	if plan.Rules.Objective != game.ObjectiveProfit {
+		fmt.Fprintf(w, "# score %s\n", plan.Rules.FormatScore(best))
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package detect
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io/fs"
+	"os"
+	"riverplan/game"
+)
+
+// maxCalibrationSkew is how far the tiles of a calibration may be from square: 0.25 accepts
+// tile widths between 0.75 and 1.25 times the tile height.
+const maxCalibrationSkew = 0.25
+
+// Profiles are grid rectangles calibrated by hand, keyed by screenshot resolution (see
+// ResolutionKey). Screenshots with a profile skip Locate and are cropped at the profile.
+// Rectangles are relative to the top-left of the screenshot.
+type Profiles map[string]image.Rectangle
+
+// ResolutionKey returns the key of a screenshot's resolution in Profiles, e.g. "2560x1440".
+func ResolutionKey(bounds image.Rectangle) string {
+	return fmt.Sprintf("%dx%d", bounds.Dx(), bounds.Dy())
+}
+
+// Calibrate turns two grid corners picked by hand into a Location: the outer top-left corner
+// of the top-left tile and the outer bottom-right corner of the bottom-right tile, in image
+// coordinates and in any order. It fails when the corners cannot span 21x12 square tiles.
+func Calibrate(bounds image.Rectangle, a, b image.Point) (Location, error) {
+	rect := image.Rectangle{Min: a, Max: b}.Canon().Intersect(bounds)
+	if rect.Dx() < game.GridWidth*minTilePitch || rect.Dy() < game.GridHeight*minTilePitch {
+		return Location{}, fmt.Errorf("the corners span only %dx%d pixels, too small for a %dx%d grid", rect.Dx(), rect.Dy(), game.GridWidth, game.GridHeight)
+	}
+	cellWidth := float64(rect.Dx()) / game.GridWidth
+	cellHeight := float64(rect.Dy()) / game.GridHeight
+	if skew := cellWidth/cellHeight - 1; skew > maxCalibrationSkew || skew < -maxCalibrationSkew {
+		return Location{}, fmt.Errorf("the corners give %.1fx%.1f pixel tiles, but tiles are square; pick the outer corners of the whole grid", cellWidth, cellHeight)
+	}
+	return Location{Rect: rect, Pitch: (cellWidth + cellHeight) / 2, Method: "calibration"}, nil
+}
+
+// Set stores loc as the profile of screenshots with the given bounds.
+func (p Profiles) Set(bounds image.Rectangle, loc Location) {
+	p[ResolutionKey(bounds)] = loc.Rect.Sub(bounds.Min)
+}
+
+// locate returns the profile of img's resolution as a Location, if there is one that fits.
+func (p Profiles) locate(img image.Image) (Location, bool) {
+	bounds := img.Bounds()
+	rect, ok := p[ResolutionKey(bounds)]
+	if !ok {
+		return Location{}, false
+	}
+	rect = rect.Add(bounds.Min)
+	if rect.Empty() || !rect.In(bounds) {
+		return Location{}, false
+	}
+	pitch := (float64(rect.Dx())/game.GridWidth + float64(rect.Dy())/game.GridHeight) / 2
+	return Location{Rect: rect, Pitch: pitch, Method: "calibration"}, true
+}
+
+// LoadProfiles reads profiles written by SaveProfiles. A missing file is no profiles.
+func LoadProfiles(path string) (Profiles, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Profiles{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	profiles := Profiles{}
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("reading grid profiles %s: %w", path, err)
+	}
+	return profiles, nil
+}
+
+// SaveProfiles writes profiles as JSON.
+func SaveProfiles(path string, profiles Profiles) error {
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,376 @@
+package detect
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"os"
+	"riverplan/game"
+	"slices"
+	"sort"
+)
+
+const (
+	// tileSampleSteps is how many pixels per row and column are sampled from the inner part of a tile.
+	tileSampleSteps = 12
+	// tileInnerMargin is the fraction of a tile's side skipped at each edge, so neighbours
+	// and the tile border do not vote.
+	tileInnerMargin = 0.2
+	// cardVoteMargin is how much more of a bright tile's pixels a card must win than the road
+	// palette before the tile is read as that card instead of road.
+	cardVoteMargin = 0.2
+	// paletteSize is the number of colours Train keeps per tile type.
+	paletteSize = 3
+	// trainIterations is the number of k-means rounds Train runs per palette.
+	trainIterations = 10
+)
+
+// Color is an RGB colour of a reference palette. It is written as "#rrggbb".
+type Color [3]uint8
+
+// MarshalText encodes c as "#rrggbb".
+func (c Color) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("#%02x%02x%02x", c[0], c[1], c[2])), nil
+}
+
+// UnmarshalText decodes a colour written by MarshalText.
+func (c *Color) UnmarshalText(text []byte) error {
+	if _, err := fmt.Sscanf(string(text), "#%02x%02x%02x", &c[0], &c[1], &c[2]); err != nil || len(text) != 7 {
+		return fmt.Errorf("invalid colour %q, expected #rrggbb", text)
+	}
+	return nil
+}
+
+// References are the palettes the classifier matches tiles against: a few typical colours of
+// every tile type's sprite. Every sampled pixel of a tile votes for the type owning the nearest
+// colour, so the vote is a colour histogram of the tile over the tile types.
+type References map[game.TileType][]Color
+
+// defaultPalettes are the sprite colours used when no trained references are given. They were
+// picked by eye and are only a starting point; "riverplan detect -train" builds better ones
+// from labelled screenshots.
+var defaultPalettes = References{
+	game.Empty:    {{60, 64, 56}, {46, 50, 44}},
+	game.Road:     {{150, 132, 100}, {120, 104, 80}},
+	game.River:    {{54, 96, 140}, {80, 130, 170}},
+	game.Forest:   {{30, 64, 34}, {52, 84, 40}},
+	game.Thicket:  {{70, 90, 30}, {96, 110, 44}},
+	game.Rock:     {{120, 118, 114}, {92, 90, 88}},
+	game.Mountain: {{110, 92, 74}, {80, 66, 56}},
+	game.Meadow:   {{92, 140, 60}, {120, 160, 80}},
+}
+
+// DefaultReferences returns a copy of the built-in palettes.
+func DefaultReferences() References {
+	return defaultPalettes.merge(nil)
+}
+
+// merge returns a copy of r with the palettes of other replacing r's for the types other has.
+func (r References) merge(other References) References {
+	merged := References{}
+	for tileType, palette := range r {
+		merged[tileType] = append([]Color(nil), palette...)
+	}
+	for tileType, palette := range other {
+		if len(palette) > 0 {
+			merged[tileType] = append([]Color(nil), palette...)
+		}
+	}
+	return merged
+}
+
+// LoadReferences reads references written by SaveReferences.
+func LoadReferences(path string) (References, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var refs References
+	if err := json.Unmarshal(data, &refs); err != nil {
+		return nil, fmt.Errorf("reading references %s: %w", path, err)
+	}
+	return refs, nil
+}
+
+// SaveReferences writes refs as JSON, keyed by tile type name.
+func SaveReferences(path string, refs References) error {
+	data, err := json.MarshalIndent(refs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// measurements keeps what Classify measured, for WriteDebugDump.
+type measurements struct {
+	referenceBrightness float64
+	threshold           float64
+	cellWidth           float64
+	cellHeight          float64
+	features            [game.GridHeight][game.GridWidth]tileFeatures
+	votes               [game.GridHeight][game.GridWidth]map[game.TileType]float64
+}
+
+// tileFeatures is what the classifier measures in one tile.
+type tileFeatures struct {
+	brightness float64 // Average brightness of the tile's sample square (see tileSampleRect)
+	pixels     []Color // Pixels sampled from the inner part of the tile
+	mean       Color   // Average of pixels
+}
+
+// measureTile samples tile (x, y) of a cropped grid.
+func measureTile(img image.Image, x, y int, cellWidth, cellHeight float64) tileFeatures {
+	bounds := img.Bounds()
+	features := tileFeatures{brightness: AverageBrightness(img, tileSampleRect(x, y, cellWidth, cellHeight))}
+	var sum [3]float64
+	for sy := 0; sy < tileSampleSteps; sy++ {
+		for sx := 0; sx < tileSampleSteps; sx++ {
+			fx := tileInnerMargin + (1-2*tileInnerMargin)*(float64(sx)+0.5)/tileSampleSteps
+			fy := tileInnerMargin + (1-2*tileInnerMargin)*(float64(sy)+0.5)/tileSampleSteps
+			px := bounds.Min.X + int((float64(x)+fx)*cellWidth)
+			py := bounds.Min.Y + int((float64(y)+fy)*cellHeight)
+			if px >= bounds.Max.X || py >= bounds.Max.Y {
+				continue
+			}
+			r, g, b, _ := img.At(px, py).RGBA()
+			c := Color{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)}
+			features.pixels = append(features.pixels, c)
+			for i := range sum {
+				sum[i] += float64(c[i])
+			}
+		}
+	}
+	if n := float64(len(features.pixels)); n > 0 {
+		features.mean = Color{uint8(sum[0] / n), uint8(sum[1] / n), uint8(sum[2] / n)}
+	}
+	return features
+}
+
+// colorDistance returns the squared RGB distance between a and b.
+func colorDistance(a, b Color) int {
+	var d int
+	for i := range a {
+		diff := int(a[i]) - int(b[i])
+		d += diff * diff
+	}
+	return d
+}
+
+// votes returns the share of pixels whose nearest palette colour belongs to each tile type.
+func (r References) votes(pixels []Color) map[game.TileType]float64 {
+	shares := map[game.TileType]float64{}
+	if len(pixels) == 0 {
+		return shares
+	}
+	for _, pixel := range pixels {
+		bestType, bestDistance := game.Empty, math.MaxInt
+		for tileType, palette := range r {
+			for _, c := range palette {
+				if d := colorDistance(pixel, c); d < bestDistance || (d == bestDistance && tileType < bestType) {
+					bestType, bestDistance = tileType, d
+				}
+			}
+		}
+		shares[bestType] += 1 / float64(len(pixels))
+	}
+	return shares
+}
+
+// bestVotes returns the two types with the most votes among those allowed (ties go to the lower type).
+func bestVotes(shares map[game.TileType]float64, allowed func(game.TileType) bool) (best, second game.TileType, bestShare, secondShare float64) {
+	best, second = game.Empty, game.Empty
+	bestShare, secondShare = -1, -1
+	for tileType := game.Empty; tileType <= game.MountainPeak; tileType++ {
+		if !allowed(tileType) {
+			continue
+		}
+		share := shares[tileType]
+		if share > bestShare {
+			second, secondShare = best, bestShare
+			best, bestShare = tileType, share
+		} else if share > secondShare {
+			second, secondShare = tileType, share
+		}
+	}
+	return best, second, bestShare, math.Max(secondShare, 0)
+}
+
+// isCard reports whether t is a card the classifier can read (not Empty, Road or a derived type).
+func isCard(t game.TileType) bool {
+	return t != game.Empty && t != game.Road && t != game.Forbidden && t != game.MountainPeak
+}
+
+// Classify classifies every tile of a cropped grid.
+//
+// Roads keep the brightness rule: a tile is road when its sample is brighter than the median
+// tile by more than the brightness threshold, with a confidence that grows with the distance
+// from the threshold. Every other tile is read from its colours (see References): the type with
+// the most pixel votes wins and the confidence is its lead over the runner-up. A bright tile is
+// only read as a card (rocks and meadows are bright too) when the card clearly out-votes the road.
+// The Empty palette is extended with the typical colour of the dark tiles of this screenshot,
+// so the bare ground is matched under any lighting.
+//
+// Afterwards the board is completed like the game does: Empty tiles next to the road become
+// Forbidden and 3x3 rock and mountain formations become Mountain Peaks.
+func Classify(img image.Image, opts Options) (Result, error) {
+	bounds := img.Bounds()
+	imgWidth := float64(bounds.Dx())
+	imgHeight := float64(bounds.Dy())
+
+	cellWidth := imgWidth / float64(game.GridWidth)
+	cellHeight := imgHeight / float64(game.GridHeight)
+
+	if cellWidth <= 0 || cellHeight <= 0 {
+		return Result{}, fmt.Errorf("image dimensions (%dx%d) result in zero or negative cell size", bounds.Dx(), bounds.Dy())
+	}
+
+	var features [game.GridHeight][game.GridWidth]tileFeatures
+	var brightnesses []float64
+	for y := 0; y < game.GridHeight; y++ {
+		for x := 0; x < game.GridWidth; x++ {
+			features[y][x] = measureTile(img, x, y, cellWidth, cellHeight)
+			brightnesses = append(brightnesses, features[y][x].brightness)
+		}
+	}
+	sort.Float64s(brightnesses)
+	referenceBrightness := brightnesses[len(brightnesses)/2]
+	threshold := opts.brightnessThreshold()
+
+	// Self-calibrate the ground: the median colour of the tiles that are not bright enough to be road
+	refs := opts.references()
+	var darkMeans [3][]int
+	for y := 0; y < game.GridHeight; y++ {
+		for x := 0; x < game.GridWidth; x++ {
+			if features[y][x].brightness-referenceBrightness <= threshold {
+				for i := range darkMeans {
+					darkMeans[i] = append(darkMeans[i], int(features[y][x].mean[i]))
+				}
+			}
+		}
+	}
+	if len(darkMeans[0]) > 0 {
+		var ground Color
+		for i := range darkMeans {
+			sort.Ints(darkMeans[i])
+			ground[i] = uint8(darkMeans[i][len(darkMeans[i])/2])
+		}
+		refs[game.Empty] = append(refs[game.Empty], ground)
+		log.Printf("Classify: reference brightness %.1f, ground colour %v", referenceBrightness, ground)
+	}
+
+	result := Result{Cropped: img, Crop: bounds}
+	m := &measurements{referenceBrightness: referenceBrightness, threshold: threshold, cellWidth: cellWidth, cellHeight: cellHeight, features: features}
+	result.measurements = m
+	var roads []game.Coordinate
+	for y := 0; y < game.GridHeight; y++ {
+		for x := 0; x < game.GridWidth; x++ {
+			shares := refs.votes(features[y][x].pixels)
+			m.votes[y][x] = shares
+			difference := features[y][x].brightness - referenceBrightness
+			if difference > threshold {
+				card, _, cardShare, _ := bestVotes(shares, isCard)
+				if cardShare > shares[game.Road]+cardVoteMargin {
+					result.Tiles[y][x] = card
+					result.Confidence[y][x] = math.Min(1, cardShare-shares[game.Road])
+					continue
+				}
+				roads = append(roads, game.Coordinate{X: x, Y: y})
+				result.Confidence[y][x] = math.Min(1, (difference-threshold)/threshold)
+				continue
+			}
+			best, _, bestShare, secondShare := bestVotes(shares, func(t game.TileType) bool { return t == game.Empty || isCard(t) })
+			result.Tiles[y][x] = best
+			// A dark tile is also judged by the road rule, so it is never surer than its distance from the threshold
+			result.Confidence[y][x] = math.Min(bestShare-secondShare, math.Min(1, (threshold-difference)/threshold))
+		}
+	}
+
+	// SetRoad places the road and marks its Empty neighbours as Forbidden, leaving the cards alone
+	result.Tiles.SetRoad(roads)
+	peaks := result.Tiles.MarkMountainPeaks()
+	log.Printf("Classify: %d road tiles, %d mountain peaks", len(roads), len(peaks))
+	return result, nil
+}
+
+// Sample is a labelled screenshot for Train: a cropped grid and the board it shows.
+type Sample struct {
+	Cropped image.Image
+	Labels  game.Grid
+}
+
+// Train builds references from labelled samples: the pixels of every labelled tile are
+// collected per type and reduced to a small palette with k-means. Forbidden tiles count as
+// Empty (they look like bare ground) and Mountain Peak tiles are skipped. Types without any
+// labelled tile keep their default palette.
+func Train(samples []Sample) References {
+	pixelsByType := map[game.TileType][]Color{}
+	for _, sample := range samples {
+		bounds := sample.Cropped.Bounds()
+		cellWidth := float64(bounds.Dx()) / float64(game.GridWidth)
+		cellHeight := float64(bounds.Dy()) / float64(game.GridHeight)
+		for y := 0; y < game.GridHeight; y++ {
+			for x := 0; x < game.GridWidth; x++ {
+				tileType := sample.Labels[y][x]
+				switch tileType {
+				case game.Forbidden:
+					tileType = game.Empty
+				case game.MountainPeak:
+					continue // Made of rocks, mountains or both, so it would blur either palette
+				}
+				pixelsByType[tileType] = append(pixelsByType[tileType], measureTile(sample.Cropped, x, y, cellWidth, cellHeight).pixels...)
+			}
+		}
+	}
+	trained := References{}
+	for tileType, pixels := range pixelsByType {
+		trained[tileType] = kMeansPalette(pixels, paletteSize)
+	}
+	return defaultPalettes.merge(trained)
+}
+
+// kMeansPalette reduces pixels to at most k colours. The centres start at evenly spaced
+// brightness quantiles, so the result does not depend on chance.
+func kMeansPalette(pixels []Color, k int) []Color {
+	if len(pixels) == 0 {
+		return nil
+	}
+	sorted := append([]Color(nil), pixels...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return int(sorted[i][0])+int(sorted[i][1])+int(sorted[i][2]) < int(sorted[j][0])+int(sorted[j][1])+int(sorted[j][2])
+	})
+	k = min(k, len(sorted))
+	centres := make([]Color, k)
+	for i := range centres {
+		centres[i] = sorted[(2*i+1)*len(sorted)/(2*k)]
+	}
+	for iteration := 0; iteration < trainIterations; iteration++ {
+		sums := make([][4]int, k) // R, G, B and count per centre
+		for _, pixel := range sorted {
+			nearest := 0
+			for i := range centres {
+				if colorDistance(pixel, centres[i]) < colorDistance(pixel, centres[nearest]) {
+					nearest = i
+				}
+			}
+			for c := 0; c < 3; c++ {
+				sums[nearest][c] += int(pixel[c])
+			}
+			sums[nearest][3]++
+		}
+		for i := range centres {
+			if n := sums[i][3]; n > 0 {
+				centres[i] = Color{uint8(sums[i][0] / n), uint8(sums[i][1] / n), uint8(sums[i][2] / n)}
+			}
+		}
+	}
+	// Centres that ended up on the same colour (e.g. a single-coloured sprite) are kept once
+	var palette []Color
+	for _, centre := range centres {
+		if !slices.Contains(palette, centre) {
+			palette = append(palette, centre)
+		}
+	}
+	return palette
+}
@@ -0,0 +1,182 @@
+package detect
+
+import (
+	"encoding/csv"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"riverplan/game"
+	"strconv"
+	"time"
+)
+
+// Colours of the debug dump images.
+var (
+	debugCropColor      = color.RGBA{R: 255, G: 0, B: 0, A: 255}   // Grid rectangle on the source
+	debugSampleColor    = color.RGBA{R: 255, G: 255, B: 0, A: 255} // Brightness sample squares
+	debugColorAreaColor = color.RGBA{R: 0, G: 255, B: 255, A: 255} // Area the colour votes are sampled from
+	debugDoubtfulColor  = color.RGBA{R: 255, G: 0, B: 0, A: 255}   // Frame of tiles below LowConfidence
+	debugTintAlpha      = color.Alpha{A: 110}                      // Opacity of the tile colours on classification.png
+)
+
+// Line widths of the debug dump images, in pixels.
+const (
+	debugCropLineWidth   = 3
+	debugSampleLineWidth = 1
+)
+
+// debugVoteTypes are the tile types with a vote column in tiles.csv, in column order.
+var debugVoteTypes = []game.TileType{game.Empty, game.Road, game.River, game.Forest, game.Thicket, game.Rock, game.Mountain, game.Meadow}
+
+// WriteDebugDump writes everything needed to see why a detection run read each tile the way it
+// did into a new folder under dir, named after the time of the run, and returns that folder:
+//
+//   - crop.png: the source screenshot with the grid rectangle in red (only with a source);
+//   - samples.png: the cropped grid with every tile's brightness sample square (yellow, see
+//     AverageBrightness) and the area its colour votes come from (cyan);
+//   - tiles.csv: per tile, the sample square, its brightness, the reference brightness and
+//     threshold of the road rule, the mean colour, the share of votes of every tile type, and
+//     the final type and confidence;
+//   - classification.png: the cropped grid tinted with the classified tile colours, with the
+//     tiles below LowConfidence framed in red.
+//
+// It needs a result of Classify (or Detect).
+func WriteDebugDump(dir string, result Result) (string, error) {
+	m := result.measurements
+	if m == nil || result.Cropped == nil {
+		return "", fmt.Errorf("the result has no measurements to dump")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("creating debug directory: %w", err)
+	}
+	folder, err := os.MkdirTemp(dir, "run-"+time.Now().Format("20060102-150405")+"-")
+	if err != nil {
+		return "", fmt.Errorf("creating debug folder: %w", err)
+	}
+
+	if result.Source != nil {
+		source := copyRGBA(result.Source)
+		strokeRect(source, result.Crop.Sub(result.Source.Bounds().Min), debugCropLineWidth, debugCropColor)
+		if err := savePNG(filepath.Join(folder, "crop.png"), source); err != nil {
+			return folder, err
+		}
+	}
+
+	samples := copyRGBA(result.Cropped)
+	tinted := copyRGBA(result.Cropped)
+	for y := 0; y < game.GridHeight; y++ {
+		for x := 0; x < game.GridWidth; x++ {
+			strokeRect(samples, tileSampleRect(x, y, m.cellWidth, m.cellHeight).Inset(-debugSampleLineWidth), debugSampleLineWidth, debugSampleColor)
+			strokeRect(samples, tileColorArea(x, y, m.cellWidth, m.cellHeight), debugSampleLineWidth, debugColorAreaColor)
+
+			tile := tileRect(x, y, m.cellWidth, m.cellHeight)
+			draw.DrawMask(tinted, tile, image.NewUniform(result.Tiles.TileColor(game.Coordinate{X: x, Y: y})), image.Point{}, image.NewUniform(debugTintAlpha), image.Point{}, draw.Over)
+			if result.Confidence[y][x] < LowConfidence {
+				strokeRect(tinted, tile.Inset(1), 2, debugDoubtfulColor)
+			}
+		}
+	}
+	if err := savePNG(filepath.Join(folder, "samples.png"), samples); err != nil {
+		return folder, err
+	}
+	if err := savePNG(filepath.Join(folder, "classification.png"), tinted); err != nil {
+		return folder, err
+	}
+	return folder, writeDebugCSV(filepath.Join(folder, "tiles.csv"), result)
+}
+
+// writeDebugCSV writes the tiles.csv of WriteDebugDump.
+func writeDebugCSV(path string, result Result) error {
+	m := result.measurements
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(file)
+	header := []string{"x", "y", "sample_x0", "sample_y0", "sample_x1", "sample_y1", "brightness", "reference", "difference", "threshold", "road_rule", "mean_color"}
+	for _, t := range debugVoteTypes {
+		name, _ := t.MarshalText()
+		header = append(header, "votes_"+string(name))
+	}
+	header = append(header, "type", "confidence")
+	w.Write(header)
+
+	formatFloat := func(f float64) string { return strconv.FormatFloat(f, 'f', 3, 64) }
+	for y := 0; y < game.GridHeight; y++ {
+		for x := 0; x < game.GridWidth; x++ {
+			features := m.features[y][x]
+			sample := tileSampleRect(x, y, m.cellWidth, m.cellHeight)
+			difference := features.brightness - m.referenceBrightness
+			mean, _ := features.mean.MarshalText()
+			record := []string{
+				strconv.Itoa(x), strconv.Itoa(y),
+				strconv.Itoa(sample.Min.X), strconv.Itoa(sample.Min.Y), strconv.Itoa(sample.Max.X), strconv.Itoa(sample.Max.Y),
+				formatFloat(features.brightness), formatFloat(m.referenceBrightness), formatFloat(difference), formatFloat(m.threshold),
+				strconv.FormatBool(difference > m.threshold), string(mean),
+			}
+			for _, t := range debugVoteTypes {
+				record = append(record, formatFloat(m.votes[y][x][t]))
+			}
+			name, _ := result.Tiles[y][x].MarshalText()
+			record = append(record, string(name), formatFloat(result.Confidence[y][x]))
+			w.Write(record)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// tileRect returns tile (x, y) of a cropped grid, relative to its top-left.
+func tileRect(x, y int, cellWidth, cellHeight float64) image.Rectangle {
+	return image.Rect(int(float64(x)*cellWidth), int(float64(y)*cellHeight), int(float64(x+1)*cellWidth), int(float64(y+1)*cellHeight))
+}
+
+// tileColorArea returns the inner part of tile (x, y) that measureTile samples for the colour votes.
+func tileColorArea(x, y int, cellWidth, cellHeight float64) image.Rectangle {
+	return image.Rect(
+		int((float64(x)+tileInnerMargin)*cellWidth), int((float64(y)+tileInnerMargin)*cellHeight),
+		int((float64(x+1)-tileInnerMargin)*cellWidth), int((float64(y+1)-tileInnerMargin)*cellHeight),
+	)
+}
+
+// copyRGBA copies img into a new RGBA image whose top-left is (0, 0).
+func copyRGBA(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+	return dst
+}
+
+// strokeRect draws the outline of r, width pixels wide on its inside.
+func strokeRect(img *image.RGBA, r image.Rectangle, width int, c color.Color) {
+	fill := image.NewUniform(c)
+	for _, edge := range []image.Rectangle{
+		image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+width),
+		image.Rect(r.Min.X, r.Max.Y-width, r.Max.X, r.Max.Y),
+		image.Rect(r.Min.X, r.Min.Y, r.Min.X+width, r.Max.Y),
+		image.Rect(r.Max.X-width, r.Min.Y, r.Max.X, r.Max.Y),
+	} {
+		draw.Draw(img, edge.Intersect(img.Bounds()), fill, image.Point{}, draw.Src)
+	}
+}
+
+// savePNG writes img as a PNG file.
+func savePNG(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return fmt.Errorf("encoding %s: %w", filepath.Base(path), err)
+	}
+	return file.Close()
+}
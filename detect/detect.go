@@ -0,0 +1,269 @@
+// Package detect reads the Loop Hero road layout out of a screenshot: it decodes the image,
+// finds and crops the 12x21 grid and classifies every tile, with a confidence per tile.
+// It does not depend on the window, so the file and clipboard buttons and the command-line
+// tools all share the same pipeline.
+package detect
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg" // Steam and many capture tools save JPEG
+	_ "image/png"  // Screenshots are PNG
+	"io"
+	"log"
+	"math"
+	"os"
+	"riverplan/game"
+
+	_ "golang.org/x/image/bmp"  // Windows capture tools and the clipboard
+	_ "golang.org/x/image/webp" // Browser and Discord captures
+)
+
+const (
+	// DefaultBrightnessThreshold is the amount by which a tile's brightness must exceed the
+	// reference tile's brightness to be considered a road.
+	DefaultBrightnessThreshold = 15.0
+
+	// LowConfidence is the tile confidence below which a classification is treated as doubtful.
+	LowConfidence = 0.5
+
+	// Anchor colors for grid detection
+	anchorDarkGrayR      = 58  // Reverted to user-specified #3A3F3F for top-left
+	anchorDarkGrayG      = 63  // Reverted to user-specified #3A3F3F for top-left
+	anchorDarkGrayB      = 63  // Reverted to user-specified #3A3F3F for top-left
+	anchorGreenPatternR  = 142 // User-specified #8E8F7B for top-right
+	anchorGreenPatternG  = 143 // User-specified #8E8F7B for top-right
+	anchorGreenPatternB  = 123 // User-specified #8E8F7B for top-right
+	anchorColorThreshold = 30  // Threshold for matching anchor colors (can be tuned)
+
+	// Percentage-based grid coordinates, the last resort of Locate (derived from 2560x1440 example)
+	// Top-left X of grid = 0px -> 0%
+	// Top-left Y of grid = 100px -> 100/1440 = 6.944%
+	// Top-right X of grid = 2099px -> 2099/2560 = 81.99%
+	// Grid bottom Y: TopY_px + ( (TopRightX_px - TopLeftX_px)/21 tiles * 12 tiles ) = 100 + ( (2099-0)/21 * 12 ) = 100 + 1199.428 = 1299.428px
+	//                 1299.428px / 1440px height = 90.2379...%
+	gridStartXPercent  = 0.0
+	gridStartYPercent  = 0.0694444444
+	gridEndXPercent    = 0.819921875
+	gridBottomYPercent = 0.9023791667
+
+	// sampleAreaSize is the side in pixels of the square sampled in every tile.
+	sampleAreaSize = 4
+)
+
+// Options configures Detect. The zero value uses the defaults.
+type Options struct {
+	BrightnessThreshold float64    // 0 means DefaultBrightnessThreshold
+	References          References // Tile palettes; types missing here use DefaultReferences
+	Profiles            Profiles   // Hand-calibrated grid rectangles, used instead of Locate when the resolution matches
+	DebugDir            string     // If set, every run writes a debug dump into a new folder here (see WriteDebugDump)
+}
+
+// references returns the effective palettes, a copy Classify may extend.
+func (o *Options) references() References {
+	return defaultPalettes.merge(o.References)
+}
+
+// brightnessThreshold returns the effective road brightness threshold.
+func (o *Options) brightnessThreshold() float64 {
+	if o.BrightnessThreshold <= 0 {
+		return DefaultBrightnessThreshold
+	}
+	return o.BrightnessThreshold
+}
+
+// Result is the outcome of one detection run.
+type Result struct {
+	Source     image.Image     // The full screenshot (nil when only a cropped grid was classified)
+	Crop       image.Rectangle // Grid rectangle in the source image
+	CropMethod string          // How the grid was found (see Location.Method)
+	Cropped    image.Image     // The grid cut out of the source image
+	// Tiles holds the whole board as read: road, cards, and Forbidden around the road as game.Grid.SetRoad marks it.
+	Tiles game.Grid
+	// Confidence is how sure the classification of each tile is, from 0 (a guess) to 1.
+	Confidence [game.GridHeight][game.GridWidth]float64
+	DebugDump  string // Folder written for Options.DebugDir, if any
+
+	measurements *measurements // What Classify measured, for WriteDebugDump
+}
+
+// RoadTiles returns the tiles classified as road, row by row.
+func (r *Result) RoadTiles() []game.Coordinate {
+	return r.Tiles.RoadTiles()
+}
+
+// MinConfidence returns the lowest confidence over all tiles.
+func (r *Result) MinConfidence() float64 {
+	lowest := 1.0
+	for y := 0; y < game.GridHeight; y++ {
+		for x := 0; x < game.GridWidth; x++ {
+			lowest = math.Min(lowest, r.Confidence[y][x])
+		}
+	}
+	return lowest
+}
+
+// Decode decodes a screenshot in any format registered with the image package: PNG, JPEG, BMP
+// and WebP. The format is told from the content, not the file name.
+func Decode(r io.Reader) (image.Image, string, error) {
+	img, format, err := image.Decode(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("decoding image: %w", err)
+	}
+	return img, format, nil
+}
+
+// DetectFile decodes the screenshot at path and runs Detect on it.
+func DetectFile(path string, opts Options) (Result, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Result{}, err
+	}
+	defer file.Close()
+	img, format, err := Decode(file)
+	if err != nil {
+		return Result{}, err
+	}
+	log.Printf("Decoded %s screenshot %s", format, path)
+	return Detect(img, opts)
+}
+
+// DetectBytes decodes an encoded screenshot (e.g. from the clipboard) and runs Detect on it.
+func DetectBytes(data []byte, opts Options) (Result, error) {
+	img, format, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		return Result{}, err
+	}
+	log.Printf("Decoded %s screenshot (%d bytes)", format, len(data))
+	return Detect(img, opts)
+}
+
+// Detect crops the grid out of a full screenshot and classifies its tiles.
+func Detect(fullImg image.Image, opts Options) (Result, error) {
+	cropped, loc, err := cropGrid(fullImg, opts.Profiles)
+	if err != nil {
+		return Result{}, err
+	}
+	result, err := Classify(cropped, opts)
+	if err != nil {
+		return Result{}, err
+	}
+	result.Source = fullImg
+	result.Crop = loc.Rect
+	result.CropMethod = loc.Method
+	if opts.DebugDir != "" {
+		// A failed dump is logged only; it must not cost the user the detection itself
+		if folder, err := WriteDebugDump(opts.DebugDir, result); err != nil {
+			log.Printf("Error writing detection debug dump: %v", err)
+		} else {
+			result.DebugDump = folder
+		}
+	}
+	return result, nil
+}
+
+// CropGrid finds the 12x21 game grid within a larger image (see Locate) and returns the cropped grid.
+func CropGrid(fullImage image.Image) (image.Image, Location, error) {
+	return cropGrid(fullImage, nil)
+}
+
+// cropGrid is CropGrid with the calibration profiles tried before Locate.
+func cropGrid(fullImage image.Image, profiles Profiles) (image.Image, Location, error) {
+	imgBounds := fullImage.Bounds()
+	loc, ok := profiles.locate(fullImage)
+	if !ok {
+		var err error
+		loc, err = Locate(fullImage)
+		if err != nil {
+			return nil, Location{}, err
+		}
+	}
+	cropRect := loc.Rect
+
+	log.Printf("Calculated crop rectangle: %+v by %s (from ImgSize: %dx%d)", cropRect, loc.Method, imgBounds.Dx(), imgBounds.Dy())
+
+	// Every locator keeps the rectangle inside the image, so this only catches rounding errors
+	if !cropRect.In(imgBounds) {
+		return nil, Location{}, fmt.Errorf("calculated crop rectangle %+v is outside image bounds %+v", cropRect, imgBounds)
+	}
+
+	// Crop the image
+	subImager, ok := fullImage.(interface {
+		SubImage(r image.Rectangle) image.Image
+	})
+	if !ok {
+		return nil, Location{}, fmt.Errorf("image type does not support SubImage operation")
+	}
+	croppedImage := subImager.SubImage(cropRect)
+	log.Printf("Successfully cropped grid. Original: %dx%d, CropRect: %+v, Cropped: %dx%d",
+		imgBounds.Dx(), imgBounds.Dy(), cropRect, croppedImage.Bounds().Dx(), croppedImage.Bounds().Dy())
+	return croppedImage, loc, nil
+}
+
+// tileSampleRect returns the square sampled in tile (x, y) of a cropped grid, relative to its top-left.
+func tileSampleRect(x, y int, cellWidth, cellHeight float64) image.Rectangle {
+	sampleCX := int((float64(x) + 0.3) * cellWidth)
+	sampleCY := int((float64(y) + 0.3) * cellHeight)
+	return image.Rect(
+		sampleCX-sampleAreaSize/2,
+		sampleCY-sampleAreaSize/2,
+		sampleCX+sampleAreaSize/2,
+		sampleCY+sampleAreaSize/2,
+	)
+}
+
+// AverageBrightness calculates the average brightness of pixels within a given rectangle in an image.
+// The relativeRect's coordinates are 0-indexed relative to the logical top-left of the img's content.
+func AverageBrightness(img image.Image, relativeRect image.Rectangle) float64 {
+	var totalBrightness float64
+	var count int
+
+	imgBounds := img.Bounds()
+	imgContentWidth := imgBounds.Dx()
+	imgContentHeight := imgBounds.Dy()
+
+	// Iterate over the pixels in the relativeRect
+	for ry := relativeRect.Min.Y; ry < relativeRect.Max.Y; ry++ {
+		for rx := relativeRect.Min.X; rx < relativeRect.Max.X; rx++ {
+			// Check if the relative coordinates (rx, ry) are within the image's content dimensions
+			if rx >= 0 && rx < imgContentWidth && ry >= 0 && ry < imgContentHeight {
+				// Convert relative (rx, ry) to absolute coordinates for img.At()
+				absX := imgBounds.Min.X + rx
+				absY := imgBounds.Min.Y + ry
+
+				pixelColor := img.At(absX, absY)
+				r, g, b, _ := pixelColor.RGBA() // Returns values in [0, 0xffff] range
+
+				// Convert to 0-255 range
+				r8 := uint8(r >> 8)
+				g8 := uint8(g >> 8)
+				b8 := uint8(b >> 8)
+
+				// Calculate brightness for this pixel (simple average)
+				brightness := (float64(r8) + float64(g8) + float64(b8)) / 3.0
+				totalBrightness += brightness
+				count++
+			}
+		}
+	}
+
+	if count == 0 {
+		return 0.0 // Avoid division by zero; or handle as an error
+	}
+	return totalBrightness / float64(count)
+}
+
+// Mismatches returns the tiles whose classification differs from labels, a hand-checked board
+// of the same screenshot (e.g. a saved ASCII map, see game.LoadBoard).
+func (r *Result) Mismatches(labels game.Grid) []game.Coordinate {
+	var mismatches []game.Coordinate
+	for y := 0; y < game.GridHeight; y++ {
+		for x := 0; x < game.GridWidth; x++ {
+			if labels[y][x] != r.Tiles[y][x] {
+				mismatches = append(mismatches, game.Coordinate{X: x, Y: y})
+			}
+		}
+	}
+	return mismatches
+}
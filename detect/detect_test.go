@@ -0,0 +1,187 @@
+package detect
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"riverplan/game"
+	"strings"
+	"testing"
+)
+
+// TestDetectLabelledScreenshots runs Detect on every screenshot in testdata and compares it with
+// the hand-checked board of the same name (see "riverplan detect -check"). Real captures of the
+// game are added there with a .txt label. Recompressed copies share the label of the board they
+// were made from: board.q50.jpg is checked against board.txt. The synthetic_* boards are not captures: they are flat
+// tiles painted close to defaultPalettes, so they only guard the locating and voting code, not
+// the palettes against real game output.
+func TestDetectLabelledScreenshots(t *testing.T) {
+	entries, err := os.ReadDir("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checked := 0
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".txt" {
+			continue
+		}
+		checked++
+		board, _, _ := strings.Cut(entry.Name(), ".")
+		t.Run(entry.Name(), func(t *testing.T) {
+			labels, err := game.LoadBoard(filepath.Join("testdata", board+".txt"))
+			if err != nil {
+				t.Fatalf("loading labels: %v", err)
+			}
+			result, err := DetectFile(filepath.Join("testdata", entry.Name()), Options{})
+			if err != nil {
+				t.Fatalf("Detect: %v", err)
+			}
+			for _, c := range result.Mismatches(labels) {
+				t.Errorf("(%d,%d) read %c want %c, confidence %.2f", c.X, c.Y,
+					game.TileSymbol(result.Tiles[c.Y][c.X]), game.TileSymbol(labels[c.Y][c.X]), result.Confidence[c.Y][c.X])
+			}
+		})
+	}
+	if checked == 0 {
+		t.Fatal("no screenshots in testdata")
+	}
+}
+
+// syntheticBoard draws a board of flat square tiles with darker edges, like the game's sprites,
+// with its top-left corner at origin. Row 6 is road.
+func syntheticBoard(width, height int, origin image.Point, pitch int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 20
+		if i%4 == 3 {
+			img.Pix[i] = 255
+		}
+	}
+	for ty := 0; ty < game.GridHeight; ty++ {
+		for tx := 0; tx < game.GridWidth; tx++ {
+			base := Color{60, 72, 58}
+			if ty == 6 {
+				base = Color{150, 138, 110}
+			}
+			for py := 0; py < pitch; py++ {
+				for px := 0; px < pitch; px++ {
+					c := base
+					if px < pitch/20+1 || py < pitch/20+1 {
+						c = Color{base[0] - 25, base[1] - 25, base[2] - 25}
+					}
+					offset := img.PixOffset(origin.X+tx*pitch+px, origin.Y+ty*pitch+py)
+					copy(img.Pix[offset:offset+3], c[:])
+				}
+			}
+		}
+	}
+	return img
+}
+
+func TestLocateFindsGrid(t *testing.T) {
+	for _, tc := range []struct {
+		width, height int
+		origin        image.Point
+		pitch         int
+	}{
+		{2560, 1440, image.Pt(0, 100), 100},
+		{1920, 1080, image.Pt(20, 80), 75},
+		{1600, 900, image.Pt(40, 62), 62},
+		{1280, 720, image.Pt(100, 60), 50},
+	} {
+		t.Run(fmt.Sprintf("%dx%d", tc.width, tc.height), func(t *testing.T) {
+			loc, err := Locate(syntheticBoard(tc.width, tc.height, tc.origin, tc.pitch))
+			if err != nil {
+				t.Fatalf("Locate: %v", err)
+			}
+			if loc.Method != "grid pattern" {
+				t.Errorf("found by %q, want the grid pattern", loc.Method)
+			}
+			if math.Abs(loc.Pitch-float64(tc.pitch)) > 1 {
+				t.Errorf("pitch %.2f, want %d", loc.Pitch, tc.pitch)
+			}
+			want := image.Rect(tc.origin.X, tc.origin.Y, tc.origin.X+game.GridWidth*tc.pitch, tc.origin.Y+game.GridHeight*tc.pitch)
+			tolerance := tc.pitch / 4
+			if absInt(loc.Rect.Min.X-want.Min.X) > tolerance || absInt(loc.Rect.Min.Y-want.Min.Y) > tolerance ||
+				absInt(loc.Rect.Max.X-want.Max.X) > tolerance || absInt(loc.Rect.Max.Y-want.Max.Y) > tolerance {
+				t.Errorf("grid %v (%s), want %v", loc.Rect, loc.Method, want)
+			}
+		})
+	}
+}
+
+func TestLocateFallsBackToPercentages(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2560, 1440)) // Nothing to find
+	loc, err := Locate(img)
+	if err != nil {
+		t.Fatalf("Locate: %v", err)
+	}
+	if loc.Method != "percentages" {
+		t.Errorf("method %q, want percentages", loc.Method)
+	}
+	if _, err := Locate(image.NewRGBA(image.Rectangle{})); err == nil {
+		t.Error("Locate accepted an empty image")
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+	bounds := image.Rect(0, 0, 2560, 1440)
+	loc, err := Calibrate(bounds, image.Pt(2100, 1300), image.Pt(0, 100)) // Corners in any order
+	if err != nil {
+		t.Fatalf("Calibrate: %v", err)
+	}
+	if want := image.Rect(0, 100, 2100, 1300); loc.Rect != want {
+		t.Errorf("grid %v, want %v", loc.Rect, want)
+	}
+	if loc.Pitch != 100 || loc.Method != "calibration" {
+		t.Errorf("pitch %.2f method %q, want 100 and calibration", loc.Pitch, loc.Method)
+	}
+
+	for _, tc := range []struct {
+		name string
+		a, b image.Point
+	}{
+		{"too small", image.Pt(0, 0), image.Pt(100, 60)},
+		{"not square", image.Pt(0, 0), image.Pt(2520, 600)},
+	} {
+		if _, err := Calibrate(bounds, tc.a, tc.b); err == nil {
+			t.Errorf("%s: Calibrate accepted %v-%v", tc.name, tc.a, tc.b)
+		}
+	}
+
+	profiles := Profiles{}
+	profiles.Set(bounds, loc)
+	if got, ok := profiles.locate(image.NewRGBA(bounds)); !ok || got.Rect != loc.Rect {
+		t.Errorf("profile gives %v, %v; want %v", got.Rect, ok, loc.Rect)
+	}
+	if _, ok := profiles.locate(image.NewRGBA(image.Rect(0, 0, 1920, 1080))); ok {
+		t.Error("profile used for another resolution")
+	}
+}
+
+func TestKMeansPalette(t *testing.T) {
+	var pixels []Color
+	for i := 0; i < 30; i++ {
+		pixels = append(pixels, Color{10, 20, 30}, Color{12, 18, 30}, Color{200, 180, 160}, Color{198, 182, 160})
+	}
+	palette := kMeansPalette(pixels, 2)
+	want := []Color{{11, 19, 30}, {199, 181, 160}}
+	if len(palette) != len(want) {
+		t.Fatalf("palette %v, want %v", palette, want)
+	}
+	for i := range want {
+		if colorDistance(palette[i], want[i]) > 3 {
+			t.Errorf("palette %v, want %v", palette, want)
+		}
+	}
+
+	single := kMeansPalette([]Color{{5, 5, 5}, {5, 5, 5}, {5, 5, 5}}, 4)
+	if len(single) != 1 || single[0] != (Color{5, 5, 5}) {
+		t.Errorf("palette of one colour %v, want [[5 5 5]]", single)
+	}
+	if kMeansPalette(nil, 3) != nil {
+		t.Error("palette of no pixels is not empty")
+	}
+}
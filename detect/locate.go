@@ -0,0 +1,368 @@
+package detect
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"riverplan/game"
+)
+
+// Grid localisation limits. The grid is GridWidth x GridHeight square tiles; its width is
+// assumed to be between minGridWidthFraction of the image width and the whole image width
+// (windowed captures and UI scales in between).
+const (
+	minGridWidthFraction = 0.35
+	minTilePitch         = 8    // Pixels; smaller grids are not readable anyway
+	minPeriodicity       = 0.15 // Normalized autocorrelation the tile pattern must reach at the pitch
+	anchorMinRun         = 3    // Consecutive matching pixels that make an anchor (skips single noisy pixels)
+)
+
+// Location is where the grid was found in a screenshot.
+type Location struct {
+	Rect   image.Rectangle // Grid rectangle in the image
+	Pitch  float64         // Tile size in pixels
+	Method string          // "anchors", "grid pattern", "percentages" or "calibration" (see Calibrate)
+}
+
+// Locate finds the grid in a full screenshot. It tries, in order:
+//
+//   - the anchor colours: the dark gray of the grid's top-left corner and the green pattern of
+//     its top-right corner on the same row, which give the grid's top edge and width;
+//   - the tile pattern: every tile of the board is drawn from the same sprites, so the image's
+//     gradients repeat with the tile pitch. The pitch comes from their autocorrelation and the
+//     grid's offset from the tile edges;
+//   - the fixed percentages of the 2560x1440 screenshot the detector was first written for.
+//
+// The first two work at any resolution and UI scale.
+func Locate(img image.Image) (Location, error) {
+	bounds := img.Bounds()
+	if bounds.Dx() == 0 || bounds.Dy() == 0 {
+		return Location{}, fmt.Errorf("input image has zero width or height")
+	}
+	luma := newLumaImage(img)
+
+	if loc, ok := locateByAnchors(img, luma); ok {
+		return loc, nil
+	}
+	if loc, ok := locateByGridPattern(luma); ok {
+		return loc, nil
+	}
+	log.Printf("Anchors and tile pattern not found; falling back to the 2560x1440 percentages.")
+	return locateByPercentages(bounds)
+}
+
+// lumaImage is the brightness (0-255) of every pixel of an image, for fast repeated access.
+type lumaImage struct {
+	bounds image.Rectangle
+	width  int
+	height int
+	pixels []float64 // Row by row
+}
+
+// newLumaImage converts img to brightness values (the simple RGB average used by AverageBrightness).
+func newLumaImage(img image.Image) *lumaImage {
+	bounds := img.Bounds()
+	l := &lumaImage{bounds: bounds, width: bounds.Dx(), height: bounds.Dy(), pixels: make([]float64, bounds.Dx()*bounds.Dy())}
+	for y := 0; y < l.height; y++ {
+		for x := 0; x < l.width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			l.pixels[y*l.width+x] = (float64(r>>8) + float64(g>>8) + float64(b>>8)) / 3.0
+		}
+	}
+	return l
+}
+
+// at returns the brightness at (x, y) relative to the image's top-left.
+func (l *lumaImage) at(x, y int) float64 {
+	return l.pixels[y*l.width+x]
+}
+
+// pitchRange returns the tile pitches (in pixels) a grid in an image of this size can have.
+func (l *lumaImage) pitchRange() (float64, float64) {
+	maxPitch := math.Min(float64(l.width)/game.GridWidth, float64(l.height)/game.GridHeight)
+	minPitch := math.Max(minTilePitch, minGridWidthFraction*float64(l.width)/game.GridWidth)
+	return minPitch, maxPitch
+}
+
+// gridRect returns the grid rectangle for a top-left corner (relative to the image) and pitch,
+// and whether it fits into the image.
+func (l *lumaImage) gridRect(x0, y0, pitch float64) (image.Rectangle, bool) {
+	rect := image.Rect(
+		int(math.Round(x0)), int(math.Round(y0)),
+		int(math.Round(x0+pitch*game.GridWidth)), int(math.Round(y0+pitch*game.GridHeight)),
+	)
+	// One pixel of rounding slack on every side
+	if rect.Min.X < -1 || rect.Min.Y < -1 || rect.Max.X > l.width+1 || rect.Max.Y > l.height+1 {
+		return image.Rectangle{}, false
+	}
+	return rect.Intersect(image.Rect(0, 0, l.width, l.height)).Add(l.bounds.Min), true
+}
+
+// matchesAnchor reports whether the pixel colour is within anchorColorThreshold (sum of absolute
+// RGB differences) of the anchor colour.
+func matchesAnchor(img image.Image, x, y int, anchorR, anchorG, anchorB int) bool {
+	r, g, b, _ := img.At(x, y).RGBA()
+	difference := absInt(int(r>>8)-anchorR) + absInt(int(g>>8)-anchorG) + absInt(int(b>>8)-anchorB)
+	return difference <= anchorColorThreshold
+}
+
+// locateByAnchors looks for the first row (from the top) that starts a run of the dark gray
+// anchor colour on its left and ends a run of the green pattern colour on its right, far enough
+// apart to be the grid's top edge.
+func locateByAnchors(img image.Image, luma *lumaImage) (Location, bool) {
+	bounds := img.Bounds()
+	minPitch, maxPitch := luma.pitchRange()
+	for y := 0; y < luma.height/2; y++ {
+		absY := bounds.Min.Y + y
+
+		// Leftmost run of the top-left anchor colour
+		left, run := -1, 0
+		for x := 0; x < luma.width/2 && left < 0; x++ {
+			if matchesAnchor(img, bounds.Min.X+x, absY, anchorDarkGrayR, anchorDarkGrayG, anchorDarkGrayB) {
+				run++
+				if run == anchorMinRun {
+					left = x - anchorMinRun + 1
+				}
+			} else {
+				run = 0
+			}
+		}
+		if left < 0 {
+			continue
+		}
+
+		// Rightmost run of the top-right anchor colour
+		right := -1
+		run = 0
+		for x := luma.width - 1; x > left && right < 0; x-- {
+			if matchesAnchor(img, bounds.Min.X+x, absY, anchorGreenPatternR, anchorGreenPatternG, anchorGreenPatternB) {
+				run++
+				if run == anchorMinRun {
+					right = x + anchorMinRun - 1
+				}
+			} else {
+				run = 0
+			}
+		}
+		if right < 0 {
+			continue
+		}
+
+		pitch := float64(right+1-left) / game.GridWidth
+		if pitch < minPitch || pitch > maxPitch+1 {
+			continue
+		}
+		rect, ok := luma.gridRect(float64(left), float64(y), pitch)
+		if !ok {
+			continue
+		}
+		// Lossy compression (JPEG, WebP) smears colours along edges into short runs that can look
+		// like anchors, so the grid they give must also show the tile pattern
+		if periodicity := luma.periodicityAt(rect.Sub(bounds.Min), pitch); periodicity < minPeriodicity {
+			log.Printf("Anchor colours at row %d, x %d-%d give no tile pattern (periodicity %.2f), ignored", y, left, right, periodicity)
+			continue
+		}
+		log.Printf("Grid located by anchor colours: top edge at row %d, x %d-%d, pitch %.2f", y, left, right, pitch)
+		return Location{Rect: rect, Pitch: pitch, Method: "anchors"}, true
+	}
+	return Location{}, false
+}
+
+// locateByGridPattern finds the tile pitch from the autocorrelation of the horizontal gradient
+// profile, then places the grid where the tile edges carry the most gradient energy.
+func locateByGridPattern(luma *lumaImage) (Location, bool) {
+	minPitch, maxPitch := luma.pitchRange()
+	if minPitch > maxPitch {
+		return Location{}, false
+	}
+
+	// Column profiles: gradient energy between column x-1 and x, and brightness, over all rows
+	columnEnergy := make([]float64, luma.width)
+	columnMeans := make([]float64, luma.width)
+	for y := 0; y < luma.height; y++ {
+		for x := 0; x < luma.width; x++ {
+			columnMeans[x] += luma.at(x, y) / float64(luma.height)
+			if x > 0 {
+				columnEnergy[x] += math.Abs(luma.at(x, y) - luma.at(x-1, y))
+			}
+		}
+	}
+	pitch, periodicity := estimatePitch(columnEnergy, minPitch, maxPitch)
+	if periodicity < minPeriodicity {
+		log.Printf("No tile pattern found (best periodicity %.2f at pitch %.2f)", periodicity, pitch)
+		return Location{}, false
+	}
+	x0 := bestGridOffset(columnEnergy, columnMeans, pitch, game.GridWidth)
+
+	// Row profiles over the grid's columns only, so side panels do not blur the rows
+	rowEnergy := make([]float64, luma.height)
+	rowMeans := make([]float64, luma.height)
+	firstColumn, lastColumn := int(x0), int(math.Min(float64(luma.width), x0+pitch*game.GridWidth))
+	for y := 0; y < luma.height; y++ {
+		for x := firstColumn; x < lastColumn; x++ {
+			rowMeans[y] += luma.at(x, y) / float64(lastColumn-firstColumn)
+			if y > 0 {
+				rowEnergy[y] += math.Abs(luma.at(x, y) - luma.at(x, y-1))
+			}
+		}
+	}
+	y0 := bestGridOffset(rowEnergy, rowMeans, pitch, game.GridHeight)
+
+	rect, ok := luma.gridRect(x0, y0, pitch)
+	if !ok {
+		return Location{}, false
+	}
+	log.Printf("Grid located by tile pattern: pitch %.2f (periodicity %.2f), top-left (%.0f, %.0f)", pitch, periodicity, x0, y0)
+	return Location{Rect: rect, Pitch: pitch, Method: "grid pattern"}, true
+}
+
+// periodicityAt returns the normalized autocorrelation at the pitch of the column gradient
+// profile inside rect (relative to the image's top-left), i.e. how strongly rect repeats with
+// the tile pitch. A real grid reaches minPeriodicity, like in locateByGridPattern.
+func (l *lumaImage) periodicityAt(rect image.Rectangle, pitch float64) float64 {
+	rect = rect.Intersect(image.Rect(0, 0, l.width, l.height))
+	lag := int(math.Round(pitch))
+	if rect.Dx() <= lag+1 {
+		return 0
+	}
+	profile := make([]float64, rect.Dx())
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X + 1; x < rect.Max.X; x++ {
+			profile[x-rect.Min.X] += math.Abs(l.at(x, y) - l.at(x-1, y))
+		}
+	}
+	_, periodicity := estimatePitch(profile[1:], float64(lag), float64(lag))
+	return periodicity
+}
+
+// estimatePitch returns the period of profile between minPitch and maxPitch and its normalized
+// autocorrelation (1 is perfectly periodic). Of several strong lags the shortest is taken, since
+// multiples of the pitch correlate as well; the peak is refined to a fraction of a pixel.
+func estimatePitch(profile []float64, minPitch, maxPitch float64) (float64, float64) {
+	mean := 0.0
+	for _, v := range profile {
+		mean += v
+	}
+	mean /= float64(len(profile))
+	centered := make([]float64, len(profile))
+	variance := 0.0
+	for i, v := range profile {
+		centered[i] = v - mean
+		variance += centered[i] * centered[i]
+	}
+	if variance == 0 {
+		return minPitch, 0
+	}
+
+	minLag, maxLag := int(math.Floor(minPitch)), int(math.Ceil(maxPitch))
+	if maxLag >= len(profile)-1 {
+		maxLag = len(profile) - 2
+	}
+	correlation := make([]float64, maxLag+2)
+	for lag := max(minLag-1, 1); lag <= maxLag+1 && lag < len(profile); lag++ {
+		sum := 0.0
+		for i := 0; i+lag < len(centered); i++ {
+			sum += centered[i] * centered[i+lag]
+		}
+		correlation[lag] = sum / variance
+	}
+
+	best := 0.0
+	for lag := minLag; lag <= maxLag; lag++ {
+		best = math.Max(best, correlation[lag])
+	}
+	for lag := minLag; lag <= maxLag; lag++ {
+		if correlation[lag] < 0.9*best {
+			continue
+		}
+		// Climb to the local peak, then interpolate a parabola through it and its neighbours
+		for lag < maxLag && correlation[lag+1] > correlation[lag] {
+			lag++
+		}
+		pitch := float64(lag)
+		if lag > 1 && lag+1 < len(correlation) {
+			left, center, right := correlation[lag-1], correlation[lag], correlation[lag+1]
+			if denominator := left - 2*center + right; denominator < 0 {
+				pitch += 0.5 * (left - right) / denominator
+			}
+		}
+		return pitch, correlation[lag]
+	}
+	return minPitch, best
+}
+
+// bestGridOffset places tiles tiles of the given pitch along one axis. edges is the gradient
+// energy profile and means the brightness profile of that axis. The phase (offset modulo the
+// pitch) is where the tile edges carry the most gradient energy. Of the whole-tile shifts of
+// that phase, the one whose neighbouring tiles have the most similar brightness profiles wins:
+// a shift that takes in a strip of the UI next to the grid has one odd tile out. This also
+// works when the grid touches the image border, where there is no edge to see.
+func bestGridOffset(edges, means []float64, pitch float64, tiles int) float64 {
+	length := len(edges)
+	span := pitch * float64(tiles)
+
+	bestPhase, bestEnergy := 0, -1.0
+	for phase := 0; phase < int(math.Ceil(pitch)) && phase < length; phase++ {
+		energy, count := 0.0, 0
+		for position := float64(phase); position < float64(length); position += pitch {
+			energy += edges[int(position)]
+			count++
+		}
+		if energy /= float64(count); energy > bestEnergy {
+			bestPhase, bestEnergy = phase, energy
+		}
+	}
+
+	segment := int(pitch)
+	bestOffset, bestDifference := 0.0, math.Inf(1)
+	for offset := float64(bestPhase) - pitch; offset+span <= float64(length)+1; offset += pitch {
+		if offset < -pitch/4 {
+			continue
+		}
+		start := math.Max(0, offset)
+		difference := 0.0
+		for k := 0; k+1 < tiles; k++ {
+			a, b := int(start+float64(k)*pitch), int(start+float64(k+1)*pitch)
+			for i := 0; i < segment && b+i < length; i++ {
+				d := means[a+i] - means[b+i]
+				difference += d * d
+			}
+		}
+		if difference < bestDifference {
+			bestOffset, bestDifference = start, difference
+		}
+	}
+	return bestOffset
+}
+
+// locateByPercentages places the grid at the fixed percentages of the 2560x1440 example.
+// Only 16:9 screenshots of the full game window are cropped correctly this way.
+func locateByPercentages(imgBounds image.Rectangle) (Location, error) {
+	imgWidth := float64(imgBounds.Dx())
+	imgHeight := float64(imgBounds.Dy())
+
+	// Calculate pixel coordinates from percentages
+	gridStartX := imgBounds.Min.X + int(imgWidth*gridStartXPercent)
+	gridStartY := imgBounds.Min.Y + int(imgHeight*gridStartYPercent)
+	gridEndX := imgBounds.Min.X + int(imgWidth*gridEndXPercent)
+	gridBottomY := imgBounds.Min.Y + int(imgHeight*gridBottomYPercent)
+
+	gridPixelWidth := gridEndX - gridStartX
+	gridPixelHeight := gridBottomY - gridStartY
+
+	if gridPixelWidth <= 0 || gridPixelHeight <= 0 {
+		return Location{}, fmt.Errorf("calculated grid pixel width (%.0f) or height (%.0f) is zero or negative. Image: %.0fx%.0f. Percentages: X(%.2f-%.2f) Y(%.2f-%.2f)",
+			float64(gridPixelWidth), float64(gridPixelHeight), imgWidth, imgHeight, gridStartXPercent*100, gridEndXPercent*100, gridStartYPercent*100, gridBottomYPercent*100)
+	}
+	cropRect := image.Rect(gridStartX, gridStartY, gridEndX, gridBottomY)
+	return Location{Rect: cropRect, Pitch: float64(gridPixelWidth) / game.GridWidth, Method: "percentages"}, nil
+}
+
+// absInt returns the absolute value of x.
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,32 @@
+package detect
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"riverplan/game"
+)
+
+// SaveSample writes a labelled screenshot in the layout "riverplan detect -check" and -train
+// read: the screenshot as <dir>/<name>.png and the board it shows as the ASCII map
+// <dir>/<name>.txt. The directory is created if needed. It returns the path of the PNG.
+func SaveSample(dir, name string, screenshot image.Image, labels game.Grid) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("creating sample directory: %w", err)
+	}
+	imagePath := filepath.Join(dir, name+".png")
+	if err := savePNG(imagePath, screenshot); err != nil {
+		return "", err
+	}
+
+	labelFile, err := os.Create(filepath.Join(dir, name+".txt"))
+	if err != nil {
+		return "", err
+	}
+	if err := game.WriteASCIIGrid(labelFile, labels); err != nil {
+		labelFile.Close()
+		return "", err
+	}
+	return imagePath, labelFile.Close()
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"riverplan/detect"
+	"riverplan/game"
+	"slices"
+	"strings"
+)
+
+// screenshotExtensions are the file extensions treated as screenshots: by the detect command in
+// a directory, the screenshot watcher and the image file dialog. detect.Decode reads them all.
+var screenshotExtensions = []string{"png", "jpg", "jpeg", "bmp", "webp"}
+
+// isScreenshotFile reports whether name has one of the screenshotExtensions.
+func isScreenshotFile(name string) bool {
+	return slices.Contains(screenshotExtensions, strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), "."))
+}
+
+// runDetectCommand implements "riverplan detect [flags] <screenshot | directory>": it runs the
+// screenshot detector and prints the board it found as an ASCII map. With -check it instead
+// compares every screenshot against its label file (the same name with .txt or .json, as saved
+// by the window) and reports the misread tiles, as a regression check for the detector. With
+// -train it builds tile references from the labelled screenshots (see detect.Train) for -references.
+// It returns the process exit code.
+func runDetectCommand(args []string) int {
+	stdout, stderr := os.Stdout, os.Stderr
+
+	fs := flag.NewFlagSet("detect", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: riverplan detect [flags] <screenshot (PNG, JPEG, BMP, WebP) | directory>")
+		fmt.Fprintln(stderr, "Prints the detected board as an ASCII map, or with -check compares screenshots")
+		fmt.Fprintln(stderr, "against label files (<name>.txt or <name>.json next to <name>.png, .jpg, ...).")
+		fmt.Fprintln(stderr, "With -train, builds tile references from the labelled screenshots instead.")
+		fs.PrintDefaults()
+	}
+	check := fs.Bool("check", false, "compare against label files instead of printing maps; exits 1 on any misread tile")
+	threshold := fs.Float64("threshold", detect.DefaultBrightnessThreshold, "brightness difference that makes a tile a road")
+	referencesPath := fs.String("references", "", "tile references written by -train (default: built-in colours)")
+	profilesPath := fs.String("profiles", gridProfilesFile, "grid calibrations saved by the window, used for screenshots of a calibrated resolution")
+	debugDir := fs.String("debug", "", "write a debug dump of every run (crop, samples, per-tile CSV, classification) into a new folder under this directory")
+	trainPath := fs.String("train", "", "build tile references from the labelled screenshots and write them to this file")
+	verbose := fs.Bool("v", false, "print the detector's debug log to stderr")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(stderr)
+	}
+	opts := detect.Options{BrightnessThreshold: *threshold, DebugDir: *debugDir}
+	if *referencesPath != "" {
+		refs, err := detect.LoadReferences(*referencesPath)
+		if err != nil {
+			fmt.Fprintf(stderr, "detect: %v\n", err)
+			return 1
+		}
+		opts.References = refs
+	}
+	profiles, err := detect.LoadProfiles(*profilesPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "detect: %v\n", err)
+		return 1
+	}
+	opts.Profiles = profiles
+
+	files := []string{fs.Arg(0)}
+	if info, err := os.Stat(fs.Arg(0)); err != nil {
+		fmt.Fprintf(stderr, "detect: %v\n", err)
+		return 1
+	} else if info.IsDir() {
+		entries, err := os.ReadDir(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintf(stderr, "detect: %v\n", err)
+			return 1
+		}
+		files = nil
+		for _, entry := range entries {
+			if !entry.IsDir() && isScreenshotFile(entry.Name()) {
+				files = append(files, filepath.Join(fs.Arg(0), entry.Name()))
+			}
+		}
+		if len(files) == 0 {
+			fmt.Fprintf(stderr, "detect: no screenshots in %s\n", fs.Arg(0))
+			return 1
+		}
+	}
+
+	if *trainPath != "" {
+		return trainDetectReferences(files, *trainPath, opts, stdout, stderr)
+	}
+
+	exitCode := 0
+	checked, failed := 0, 0
+	for _, file := range files {
+		result, err := detect.DetectFile(file, opts)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", file, err)
+			exitCode = 1
+			continue
+		}
+		if !*check {
+			if len(files) > 1 {
+				fmt.Fprintf(stdout, "# %s\n", file)
+			}
+			if err := writeDetectResult(stdout, result); err != nil {
+				fmt.Fprintf(stderr, "detect: writing map: %v\n", err)
+				return 1
+			}
+			continue
+		}
+
+		labels, labelPath, err := loadDetectLabels(file)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", file, err)
+			exitCode = 1
+			continue
+		}
+		if labelPath == "" {
+			fmt.Fprintf(stderr, "%s: no label file, skipped\n", file)
+			continue
+		}
+		checked++
+		mismatches := result.Mismatches(labels)
+		if len(mismatches) == 0 {
+			fmt.Fprintf(stdout, "ok   %s (lowest tile confidence %.2f)\n", file, result.MinConfidence())
+			continue
+		}
+		failed++
+		exitCode = 1
+		var tiles []string
+		for _, c := range mismatches {
+			tiles = append(tiles, fmt.Sprintf("(%d,%d) read %c want %c, confidence %.2f",
+				c.X, c.Y, game.TileSymbol(result.Tiles[c.Y][c.X]), game.TileSymbol(labels[c.Y][c.X]), result.Confidence[c.Y][c.X]))
+		}
+		if result.DebugDump != "" {
+			tiles = append(tiles, "debug dump "+result.DebugDump)
+		}
+		fmt.Fprintf(stdout, "FAIL %s: %d tile(s) misread against %s\n     %s\n", file, len(mismatches), filepath.Base(labelPath), strings.Join(tiles, "\n     "))
+	}
+	if *check {
+		fmt.Fprintf(stderr, "%d screenshot(s) checked, %d failed\n", checked, failed)
+	}
+	return exitCode
+}
+
+// writeDetectResult prints a detected board as an ASCII map, followed by comment lines with the
+// crop, how it was found, the lowest tile confidence and the debug dump, if any.
+func writeDetectResult(w io.Writer, result detect.Result) error {
+	// The board is written as drawn; WriteASCIIMap would only keep the cards of a solution
+	if err := game.WriteASCIIGrid(w, result.Tiles); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "# crop %v (%s), lowest tile confidence %.2f\n", result.Crop, result.CropMethod, result.MinConfidence())
+	if result.DebugDump != "" {
+		fmt.Fprintf(w, "# debug dump %s\n", result.DebugDump)
+	}
+	return nil
+}
+
+// trainDetectReferences implements "riverplan detect -train": it locates the grid in every
+// labelled screenshot (with the grid calibrations of opts), trains references on them and
+// writes the references to outPath.
+func trainDetectReferences(files []string, outPath string, opts detect.Options, stdout, stderr io.Writer) int {
+	var samples []detect.Sample
+	for _, file := range files {
+		labels, labelPath, err := loadDetectLabels(file)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", file, err)
+			return 1
+		}
+		if labelPath == "" {
+			fmt.Fprintf(stderr, "%s: no label file, skipped\n", file)
+			continue
+		}
+		result, err := detect.DetectFile(file, opts)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", file, err)
+			return 1
+		}
+		samples = append(samples, detect.Sample{Cropped: result.Cropped, Labels: labels})
+	}
+	if len(samples) == 0 {
+		fmt.Fprintln(stderr, "detect: no labelled screenshots to train on")
+		return 1
+	}
+	if err := detect.SaveReferences(outPath, detect.Train(samples)); err != nil {
+		fmt.Fprintf(stderr, "detect: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "Trained references on %d screenshot(s), written to %s\n", len(samples), outPath)
+	return 0
+}
+
+// loadDetectLabels loads the label board of a screenshot from <name>.txt or <name>.json (see game.LoadBoard).
+// Recompressed copies named like <name>.q50.jpg fall back to the label of <name>.
+// It returns an empty labelPath if there is no label file.
+func loadDetectLabels(screenshot string) (game.Grid, string, error) {
+	dir, file := filepath.Split(screenshot)
+	board, _, _ := strings.Cut(file, ".")
+	for _, base := range []string{strings.TrimSuffix(screenshot, filepath.Ext(screenshot)), filepath.Join(dir, board)} {
+		for _, extension := range []string{".txt", ".json"} {
+			labelPath := base + extension
+			if _, err := os.Stat(labelPath); err != nil {
+				continue
+			}
+			labels, err := game.LoadBoard(labelPath)
+			return labels, labelPath, err
+		}
+	}
+	return game.Grid{}, "", nil
+}
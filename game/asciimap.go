@@ -0,0 +1,426 @@
+package game
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The ASCII map format is the character map written by Print, optionally preceded by
+// header lines for the rules and start. Example:
+//
+//	# riverplan map
+//	# start: 4,0
+//	# max-length: 35
+//	# end-road-distance: 3
+//	. . . . ~ . . . . . . . . . . . . . . . .
+//	...
+//
+// Tiles use the symbols of TileSymbol; spaces between them are optional. Lines starting
+// with "#" that are not one of the known headers below are comments. Blank lines are ignored.
+// Headers (all optional):
+//
+//	start: x,y                  Selected river start
+//	max-length: n               Maximum river length
+//	disable-cross-adjacency: b  true/false
+//	end-border: b               River must end on the border
+//	end-target: x,y             River must end on this cell
+//	end-road-distance: n        River must end at least n tiles from the road
+//	objective: name             profit, profit_per_river, profit_per_card, fewest_cards
+//	                            or meadow_healing
+//	target-profit: f            Target of fewest_cards (0.5 = 50%)
+//	weights: hp=f regen=f attack-speed=f
+//	budgets: forest=n thicket=n ...   Landscape cards (-1 = unlimited)
+//	path: x,y x,y ...           River path in order (only needed when it cannot be traced from the map)
+//
+// When the map contains River tiles, it is read as a solution.
+
+// ReadASCIIMap parses an ASCII map into a PlanFile.
+func ReadASCIIMap(r io.Reader) (PlanFile, error) {
+	p := PlanFile{Version: PlanFileVersion}
+	var path []Coordinate
+
+	grid, err := scanASCIIMap(r, func(key, value string) error {
+		return p.applyMapHeader(key, value, &path)
+	})
+	if err != nil {
+		return PlanFile{}, err
+	}
+	p.Roads = grid.RoadTiles()
+
+	// A map with a river is a solution; rebuild it on top of the road so Forbidden tiles are consistent
+	hasRiver := false
+	for y := 0; y < GridHeight; y++ {
+		for x := 0; x < GridWidth; x++ {
+			if grid[y][x] == River {
+				hasRiver = true
+			}
+		}
+	}
+	if hasRiver {
+		if path == nil {
+			path, err = grid.traceRiverPath(p.Start)
+			if err != nil {
+				return PlanFile{}, err
+			}
+		}
+		solutionGrid := p.RoadGrid()
+		for y := 0; y < GridHeight; y++ {
+			for x := 0; x < GridWidth; x++ {
+				if grid[y][x] != Empty && grid[y][x] != Road && grid[y][x] != Forbidden {
+					solutionGrid[y][x] = grid[y][x]
+				}
+			}
+		}
+		stats := solutionGrid.TotalStats()
+		p.Solution = &PlanSolution{Path: path, Profit: p.Rules.Landscape.Profit(stats), Stats: stats, Grid: solutionGrid}
+		if p.Start == nil && len(path) > 0 {
+			start := path[0]
+			p.Start = &start
+		}
+	}
+
+	if err := p.validate(); err != nil {
+		return PlanFile{}, err
+	}
+	return p, nil
+}
+
+// ReadASCIIGrid reads only the tiles of an ASCII map, exactly as drawn. Headers are skipped and
+// the grid is not rebuilt on top of the road, so cards on a board without a river (e.g. the
+// label of a mid-run screenshot) are kept.
+func ReadASCIIGrid(r io.Reader) (Grid, error) {
+	return scanASCIIMap(r, func(key, value string) error { return nil })
+}
+
+// scanASCIIMap parses the tile rows of an ASCII map, passing every "# key: value" line to header.
+func scanASCIIMap(r io.Reader, header func(key, value string) error) (Grid, error) {
+	var rows []string
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			key, value, found := strings.Cut(strings.TrimSpace(strings.TrimPrefix(line, "#")), ":")
+			if !found {
+				continue // Plain comment
+			}
+			if err := header(strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+				return Grid{}, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			continue
+		}
+		rows = append(rows, strings.Join(strings.Fields(line), ""))
+	}
+	if err := scanner.Err(); err != nil {
+		return Grid{}, fmt.Errorf("failed to read map: %w", err)
+	}
+	return ParseRows(rows)
+}
+
+// applyMapHeader applies one "key: value" header line. Unknown keys are treated as comments.
+func (p *PlanFile) applyMapHeader(key, value string, path *[]Coordinate) error {
+	var err error
+	switch key {
+	case "start":
+		var c Coordinate
+		c, err = parseMapCoordinate(value)
+		p.Start = &c
+	case "max-length":
+		p.MaxLength, err = strconv.Atoi(value)
+	case "disable-cross-adjacency":
+		p.Rules.DisableCrossRiverAdjacency, err = strconv.ParseBool(value)
+	case "end-border":
+		p.Rules.End.OnBorder, err = strconv.ParseBool(value)
+	case "end-target":
+		var c Coordinate
+		c, err = parseMapCoordinate(value)
+		p.Rules.End.Target = &c
+	case "end-road-distance":
+		p.Rules.End.MinRoadDistance, err = strconv.Atoi(value)
+	case "objective":
+		err = p.Rules.Objective.UnmarshalText([]byte(value))
+	case "target-profit":
+		p.Rules.TargetProfit, err = strconv.ParseFloat(value, 64)
+	case "weights":
+		for _, field := range strings.Fields(value) {
+			name, number, found := strings.Cut(field, "=")
+			if !found {
+				return fmt.Errorf("weight %q is not name=value", field)
+			}
+			f, parseErr := strconv.ParseFloat(number, 64)
+			if parseErr != nil {
+				return fmt.Errorf("weight %q: %w", field, parseErr)
+			}
+			switch name {
+			case "hp":
+				p.Rules.Landscape.Weights.HP = f
+			case "regen":
+				p.Rules.Landscape.Weights.Regen = f
+			case "attack-speed":
+				p.Rules.Landscape.Weights.AttackSpeed = f
+			default:
+				return fmt.Errorf("unknown weight %q", name)
+			}
+		}
+	case "budgets":
+		p.Rules.Landscape.Budgets = make(map[TileType]int)
+		for _, field := range strings.Fields(value) {
+			name, number, found := strings.Cut(field, "=")
+			if !found {
+				return fmt.Errorf("budget %q is not name=value", field)
+			}
+			var t TileType
+			if err := t.UnmarshalText([]byte(name)); err != nil {
+				return err
+			}
+			n, parseErr := strconv.Atoi(number)
+			if parseErr != nil {
+				return fmt.Errorf("budget %q: %w", field, parseErr)
+			}
+			p.Rules.Landscape.Budgets[t] = n
+		}
+	case "path":
+		*path = []Coordinate{}
+		for _, field := range strings.Fields(value) {
+			c, parseErr := parseMapCoordinate(field)
+			if parseErr != nil {
+				return parseErr
+			}
+			*path = append(*path, c)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("header %q: %w", key, err)
+	}
+	return nil
+}
+
+// parseMapCoordinate parses "x,y".
+func parseMapCoordinate(s string) (Coordinate, error) {
+	xs, ys, found := strings.Cut(s, ",")
+	if !found {
+		return Coordinate{}, fmt.Errorf("coordinate %q is not x,y", s)
+	}
+	x, err := strconv.Atoi(strings.TrimSpace(xs))
+	if err != nil {
+		return Coordinate{}, fmt.Errorf("coordinate %q: %w", s, err)
+	}
+	y, err := strconv.Atoi(strings.TrimSpace(ys))
+	if err != nil {
+		return Coordinate{}, fmt.Errorf("coordinate %q: %w", s, err)
+	}
+	return Coordinate{X: x, Y: y}, nil
+}
+
+// traceRiverPath orders the River tiles of g into a path. It starts at start when that is a
+// river tile, otherwise at the only river end on the border. It fails when the river branches
+// or touches itself so the order is ambiguous (a "path:" header resolves that).
+func (g *Grid) traceRiverPath(start *Coordinate) ([]Coordinate, error) {
+	riverNeighbours := func(c Coordinate, visited map[Coordinate]bool) []Coordinate {
+		var next []Coordinate
+		for _, adj := range []Coordinate{{X: c.X, Y: c.Y - 1}, {X: c.X, Y: c.Y + 1}, {X: c.X - 1, Y: c.Y}, {X: c.X + 1, Y: c.Y}} {
+			if g.isValidCoordinate(adj) && g[adj.Y][adj.X] == River && !visited[adj] {
+				next = append(next, adj)
+			}
+		}
+		return next
+	}
+
+	riverTiles := 0
+	var ends []Coordinate
+	for y := 0; y < GridHeight; y++ {
+		for x := 0; x < GridWidth; x++ {
+			c := Coordinate{X: x, Y: y}
+			if g[y][x] != River {
+				continue
+			}
+			riverTiles++
+			if isBorderTile(c) && len(riverNeighbours(c, nil)) <= 1 {
+				ends = append(ends, c)
+			}
+		}
+	}
+
+	var current Coordinate
+	switch {
+	case start != nil && g.isValidCoordinate(*start) && g[start.Y][start.X] == River:
+		current = *start
+	case len(ends) == 1:
+		current = ends[0]
+	default:
+		return nil, fmt.Errorf("cannot tell where the river starts (%d border ends); add a \"start:\" or \"path:\" header", len(ends))
+	}
+
+	visited := map[Coordinate]bool{current: true}
+	path := []Coordinate{current}
+	for {
+		next := riverNeighbours(current, visited)
+		if len(next) == 0 {
+			break
+		}
+		if len(next) > 1 {
+			return nil, fmt.Errorf("river branches at (%d, %d); add a \"path:\" header", current.X, current.Y)
+		}
+		current = next[0]
+		visited[current] = true
+		path = append(path, current)
+	}
+	if len(path) != riverTiles {
+		return nil, fmt.Errorf("river is not a single path (%d of %d tiles reached); add a \"path:\" header", len(path), riverTiles)
+	}
+	return path, nil
+}
+
+// WriteASCIIMap writes p as an ASCII map with header lines. The solution grid is written
+// when p has a solution, otherwise the road grid.
+func WriteASCIIMap(w io.Writer, p PlanFile) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# riverplan map")
+	if p.Start != nil {
+		fmt.Fprintf(bw, "# start: %d,%d\n", p.Start.X, p.Start.Y)
+	}
+	if p.MaxLength > 0 {
+		fmt.Fprintf(bw, "# max-length: %d\n", p.MaxLength)
+	}
+	if p.Rules.DisableCrossRiverAdjacency {
+		fmt.Fprintln(bw, "# disable-cross-adjacency: true")
+	}
+	if p.Rules.End.OnBorder {
+		fmt.Fprintln(bw, "# end-border: true")
+	}
+	if p.Rules.End.Target != nil {
+		fmt.Fprintf(bw, "# end-target: %d,%d\n", p.Rules.End.Target.X, p.Rules.End.Target.Y)
+	}
+	if p.Rules.End.MinRoadDistance > 0 {
+		fmt.Fprintf(bw, "# end-road-distance: %d\n", p.Rules.End.MinRoadDistance)
+	}
+	if p.Rules.Objective != ObjectiveProfit {
+		name, err := p.Rules.Objective.MarshalText()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(bw, "# objective: %s\n", name)
+		if p.Rules.Objective == ObjectiveFewestCards {
+			fmt.Fprintf(bw, "# target-profit: %g\n", p.Rules.TargetProfit)
+		}
+	}
+	if weights := p.Rules.Landscape.Weights; weights != (Stats{}) {
+		fmt.Fprintf(bw, "# weights: hp=%g regen=%g attack-speed=%g\n", weights.HP, weights.Regen, weights.AttackSpeed)
+	}
+	if p.Rules.Landscape.Budgets != nil {
+		var budgets []string
+		for _, t := range LandscapeTypes {
+			if n, ok := p.Rules.Landscape.Budgets[t]; ok {
+				budgets = append(budgets, fmt.Sprintf("%s=%d", tileNames[t], n))
+			}
+		}
+		fmt.Fprintf(bw, "# budgets: %s\n", strings.Join(budgets, " "))
+	}
+
+	grid := p.RoadGrid()
+	if p.Solution != nil {
+		grid = p.Solution.Grid
+		if traced, err := grid.traceRiverPath(p.Start); err != nil || !sameCoordinates(traced, p.Solution.Path) {
+			// The map alone does not tell the river order, so spell it out
+			var tiles []string
+			for _, c := range p.Solution.Path {
+				tiles = append(tiles, fmt.Sprintf("%d,%d", c.X, c.Y))
+			}
+			fmt.Fprintf(bw, "# path: %s\n", strings.Join(tiles, " "))
+		}
+	}
+	writeMapRows(bw, grid)
+	return bw.Flush()
+}
+
+// WriteASCIIGrid writes just the tiles of g as an ASCII map without any headers, the
+// counterpart of ReadASCIIGrid.
+func WriteASCIIGrid(w io.Writer, g Grid) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# riverplan map")
+	writeMapRows(bw, g)
+	return bw.Flush()
+}
+
+// writeMapRows writes the rows of g with a space between tiles.
+func writeMapRows(w io.Writer, g Grid) {
+	for _, row := range g.Rows() {
+		fmt.Fprintln(w, strings.Join(strings.Split(row, ""), " "))
+	}
+}
+
+// sameCoordinates reports whether a and b hold the same coordinates in the same order.
+func sameCoordinates(a, b []Coordinate) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// SaveASCIIMap writes p as an ASCII map to the file at path, replacing it if it exists.
+func SaveASCIIMap(path string, p PlanFile) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WriteASCIIMap(file, p); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// LoadASCIIMap reads the ASCII map at path.
+func LoadASCIIMap(path string) (PlanFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return PlanFile{}, err
+	}
+	defer file.Close()
+	return ReadASCIIMap(file)
+}
+
+// LoadPlan reads either a JSON plan file or an ASCII map from path, telling them apart by content.
+func LoadPlan(path string) (PlanFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return PlanFile{}, err
+	}
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		return ReadPlanFile(strings.NewReader(string(data)))
+	}
+	return ReadASCIIMap(strings.NewReader(string(data)))
+}
+
+// LoadBoard reads the tiles of a JSON plan file or an ASCII map at path: the solution grid
+// when there is one, otherwise the road grid (JSON) or the tiles as drawn (ASCII, see ReadASCIIGrid).
+func LoadBoard(path string) (Grid, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Grid{}, err
+	}
+	if !strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		return ReadASCIIGrid(strings.NewReader(string(data)))
+	}
+	plan, err := ReadPlanFile(strings.NewReader(string(data)))
+	if err != nil {
+		return Grid{}, err
+	}
+	if plan.Solution != nil {
+		return plan.Solution.Grid, nil
+	}
+	return plan.RoadGrid(), nil
+}
@@ -0,0 +1,102 @@
+package game
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestASCIIMapRoundTrip(t *testing.T) {
+	for _, solved := range []bool{false, true} {
+		p := samplePlan(t, solved)
+		var sb strings.Builder
+		if err := WriteASCIIMap(&sb, p); err != nil {
+			t.Fatalf("WriteASCIIMap: %v", err)
+		}
+		got, err := ReadASCIIMap(strings.NewReader(sb.String()))
+		if err != nil {
+			t.Fatalf("ReadASCIIMap: %v\n%s", err, sb.String())
+		}
+		if !reflect.DeepEqual(got, p) {
+			t.Errorf("solved %t: read back\n%+v\nwant\n%+v\nfrom\n%s", solved, got, p, sb.String())
+		}
+	}
+}
+
+func TestASCIIMapKeepsPathThatCannotBeTraced(t *testing.T) {
+	// The river turns back next to itself, so the map alone would not tell its order
+	p := samplePlan(t, false)
+	p.Rules = PlanRules{}
+	roads := p.RoadGrid()
+	sol, err := roads.EvaluatePath([]Coordinate{{X: 10, Y: 0}, {X: 10, Y: 1}, {X: 11, Y: 1}, {X: 11, Y: 2}, {X: 10, Y: 2}, {X: 9, Y: 2}, {X: 9, Y: 1}}, false, SearchOptions{})
+	if err != nil {
+		t.Fatalf("EvaluatePath: %v", err)
+	}
+	p.Solution = &PlanSolution{Path: sol.Path, Profit: sol.Profit, Stats: sol.Stats, Grid: sol.Grid}
+	var sb strings.Builder
+	if err := WriteASCIIMap(&sb, p); err != nil {
+		t.Fatalf("WriteASCIIMap: %v", err)
+	}
+	if !strings.Contains(sb.String(), "# path: ") {
+		t.Errorf("map has no path header:\n%s", sb.String())
+	}
+	got, err := ReadASCIIMap(strings.NewReader(sb.String()))
+	if err != nil {
+		t.Fatalf("ReadASCIIMap: %v", err)
+	}
+	if !sameCoordinates(got.Solution.Path, p.Solution.Path) {
+		t.Errorf("path read back as %v, want %v", got.Solution.Path, p.Solution.Path)
+	}
+}
+
+func TestReadASCIIMapRejectsBadInput(t *testing.T) {
+	var sb strings.Builder
+	if err := WriteASCIIMap(&sb, samplePlan(t, true)); err != nil {
+		t.Fatalf("WriteASCIIMap: %v", err)
+	}
+	valid := sb.String()
+	lines := strings.Split(strings.TrimSuffix(valid, "\n"), "\n")
+	roadRow := "R R R R R R R R R R R R R R R R R R R R R"
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"unknown symbol", strings.Replace(valid, roadRow, "R R R R R R R R R R R R R R R R R R R R Z", 1)},
+		{"short row", strings.Replace(valid, roadRow, "R R R R R R R R R R R R R R R R R R R R", 1)},
+		{"long row", strings.Replace(valid, roadRow, roadRow+" R", 1)},
+		{"missing row", strings.Join(lines[:len(lines)-1], "\n")},
+		{"extra row", valid + roadRow + "\n"},
+		{"bad start", strings.Replace(valid, "# start: 10,0", "# start: ten,0", 1)},
+		{"start outside the grid", strings.Replace(valid, "# start: 10,0", "# start: 40,0", 1)},
+		{"bad max length", strings.Replace(valid, "# max-length: 20", "# max-length: long", 1)},
+		{"unknown objective", strings.Replace(valid, "# objective: fewest_cards", "# objective: most_cards", 1)},
+		{"unknown weight", strings.Replace(valid, "# weights: hp=1", "# weights: luck=1", 1)},
+		{"unknown budget", strings.Replace(valid, "# budgets: forest=-1", "# budgets: swamp=-1", 1)},
+	}
+	for _, tt := range tests {
+		if tt.data == valid {
+			t.Fatalf("%s: the test edit did not change the map", tt.name)
+		}
+		if _, err := ReadASCIIMap(strings.NewReader(tt.data)); err == nil {
+			t.Errorf("%s: ReadASCIIMap accepted the map", tt.name)
+		}
+	}
+	if _, err := ReadASCIIGrid(strings.NewReader(tests[0].data)); err == nil {
+		t.Error("ReadASCIIGrid accepted an unknown symbol")
+	}
+}
+
+func TestASCIIGridRoundTrip(t *testing.T) {
+	g := cardBoard()
+	var sb strings.Builder
+	if err := WriteASCIIGrid(&sb, g); err != nil {
+		t.Fatalf("WriteASCIIGrid: %v", err)
+	}
+	got, err := ReadASCIIGrid(strings.NewReader(sb.String()))
+	if err != nil {
+		t.Fatalf("ReadASCIIGrid: %v", err)
+	}
+	if got != g {
+		t.Errorf("read back\n%s", sb.String())
+	}
+}
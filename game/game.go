@@ -15,11 +15,16 @@ const (
 // TileType represents the type of a tile on the game grid.
 // We'll use iota to give these constants incrementing values.
 const (
-	Empty     TileType = iota // Default state, can be built upon
-	Road                      // Manually placed road tile
-	River                     // Player-placed river tile
-	Forest                    // Player-placed forest tile
-	Forbidden                 // Tiles near the road or otherwise unbuildable
+	Empty        TileType = iota // Default state, can be built upon
+	Road                         // Manually placed road tile
+	River                        // Player-placed river tile
+	Forest                       // Player-placed forest tile
+	Forbidden                    // Tiles near the road or otherwise unbuildable
+	Rock                         // Player-placed rock tile (max HP)
+	Mountain                     // Player-placed mountain tile (max HP)
+	Meadow                       // Player-placed meadow tile (HP regeneration)
+	Thicket                      // Player-placed thicket tile (attack speed)
+	MountainPeak                 // Rock or mountain tile that is part of a 3x3 Mountain Peak
 )
 
 // TileType is an alias for int for better readability.
@@ -28,7 +33,8 @@ type TileType int
 // Coordinate represents a position on the grid.
 // X is column, Y is row.
 type Coordinate struct {
-	X, Y int
+	X int `json:"x"`
+	Y int `json:"y"`
 }
 
 // Grid represents the game board.
@@ -102,6 +108,16 @@ func (g *Grid) Print() {
 				fmt.Print("F ") // F for Forest
 			case Forbidden:
 				fmt.Print("X ") // X for Forbidden
+			case Rock:
+				fmt.Print("o ") // o for Rock
+			case Mountain:
+				fmt.Print("M ") // M for Mountain
+			case Meadow:
+				fmt.Print("w ") // w for Meadow
+			case Thicket:
+				fmt.Print("T ") // T for Thicket
+			case MountainPeak:
+				fmt.Print("^ ") // ^ for Mountain Peak
 			default:
 				fmt.Print("? ") // Should not happen
 			}
@@ -141,48 +157,67 @@ func (g *Grid) GetValidRiverStarts() []Coordinate {
 	return validStarts
 }
 
+// isValidRiverStart reports whether a river may start at c, by the rule of GetValidRiverStarts.
+func (g *Grid) isValidRiverStart(c Coordinate) bool {
+	if !g.isValidCoordinate(c) || g[c.Y][c.X] != Empty {
+		return false
+	}
+	onVerticalBorder := c.X == 0 || c.X == GridWidth-1
+	onHorizontalBorder := c.Y == 0 || c.Y == GridHeight-1
+	return onVerticalBorder != onHorizontalBorder // On the border but not in a corner
+}
+
 // RiverPathSolution stores a sequence of river tiles and the calculated profit.
+// Profit is the weighted sum of Stats (attack speed only by default).
 type RiverPathSolution struct {
-	Path   []Coordinate
-	Profit float64
-	Grid   Grid
+	Path   []Coordinate `json:"path"`
+	Profit float64      `json:"profit"`
+	Stats  Stats        `json:"stats"` // Total stat bonuses of all landscape tiles in Grid
+	Score  float64      `json:"score"` // Value under the search objective (equals Profit for ObjectiveProfit); see BetterThan
+	Cards  int          `json:"cards"` // River tiles plus landscape tiles in Grid
+	Grid   Grid         `json:"grid"`
 }
 
-// FindOptimalRiverAndForests now accepts maxLen and disableCrossRiverAdjacency.
-func (g *Grid) FindOptimalRiverAndForests(startCoordinate Coordinate, maxLen int, progressCallback func(RiverPathSolution), stopChannel <-chan struct{}, disableCrossRiverAdjacency bool) (RiverPathSolution, error) {
-	fmt.Printf("Starting search from user-defined start: (%d, %d) with max length: %d, DisableCrossAdj: %t\n", startCoordinate.X, startCoordinate.Y, maxLen, disableCrossRiverAdjacency)
+// FindOptimalRiverAndForests now accepts maxLen, disableCrossRiverAdjacency and SearchOptions.
+// Only paths that satisfy opts.End are considered solutions.
+func (g *Grid) FindOptimalRiverAndForests(startCoordinate Coordinate, maxLen int, progressCallback func(RiverPathSolution), stopChannel <-chan struct{}, disableCrossRiverAdjacency bool, opts SearchOptions) (RiverPathSolution, error) {
+	opts.logf("Starting search from user-defined start: (%d, %d) with max length: %d, DisableCrossAdj: %t, End: %s, Objective: %s\n", startCoordinate.X, startCoordinate.Y, maxLen, disableCrossRiverAdjacency, opts.End, opts.Objective)
 	initialGrid := *g
 
 	bestSolution := RiverPathSolution{Profit: -1.0, Grid: initialGrid}
 	if initialGrid[startCoordinate.Y][startCoordinate.X] != Empty {
 		return bestSolution, fmt.Errorf("chosen river start point (%d, %d) is not Empty", startCoordinate.X, startCoordinate.Y)
 	}
+	if err := opts.End.validate(&initialGrid); err != nil {
+		return bestSolution, err
+	}
 	var currentPath []Coordinate
 	workingGrid := initialGrid
+	opts.bound = newGridBound(&initialGrid, &opts)
 
 	defer func() {
 		if r := recover(); r != nil {
-			fmt.Println("Recovered in FindOptimalRiverAndForests (likely from closed stopChannel):", r)
+			opts.logf("Recovered in FindOptimalRiverAndForests (likely from closed stopChannel): %v\n", r)
 		}
 	}()
-	exploreAndEvaluateRecursive(&workingGrid, startCoordinate, currentPath, &bestSolution, 0, maxLen, progressCallback, stopChannel, disableCrossRiverAdjacency)
+	exploreAndEvaluateRecursive(&workingGrid, startCoordinate, currentPath, &bestSolution, 0, maxLen, progressCallback, stopChannel, disableCrossRiverAdjacency, &opts)
 
 	select {
 	case <-stopChannel:
-		fmt.Println("Search was stopped prematurely via channel.")
+		opts.logf("Search was stopped prematurely via channel.\n")
 		return bestSolution, fmt.Errorf("search stopped by user")
 	default:
 	}
 
 	if bestSolution.Profit < 0 {
-		return RiverPathSolution{Grid: *g, Profit: -1.0}, fmt.Errorf("no profitable river paths found from (%d, %d) with max length %d", startCoordinate.X, startCoordinate.Y, maxLen)
+		return RiverPathSolution{Grid: *g, Profit: -1.0}, fmt.Errorf("no profitable river paths found from (%d, %d) with max length %d (end: %s)", startCoordinate.X, startCoordinate.Y, maxLen, opts.End)
 	}
-	fmt.Printf("Search complete. Best profit: %.2f%% with %d river tiles from start (%d, %d), max length %d.\n", bestSolution.Profit*100, len(bestSolution.Path), startCoordinate.X, startCoordinate.Y, maxLen)
+	opts.logf("Search complete. Best profit: %.2f%% (%s) with %d river tiles from start (%d, %d), max length %d.\n", bestSolution.Profit*100, opts.FormatScore(bestSolution), len(bestSolution.Path), startCoordinate.X, startCoordinate.Y, maxLen)
 	return bestSolution, nil
 }
 
-// exploreAndEvaluateRecursive now uses maxLen and disableCrossRiverAdjacency.
-func exploreAndEvaluateRecursive(grid *Grid, currentTile Coordinate, currentPath []Coordinate, bestSolution *RiverPathSolution, depth int, maxLen int, progressCallback func(RiverPathSolution), stopChannel <-chan struct{}, disableCrossRiverAdjacency bool) {
+// exploreAndEvaluateRecursive now uses maxLen, disableCrossRiverAdjacency and the end constraints in opts.
+func exploreAndEvaluateRecursive(grid *Grid, currentTile Coordinate, currentPath []Coordinate, bestSolution *RiverPathSolution, depth int, maxLen int, progressCallback func(RiverPathSolution), stopChannel <-chan struct{}, disableCrossRiverAdjacency bool, opts *SearchOptions) {
 	select {
 	case <-stopChannel:
 		return
@@ -202,8 +237,17 @@ func exploreAndEvaluateRecursive(grid *Grid, currentTile Coordinate, currentPath
 	grid[currentTile.Y][currentTile.X] = River
 	pathWithCurrentTile := append(currentPath, currentTile)
 
+	// Prune by objective: no extension of this path can beat the best solution found so far
+	if !opts.disablePruning && bestSolution.Profit >= 0 && opts.scoreCeiling(grid, pathWithCurrentTile, maxLen)+1e-9 <= bestSolution.Score {
+		grid[currentTile.Y][currentTile.X] = originalTileState
+		return
+	}
+
+	// A river that reaches its end target stops there; continuing would move the end elsewhere.
+	reachedTarget := opts.End.Target != nil && currentTile.X == opts.End.Target.X && currentTile.Y == opts.End.Target.Y
+
 	madeRecursiveCall := false
-	if len(pathWithCurrentTile) < maxLen {
+	if len(pathWithCurrentTile) < maxLen && !reachedTarget {
 		potentialNeighbors := []Coordinate{
 			{X: currentTile.X, Y: currentTile.Y - 1}, // Up
 			{X: currentTile.X, Y: currentTile.Y + 1}, // Down
@@ -252,9 +296,16 @@ func exploreAndEvaluateRecursive(grid *Grid, currentTile Coordinate, currentPath
 				}
 			}
 
+			// Prune moves from which the end target can no longer be reached within maxLen
+			if !opts.End.canReachTarget(nextTile, maxLen-len(pathWithCurrentTile)-1) {
+				continue
+			}
+
 			if grid.isValidCoordinate(nextTile) && grid[nextTile.Y][nextTile.X] == Empty {
 				isBorder := nextTile.X == 0 || nextTile.X == GridWidth-1 || nextTile.Y == 0 || nextTile.Y == GridHeight-1
-				if isBorder {
+				// Border moves the river may end on are explored like interior moves; waiting for a dead end would hardly ever reach them
+				mayEndThere := opts.End.OnBorder || opts.End.IsActive() && opts.End.isSatisfiedBy(grid, nextTile)
+				if isBorder && !mayEndThere {
 					borderChoices = append(borderChoices, nextTile)
 				} else {
 					nonBorderChoices = append(nonBorderChoices, nextTile)
@@ -299,7 +350,7 @@ func exploreAndEvaluateRecursive(grid *Grid, currentTile Coordinate, currentPath
 
 			// Explore sorted moves
 			for _, scoredChoice := range scoredMoves {
-				exploreAndEvaluateRecursive(grid, scoredChoice.Coord, pathWithCurrentTile, bestSolution, depth+1, maxLen, progressCallback, stopChannel, disableCrossRiverAdjacency)
+				exploreAndEvaluateRecursive(grid, scoredChoice.Coord, pathWithCurrentTile, bestSolution, depth+1, maxLen, progressCallback, stopChannel, disableCrossRiverAdjacency, opts)
 				madeRecursiveCall = true
 			}
 		}
@@ -314,15 +365,28 @@ func exploreAndEvaluateRecursive(grid *Grid, currentTile Coordinate, currentPath
 	default:
 	}
 
-	if !madeRecursiveCall || len(pathWithCurrentTile) == maxLen { // Evaluate if path ends naturally or hits maxLen
-		profit, gridWithForests := calculateProfitAndPlaceForests(*grid, pathWithCurrentTile)
-		if profit > bestSolution.Profit {
+	// Evaluate if path ends naturally or hits maxLen, and only if it ends where the end constraints allow.
+	// With an end constraint, every prefix that ends in an allowed cell is a candidate, because the
+	// paths through it rarely dead-end or reach maxLen exactly there. Objectives other than raw
+	// profit can prefer a shorter river, so for them every prefix is a candidate too.
+	isCandidate := !madeRecursiveCall || len(pathWithCurrentTile) == maxLen || opts.End.IsActive() || opts.Objective != ObjectiveProfit
+	if isCandidate && !opts.disablePruning && bestSolution.Profit >= 0 && opts.scoreCeiling(grid, pathWithCurrentTile, len(pathWithCurrentTile))+1e-9 <= bestSolution.Score {
+		isCandidate = false // Skip placing the landscapes when even the bound for this exact path cannot win
+	}
+	if isCandidate && opts.End.isSatisfiedBy(grid, currentTile) {
+		profit, stats, gridWithForests := placeLandscapesForObjective(*grid, pathWithCurrentTile, opts)
+		cards := gridWithForests.countCards()
+		score, qualifies := opts.score(profit, stats, len(pathWithCurrentTile), cards)
+		if qualifies && (bestSolution.Profit < 0 || score > bestSolution.Score) {
 			select {
 			case <-stopChannel:
 				grid[currentTile.Y][currentTile.X] = originalTileState
 				return
 			default:
 				bestSolution.Profit = profit
+				bestSolution.Stats = stats
+				bestSolution.Score = score
+				bestSolution.Cards = cards
 				bestSolution.Path = make([]Coordinate, len(pathWithCurrentTile))
 				copy(bestSolution.Path, pathWithCurrentTile)
 				bestSolution.Grid = gridWithForests
@@ -493,63 +557,90 @@ func calculateScoreWithLookahead(grid *Grid, choice Coordinate, currentTile Coor
 	return isStraight, totalAdjacencyBonus, totalNewForestTilesCount
 }
 
-// calculateProfitAndPlaceForests places Forest tiles ONLY in Empty spots adjacent to the river,
-// and calculates profit where each adjacent river tile DOUBLES the forest's base 2% profit.
-func calculateProfitAndPlaceForests(gridWithRiver Grid, riverPath []Coordinate) (float64, Grid) {
+// calculateProfitAndPlaceForests places landscape tiles (only forests by default) in Empty spots
+// adjacent to the river, and calculates profit where each adjacent river tile DOUBLES the tile's base stats.
+func calculateProfitAndPlaceForests(gridWithRiver Grid, riverPath []Coordinate, landscape *LandscapeOptions) (float64, Stats, Grid) {
 	workingGrid := gridWithRiver // Start with the grid that has the river placed
 
-	potentialForestSpots := make(map[Coordinate]bool)
-	for _, riverTile := range riverPath {
-		adjacents := []Coordinate{
-			{X: riverTile.X, Y: riverTile.Y - 1}, {X: riverTile.X, Y: riverTile.Y + 1},
-			{X: riverTile.X - 1, Y: riverTile.Y}, {X: riverTile.X + 1, Y: riverTile.Y},
-		}
-		for _, adj := range adjacents {
-			if workingGrid.isValidCoordinate(adj) && workingGrid[adj.Y][adj.X] == Empty {
-				potentialForestSpots[adj] = true
-			}
-		}
-	}
-
-	for spot := range potentialForestSpots {
-		workingGrid[spot.Y][spot.X] = Forest
-	}
+	placeLandscapes(&workingGrid, riverPath, landscape)
 
-	totalProfit := 0.0
-	for y := 0; y < GridHeight; y++ {
-		for x := 0; x < GridWidth; x++ {
-			if workingGrid[y][x] == Forest {
-				baseForestProfit := 0.02 // Base 2% profit
-				adjacentRiverCount := 0
+	// Every placed tile sits next to the river, so each one contributes
+	// BaseStats * (2 * NumberOfAdjacentRiverTiles).
+	totalStats := workingGrid.TotalStats()
+	return totalStats.Dot(landscape.weights()), totalStats, workingGrid
+}
 
-				adjacentsToForest := []Coordinate{
-					{X: x, Y: y - 1}, {X: x, Y: y + 1},
-					{X: x - 1, Y: y}, {X: x + 1, Y: y},
-				}
+// placeLandscapesForObjective places the landscapes next to a candidate river the way
+// opts.Objective needs them and returns the profit, stats and resulting grid. ObjectiveFewestCards
+// trims them to the target profit; ObjectiveMeadowHealing plants the meadow budget first, where
+// meadows heal most (river banks included), and fills the rest of the banks with the other cards.
+func placeLandscapesForObjective(gridWithRiver Grid, riverPath []Coordinate, opts *SearchOptions) (float64, Stats, Grid) {
+	switch opts.Objective {
+	case ObjectiveFewestCards:
+		_, _, gridWithForests := calculateProfitAndPlaceForests(gridWithRiver, riverPath, &opts.Landscape)
+		profit, stats := trimLandscapesToTarget(&gridWithForests, &gridWithRiver, opts.TargetProfit, &opts.Landscape)
+		return profit, stats, gridWithForests
+	case ObjectiveMeadowHealing:
+		placeMeadows(&gridWithRiver, opts.Landscape.budget(Meadow))
+		bank := opts.Landscape.withoutMeadows()
+		return calculateProfitAndPlaceForests(gridWithRiver, riverPath, &bank)
+	default:
+		return calculateProfitAndPlaceForests(gridWithRiver, riverPath, &opts.Landscape)
+	}
+}
 
-				for _, adj := range adjacentsToForest {
-					if workingGrid.isValidCoordinate(adj) && workingGrid[adj.Y][adj.X] == River {
-						adjacentRiverCount++
+// EvaluatePath places the given river path on g (a road layout) and scores it exactly like the
+// search would: the path must start on a valid river start (see GetValidRiverStarts), continue to adjacent Empty tiles without
+// revisiting any, obey disableCrossRiverAdjacency and end where opts.End allows. Landscapes are
+// then placed by opts.Landscape (see placeLandscapesForObjective).
+func (g *Grid) EvaluatePath(path []Coordinate, disableCrossRiverAdjacency bool, opts SearchOptions) (RiverPathSolution, error) {
+	solution := RiverPathSolution{Grid: *g, Profit: -1.0}
+	if len(path) == 0 {
+		return solution, fmt.Errorf("the river path is empty")
+	}
+	if err := opts.End.validate(g); err != nil {
+		return solution, err
+	}
+	if start := path[0]; !g.isValidRiverStart(start) {
+		return solution, fmt.Errorf("the river cannot start at (%d, %d): it must start on an Empty border tile that is not a corner", start.X, start.Y)
+	}
+	workingGrid := *g
+	for i, tile := range path {
+		if !workingGrid.isValidCoordinate(tile) {
+			return solution, fmt.Errorf("river tile %d (%d, %d) is outside the grid", i, tile.X, tile.Y)
+		}
+		if workingGrid[tile.Y][tile.X] != Empty {
+			return solution, fmt.Errorf("river tile %d (%d, %d) is not Empty", i, tile.X, tile.Y)
+		}
+		if i > 0 {
+			previous := path[i-1]
+			if abs(tile.X-previous.X)+abs(tile.Y-previous.Y) != 1 {
+				return solution, fmt.Errorf("river tile %d (%d, %d) is not adjacent to the previous tile (%d, %d)", i, tile.X, tile.Y, previous.X, previous.Y)
+			}
+			if disableCrossRiverAdjacency {
+				for _, adj := range []Coordinate{{X: tile.X, Y: tile.Y - 1}, {X: tile.X, Y: tile.Y + 1}, {X: tile.X - 1, Y: tile.Y}, {X: tile.X + 1, Y: tile.Y}} {
+					if adj != previous && workingGrid.isValidCoordinate(adj) && workingGrid[adj.Y][adj.X] == River {
+						return solution, fmt.Errorf("river tile %d (%d, %d) touches the river at (%d, %d) (cross-river adjacency is disabled)", i, tile.X, tile.Y, adj.X, adj.Y)
 					}
 				}
-
-				// New profit calculation logic:
-				// FinalProfit = BaseForestProfit * (2 * NumberOfAdjacentRiverTiles)
-				individualForestProfit := 0.0
-				if adjacentRiverCount > 0 {
-					individualForestProfit = baseForestProfit * (2.0 * float64(adjacentRiverCount))
-				}
-				// If a forest tile somehow has 0 adjacent rivers (which shouldn't happen
-				// with current placement logic), its profit contribution here will be 0.0.
-
-				totalProfit += individualForestProfit
 			}
 		}
+		workingGrid[tile.Y][tile.X] = River
+	}
+	last := path[len(path)-1]
+	if !opts.End.isSatisfiedBy(&workingGrid, last) {
+		return solution, fmt.Errorf("the river ends at (%d, %d), which does not satisfy the end constraint (%s)", last.X, last.Y, opts.End)
 	}
-	return totalProfit, workingGrid
-}
 
-// TODO: Add functions for calculating profit based on a river path and forest placements
+	profit, stats, gridWithForests := placeLandscapesForObjective(workingGrid, path, &opts)
+	cards := gridWithForests.countCards()
+	score, qualifies := opts.score(profit, stats, len(path), cards)
+	solution = RiverPathSolution{Path: append([]Coordinate(nil), path...), Profit: profit, Stats: stats, Score: score, Cards: cards, Grid: gridWithForests}
+	if !qualifies {
+		return solution, fmt.Errorf("profit %.2f%% does not reach the target of %.2f%%", profit*100, opts.TargetProfit*100)
+	}
+	return solution, nil
+}
 
 type ScoredMove struct {
 	Coord               Coordinate
@@ -0,0 +1,147 @@
+package game
+
+import (
+	"math"
+	"testing"
+)
+
+// rowRoadGrid returns an empty grid with a road along row y.
+func rowRoadGrid(y int) Grid {
+	var road []Coordinate
+	for x := 0; x < GridWidth; x++ {
+		road = append(road, Coordinate{X: x, Y: y})
+	}
+	g := NewGrid()
+	g.SetRoad(road)
+	return g
+}
+
+// bestScoreByBruteForce returns the best score of every river from start of at most maxLen
+// tiles that ends where opts.End allows, or -Inf if there is none (scores can be negative, e.g.
+// for ObjectiveFewestCards). Like the search, a river only moves onto a border tile when no other
+// tile is free, unless it may end there, and never where it can no longer reach its end target.
+func bestScoreByBruteForce(g Grid, start Coordinate, maxLen int, opts SearchOptions) float64 {
+	best := math.Inf(-1)
+	var walk func(path []Coordinate)
+	walk = func(path []Coordinate) {
+		last := path[len(path)-1]
+		if opts.End.isSatisfiedBy(&g, last) {
+			profit, stats, withForests := placeLandscapesForObjective(g, path, &opts)
+			if score, ok := opts.score(profit, stats, len(path), withForests.countCards()); ok && score > best {
+				best = score
+			}
+		}
+		if len(path) == maxLen {
+			return
+		}
+		var inner, border []Coordinate
+		for _, next := range []Coordinate{{X: last.X, Y: last.Y - 1}, {X: last.X, Y: last.Y + 1}, {X: last.X - 1, Y: last.Y}, {X: last.X + 1, Y: last.Y}} {
+			if !g.isValidCoordinate(next) || g[next.Y][next.X] != Empty || !opts.End.canReachTarget(next, maxLen-len(path)-1) {
+				continue
+			}
+			if isBorderTile(next) && !opts.End.OnBorder && !(opts.End.IsActive() && opts.End.isSatisfiedBy(&g, next)) {
+				border = append(border, next)
+			} else {
+				inner = append(inner, next)
+			}
+		}
+		if len(inner) == 0 {
+			inner = border
+		}
+		for _, next := range inner {
+			g[next.Y][next.X] = River
+			walk(append(path, next))
+			g[next.Y][next.X] = Empty
+		}
+	}
+	g[start.Y][start.X] = River
+	walk([]Coordinate{start})
+	return best
+}
+
+func TestEndConstraintsFindBestPath(t *testing.T) {
+	g := rowRoadGrid(5)
+	start := Coordinate{X: 10, Y: 0}
+	tests := []struct {
+		name string
+		end  EndConstraint
+	}{
+		{"on border", EndConstraint{OnBorder: true}},
+		{"min road distance", EndConstraint{MinRoadDistance: 5}},
+		{"target", EndConstraint{Target: &Coordinate{X: 14, Y: 0}}},
+	}
+	for _, tt := range tests {
+		for _, maxLen := range []int{6, 8, 10} {
+			opts := SearchOptions{End: tt.end}
+			want := bestScoreByBruteForce(g, start, maxLen, opts)
+			sol, err := g.FindOptimalRiverAndForests(start, maxLen, nil, nil, false, opts)
+			if err != nil {
+				t.Errorf("%s, max length %d: %v", tt.name, maxLen, err)
+				continue
+			}
+			if !tt.end.isSatisfiedBy(&g, sol.Path[len(sol.Path)-1]) {
+				t.Errorf("%s, max length %d: path %v ends outside the constraint", tt.name, maxLen, sol.Path)
+			}
+			if math.Abs(sol.Score-want) > 1e-9 {
+				t.Errorf("%s, max length %d: score %.4f, want %.4f", tt.name, maxLen, sol.Score, want)
+			}
+		}
+	}
+}
+
+func TestObjectivesFindBestPathOfAnyLength(t *testing.T) {
+	g := rowRoadGrid(6)
+	start := Coordinate{X: 10, Y: 0}
+	objectives := []SearchOptions{
+		{Objective: ObjectiveProfit},
+		{Objective: ObjectiveProfitPerRiver},
+		{Objective: ObjectiveProfitPerCard},
+		{Objective: ObjectiveFewestCards, TargetProfit: 0.1},
+		{Objective: ObjectiveFewestCards, TargetProfit: 0.4},
+	}
+	for _, opts := range objectives {
+		for _, maxLen := range []int{6, 10} {
+			want := bestScoreByBruteForce(g, start, maxLen, opts)
+			sol, err := g.FindOptimalRiverAndForests(start, maxLen, nil, nil, false, opts)
+			if err != nil {
+				t.Errorf("%s, max length %d: %v", opts.Objective, maxLen, err)
+				continue
+			}
+			if math.Abs(sol.Score-want) > 1e-9 {
+				t.Errorf("%s, max length %d: score %.4f (%d tiles), want %.4f", opts.Objective, maxLen, sol.Score, len(sol.Path), want)
+			}
+		}
+	}
+}
+
+func TestOnBorderTakesBorderMovesBeforeDeadEnd(t *testing.T) {
+	g := rowRoadGrid(6)
+	sol, err := g.FindOptimalRiverAndForests(Coordinate{X: 10, Y: 0}, 6, nil, nil, false, SearchOptions{End: EndConstraint{OnBorder: true}})
+	if err != nil {
+		t.Fatalf("no solution: %v", err)
+	}
+	if last := sol.Path[len(sol.Path)-1]; !isBorderTile(last) {
+		t.Errorf("path %v does not end on the border", sol.Path)
+	}
+}
+
+func TestEvaluatePathChecksRiverStart(t *testing.T) {
+	g := rowRoadGrid(6)
+	g[0][5] = Rock
+	for _, tc := range []struct {
+		name  string
+		path  []Coordinate
+		valid bool
+	}{
+		{"border", []Coordinate{{X: 10, Y: 0}, {X: 10, Y: 1}}, true},
+		{"interior", []Coordinate{{X: 10, Y: 2}, {X: 10, Y: 3}}, false},
+		{"corner", []Coordinate{{X: 0, Y: 0}, {X: 0, Y: 1}}, false},
+		{"not empty", []Coordinate{{X: 5, Y: 0}, {X: 5, Y: 1}}, false},
+		{"road", []Coordinate{{X: 0, Y: 6}, {X: 1, Y: 6}}, false},
+	} {
+		_, err := g.EvaluatePath(tc.path, false, SearchOptions{})
+		if (err == nil) != tc.valid {
+			t.Errorf("%s start %v: got error %v, want valid %v", tc.name, tc.path[0], err, tc.valid)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package game
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"os"
+)
+
+// Frame delays of the placement GIF in 1/100 s.
+const (
+	gifFrameDelay = 60
+	gifLastDelay  = 300
+)
+
+// PlacementSteps splits sol into the order the cards are placed in game: step i holds the
+// road, the first i+1 river tiles of sol.Path and the landscape tiles of sol.Grid that touch
+// them, with the profit and stats of that partial grid. When sol.Grid has landscape tiles away
+// from the river (e.g. planned meadows or peaks), a last step with the complete grid is added.
+func PlacementSteps(sol RiverPathSolution, landscape LandscapeOptions) []RiverPathSolution {
+	var steps []RiverPathSolution
+	base := sol.Grid
+	for y := 0; y < GridHeight; y++ {
+		for x := 0; x < GridWidth; x++ {
+			if base[y][x] == River || IsLandscape(base[y][x]) {
+				base[y][x] = Empty
+			}
+		}
+	}
+	partial := base
+	for i, riverTile := range sol.Path {
+		partial[riverTile.Y][riverTile.X] = River
+		for _, adj := range []Coordinate{{X: riverTile.X, Y: riverTile.Y - 1}, {X: riverTile.X, Y: riverTile.Y + 1}, {X: riverTile.X - 1, Y: riverTile.Y}, {X: riverTile.X + 1, Y: riverTile.Y}} {
+			if partial.isValidCoordinate(adj) && IsLandscape(sol.Grid[adj.Y][adj.X]) {
+				partial[adj.Y][adj.X] = sol.Grid[adj.Y][adj.X]
+			}
+		}
+		stats := partial.TotalStats()
+		steps = append(steps, RiverPathSolution{Path: sol.Path[:i+1], Profit: landscape.Profit(stats), Stats: stats, Grid: partial})
+	}
+	if partial != sol.Grid {
+		stats := sol.Grid.TotalStats()
+		steps = append(steps, RiverPathSolution{Path: sol.Path, Profit: landscape.Profit(stats), Stats: stats, Grid: sol.Grid})
+	}
+	return steps
+}
+
+// WritePlacementGIF writes an animated GIF that places sol card by card (see PlacementSteps),
+// with a running profit counter in the legend. opts.Title is shown above the counter.
+func WritePlacementGIF(w io.Writer, sol RiverPathSolution, opts RenderOptions) error {
+	steps := PlacementSteps(sol, opts.Landscape)
+	if len(steps) == 0 {
+		return fmt.Errorf("solution has no river path to animate")
+	}
+
+	// Render every frame first; the legend grows as tile types appear, so frames are padded to the tallest
+	var frames []*image.RGBA
+	bounds := image.Rectangle{}
+	for i, step := range steps {
+		title := fmt.Sprintf("Card %d/%d", i+1, len(sol.Path))
+		if i >= len(sol.Path) {
+			title = "Complete"
+		}
+		if opts.Title != "" {
+			title = opts.Title + " - " + title
+		}
+		frameOpts := opts
+		frameOpts.Title = title
+		frame := RenderSolution(step, frameOpts)
+		frames = append(frames, frame)
+		bounds = bounds.Union(frame.Bounds())
+	}
+
+	pal, exact := framePalette(frames)
+	anim := &gif.GIF{Config: image.Config{ColorModel: pal, Width: bounds.Dx(), Height: bounds.Dy()}}
+	for i, frame := range frames {
+		paletted := image.NewPaletted(bounds, pal)
+		draw.Draw(paletted, bounds, image.NewUniform(BackgroundColor), image.Point{}, draw.Src)
+		if exact {
+			draw.Draw(paletted, frame.Bounds(), frame, image.Point{}, draw.Src)
+		} else {
+			draw.FloydSteinberg.Draw(paletted, frame.Bounds(), frame, image.Point{})
+		}
+		delay := gifFrameDelay
+		if i == len(frames)-1 {
+			delay = gifLastDelay
+		}
+		anim.Image = append(anim.Image, paletted)
+		anim.Delay = append(anim.Delay, delay)
+	}
+	return gif.EncodeAll(w, anim)
+}
+
+// framePalette returns the exact colours used by the frames (the renderer only draws flat colours).
+// If there are more than a GIF can hold, it returns the Plan 9 palette and false.
+func framePalette(frames []*image.RGBA) (color.Palette, bool) {
+	seen := map[color.RGBA]bool{BackgroundColor: true}
+	pal := color.Palette{BackgroundColor}
+	for _, frame := range frames {
+		for i := 0; i+3 < len(frame.Pix); i += 4 {
+			c := color.RGBA{R: frame.Pix[i], G: frame.Pix[i+1], B: frame.Pix[i+2], A: frame.Pix[i+3]}
+			if seen[c] {
+				continue
+			}
+			if len(pal) == 256 {
+				return palette.Plan9, false
+			}
+			seen[c] = true
+			pal = append(pal, c)
+		}
+	}
+	return pal, true
+}
+
+// SavePlacementGIF writes the placement GIF of sol to the file at path, replacing it if it exists.
+func SavePlacementGIF(path string, sol RiverPathSolution, opts RenderOptions) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WritePlacementGIF(file, sol, opts); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
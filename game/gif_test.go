@@ -0,0 +1,59 @@
+package game
+
+import (
+	"bytes"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+func TestWritePlacementGIF(t *testing.T) {
+	sol := renderSample(t)
+	var buf bytes.Buffer
+	if err := WritePlacementGIF(&buf, sol, RenderOptions{}); err != nil {
+		t.Fatalf("WritePlacementGIF: %v", err)
+	}
+	anim, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatalf("decoding the GIF: %v", err)
+	}
+	// One frame per river card, then the complete grid with the rock away from the river
+	if len(anim.Image) != len(sol.Path)+1 {
+		t.Fatalf("%d frames, want %d", len(anim.Image), len(sol.Path)+1)
+	}
+	if anim.Config.Width != GridWidth*32 || anim.Config.Height <= GridHeight*32 {
+		t.Errorf("GIF is %dx%d, want %d wide with a legend below %d", anim.Config.Width, anim.Config.Height, GridWidth*32, GridHeight*32)
+	}
+	for i, frame := range anim.Image {
+		if frame.Bounds().Dx() != anim.Config.Width || frame.Bounds().Dy() != anim.Config.Height {
+			t.Errorf("frame %d is %v, want the full %dx%d", i, frame.Bounds(), anim.Config.Width, anim.Config.Height)
+		}
+	}
+	if last := anim.Delay[len(anim.Delay)-1]; anim.Delay[0] != gifFrameDelay || last != gifLastDelay {
+		t.Errorf("delays %v, want %d and %d on the last frame", anim.Delay, gifFrameDelay, gifLastDelay)
+	}
+
+	for _, tc := range []struct {
+		frame, x, y int
+		want        color.RGBA
+	}{
+		{0, 10, 0, tileColors[River]}, // The start and the forests beside it
+		{0, 9, 0, tileColors[Forest]},
+		{0, 11, 1, tileColors[Empty]},
+		{0, 13, 0, tileColors[Empty]},
+		{0, 3, 10, tileColors[Empty]},
+		{0, 0, 6, tileColors[Road]},
+		{len(sol.Path) - 1, 13, 0, tileColors[Forest]},
+		{len(sol.Path) - 1, 3, 10, tileColors[Empty]},
+		{len(sol.Path), 11, 1, tileColors[River]},
+		{len(sol.Path), 3, 10, tileColors[Rock]},
+	} {
+		if got := tilePixel(anim.Image[tc.frame], tc.x, tc.y, 32); got != tc.want {
+			t.Errorf("frame %d: tile (%d,%d) is %v, want %v", tc.frame, tc.x, tc.y, got, tc.want)
+		}
+	}
+
+	if err := WritePlacementGIF(&bytes.Buffer{}, RiverPathSolution{Grid: rowRoadGrid(6)}, RenderOptions{}); err == nil {
+		t.Error("WritePlacementGIF accepted a board with nothing to place")
+	}
+}
@@ -0,0 +1,257 @@
+package game
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Stats holds the hero stat bonuses produced by landscape tiles.
+// It is also used as a set of weights when turning stats into a single objective value.
+type Stats struct {
+	HP          float64 `json:"hp"`           // Max HP bonus as a fraction (0.01 = 1%)
+	Regen       float64 `json:"regen"`        // HP regeneration in HP per day
+	AttackSpeed float64 `json:"attack_speed"` // Attack speed bonus as a fraction (0.02 = 2%)
+}
+
+// Add returns the component-wise sum of s and o.
+func (s Stats) Add(o Stats) Stats {
+	return Stats{HP: s.HP + o.HP, Regen: s.Regen + o.Regen, AttackSpeed: s.AttackSpeed + o.AttackSpeed}
+}
+
+// Scale returns s with every component multiplied by f.
+func (s Stats) Scale(f float64) Stats {
+	return Stats{HP: s.HP * f, Regen: s.Regen * f, AttackSpeed: s.AttackSpeed * f}
+}
+
+// Dot returns the weighted sum of s using w as the weights.
+func (s Stats) Dot(w Stats) float64 {
+	return s.HP*w.HP + s.Regen*w.Regen + s.AttackSpeed*w.AttackSpeed
+}
+
+// String formats the non-zero stats, e.g. "Speed +12.00% HP +4.00%".
+func (s Stats) String() string {
+	var parts []string
+	if s.AttackSpeed != 0 {
+		parts = append(parts, fmt.Sprintf("Speed +%.2f%%", s.AttackSpeed*100))
+	}
+	if s.HP != 0 {
+		parts = append(parts, fmt.Sprintf("HP +%.2f%%", s.HP*100))
+	}
+	if s.Regen != 0 {
+		parts = append(parts, fmt.Sprintf("Regen +%.1f/day", s.Regen))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, " ")
+}
+
+// LandscapeBaseStats lists what a single tile of each landscape type contributes
+// before the river bonus. Every adjacent river tile doubles this base, exactly as for forests.
+var LandscapeBaseStats = map[TileType]Stats{
+	Forest:   {AttackSpeed: 0.02},
+	Thicket:  {AttackSpeed: 0.04},
+	Rock:     {HP: 0.01},
+	Mountain: {HP: 0.02},
+	Meadow:   {Regen: 2},
+	// Each tile of a 3x3 Mountain Peak formation (see MarkMountainPeaks).
+	MountainPeak: {HP: 0.03},
+}
+
+// LandscapeTypes lists the landscape tile types in a stable order.
+var LandscapeTypes = []TileType{Forest, Thicket, Rock, Mountain, Meadow}
+
+// DefaultStatWeights scores attack speed only, which reproduces the classic forest profit.
+var DefaultStatWeights = Stats{AttackSpeed: 1}
+
+// LandscapeOptions controls which landscape cards are placed next to the river
+// and how their stats are turned into the profit value.
+// The zero value places unlimited forests and scores attack speed only.
+type LandscapeOptions struct {
+	Weights Stats            `json:"weights"` // Objective weights; the zero value means DefaultStatWeights
+	Budgets map[TileType]int `json:"budgets"` // Cards per landscape type. nil = unlimited forests only; a missing type = 0 cards; negative = unlimited
+}
+
+// weights returns the effective objective weights.
+func (o *LandscapeOptions) weights() Stats {
+	if o.Weights == (Stats{}) {
+		return DefaultStatWeights
+	}
+	return o.Weights
+}
+
+// Profit returns the weighted value of s under these options.
+func (o *LandscapeOptions) Profit(s Stats) float64 {
+	return s.Dot(o.weights())
+}
+
+// budget returns how many cards of type t may be placed (-1 = unlimited).
+func (o *LandscapeOptions) budget(t TileType) int {
+	if o.Budgets == nil {
+		if t == Forest {
+			return -1
+		}
+		return 0
+	}
+	n, ok := o.Budgets[t]
+	if !ok {
+		return 0
+	}
+	if n < 0 {
+		return -1
+	}
+	return n
+}
+
+// withoutMeadows returns a copy of o with no meadow budget.
+func (o *LandscapeOptions) withoutMeadows() LandscapeOptions {
+	c := *o
+	if o.Budgets != nil {
+		c.Budgets = make(map[TileType]int, len(o.Budgets))
+		for t, n := range o.Budgets {
+			if t != Meadow {
+				c.Budgets[t] = n
+			}
+		}
+	}
+	return c
+}
+
+// TileValue returns the weighted value of one tile of type t placed on the river bank, before the river bonus.
+// A meadow on the river bank always touches the river, so it is valued as a Blooming Meadow.
+func (o *LandscapeOptions) TileValue(t TileType) float64 {
+	if t == Meadow {
+		return BloomingMeadowStats.Dot(o.weights())
+	}
+	return LandscapeBaseStats[t].Dot(o.weights())
+}
+
+// IsLandscape reports whether t is a landscape tile that benefits from adjacent rivers.
+func IsLandscape(t TileType) bool {
+	_, ok := LandscapeBaseStats[t]
+	return ok
+}
+
+// AdjacentRiverCount returns how many River tiles touch c (Up, Down, Left, Right).
+func (g *Grid) AdjacentRiverCount(c Coordinate) int {
+	count := 0
+	for _, adj := range []Coordinate{{X: c.X, Y: c.Y - 1}, {X: c.X, Y: c.Y + 1}, {X: c.X - 1, Y: c.Y}, {X: c.X + 1, Y: c.Y}} {
+		if g.isValidCoordinate(adj) && g[adj.Y][adj.X] == River {
+			count++
+		}
+	}
+	return count
+}
+
+// TileStats returns the stats contributed by the landscape tile at c, including the river bonus.
+// Non-landscape tiles contribute nothing.
+func (g *Grid) TileStats(c Coordinate) Stats {
+	if g[c.Y][c.X] == Meadow {
+		return g.meadowStats(c)
+	}
+	base, ok := LandscapeBaseStats[g[c.Y][c.X]]
+	if !ok {
+		return Stats{}
+	}
+	return base.Scale(riverMultiplier(g.AdjacentRiverCount(c)))
+}
+
+// riverMultiplier returns the factor applied to a landscape's base stats:
+// 2 * NumberOfAdjacentRiverTiles next to a river, 1 (the plain base value) otherwise.
+func riverMultiplier(adjacentRiverCount int) float64 {
+	if adjacentRiverCount == 0 {
+		return 1.0
+	}
+	return 2.0 * float64(adjacentRiverCount)
+}
+
+// WithoutLandscapes returns a copy of g with every landscape tile turned back into Empty.
+// Road, Forbidden and River tiles are kept.
+func (g Grid) WithoutLandscapes() Grid {
+	for y := 0; y < GridHeight; y++ {
+		for x := 0; x < GridWidth; x++ {
+			if IsLandscape(g[y][x]) {
+				g[y][x] = Empty
+			}
+		}
+	}
+	return g
+}
+
+// PlaceLandscapes fills the Empty tiles adjacent to riverPath with landscape cards chosen by opts
+// and returns the resulting profit and stats of the whole grid.
+// It is used to re-plant the river banks after other planners have claimed some of the tiles.
+func (g *Grid) PlaceLandscapes(riverPath []Coordinate, opts LandscapeOptions) (float64, Stats) {
+	placeLandscapes(g, riverPath, &opts)
+	stats := g.TotalStats()
+	return opts.Profit(stats), stats
+}
+
+// TotalStats sums TileStats over the whole grid.
+func (g *Grid) TotalStats() Stats {
+	var total Stats
+	for y := 0; y < GridHeight; y++ {
+		for x := 0; x < GridWidth; x++ {
+			total = total.Add(g.TileStats(Coordinate{X: x, Y: y}))
+		}
+	}
+	return total
+}
+
+// placeLandscapes fills the Empty tiles adjacent to the river with landscape cards.
+// A tile's value is (2 * adjacent rivers) * TileValue(type), so giving the tiles with the most
+// adjacent rivers the most valuable card types still in budget is optimal.
+func placeLandscapes(workingGrid *Grid, riverPath []Coordinate, opts *LandscapeOptions) {
+	type spot struct {
+		Coord         Coordinate
+		AdjacentRiver int
+	}
+	seen := make(map[Coordinate]bool)
+	var spots []spot
+	for _, riverTile := range riverPath {
+		adjacents := []Coordinate{
+			{X: riverTile.X, Y: riverTile.Y - 1}, {X: riverTile.X, Y: riverTile.Y + 1},
+			{X: riverTile.X - 1, Y: riverTile.Y}, {X: riverTile.X + 1, Y: riverTile.Y},
+		}
+		for _, adj := range adjacents {
+			if workingGrid.isValidCoordinate(adj) && workingGrid[adj.Y][adj.X] == Empty && !seen[adj] {
+				seen[adj] = true
+				spots = append(spots, spot{Coord: adj, AdjacentRiver: workingGrid.AdjacentRiverCount(adj)})
+			}
+		}
+	}
+
+	// Card types worth placing, most valuable first, with their remaining budgets
+	var types []TileType
+	remaining := make(map[TileType]int)
+	limited := false
+	for _, t := range LandscapeTypes {
+		if b := opts.budget(t); b != 0 && opts.TileValue(t) > 0 {
+			types = append(types, t)
+			remaining[t] = b
+			if b > 0 {
+				limited = true
+			}
+		}
+	}
+	sort.SliceStable(types, func(i, j int) bool { return opts.TileValue(types[i]) > opts.TileValue(types[j]) })
+
+	// With limited budgets the best spots must get the best cards
+	if limited {
+		sort.SliceStable(spots, func(i, j int) bool { return spots[i].AdjacentRiver > spots[j].AdjacentRiver })
+	}
+
+	for _, s := range spots {
+		for _, t := range types {
+			if remaining[t] == 0 {
+				continue
+			}
+			workingGrid[s.Coord.Y][s.Coord.X] = t
+			if remaining[t] > 0 {
+				remaining[t]--
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package game
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRiverMultiplier(t *testing.T) {
+	for rivers, want := range []float64{1, 2, 4, 6, 8} {
+		if got := riverMultiplier(rivers); got != want {
+			t.Errorf("riverMultiplier(%d) = %v, want %v", rivers, got, want)
+		}
+	}
+}
+
+func TestTileStatsRiverBonus(t *testing.T) {
+	g := NewGrid()
+	g[5][5] = River
+	g[5][7] = River
+	g[5][6] = Forest  // Between two river tiles
+	g[4][5] = Forest  // Next to one river tile
+	g[0][0] = Forest  // No river nearby
+	g[0][20] = Rock   // No river nearby
+	g[6][5] = Thicket // Next to one river tile
+
+	tests := []struct {
+		c    Coordinate
+		want Stats
+	}{
+		{Coordinate{X: 6, Y: 5}, Stats{AttackSpeed: 0.08}},
+		{Coordinate{X: 5, Y: 4}, Stats{AttackSpeed: 0.04}},
+		{Coordinate{X: 0, Y: 0}, Stats{AttackSpeed: 0.02}},
+		{Coordinate{X: 20, Y: 0}, Stats{HP: 0.01}},
+		{Coordinate{X: 5, Y: 6}, Stats{AttackSpeed: 0.08}},
+		{Coordinate{X: 5, Y: 5}, Stats{}}, // River tiles give nothing themselves
+	}
+	for _, tt := range tests {
+		if got := g.TileStats(tt.c); !statsEqual(got, tt.want) {
+			t.Errorf("TileStats(%v) = %+v, want %+v", tt.c, got, tt.want)
+		}
+	}
+	if got, want := g.TotalStats(), (Stats{HP: 0.01, AttackSpeed: 0.22}); !statsEqual(got, want) {
+		t.Errorf("TotalStats() = %+v, want %+v", got, want)
+	}
+}
+
+// statsEqual compares stats up to floating point rounding.
+func statsEqual(a, b Stats) bool {
+	const eps = 1e-9
+	return math.Abs(a.HP-b.HP) < eps && math.Abs(a.Regen-b.Regen) < eps && math.Abs(a.AttackSpeed-b.AttackSpeed) < eps
+}
@@ -0,0 +1,228 @@
+package game
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// LayoutCodeVersion is the version byte written by EncodeLayoutCode.
+const LayoutCodeVersion = 1
+
+// Layout code flags (byte 35 of a version 1 code).
+const (
+	codeFlagDisableCrossAdjacency = 1 << iota
+	codeFlagEndOnBorder
+	codeFlagEndTarget
+	codeFlagWeights
+	codeFlagBudgets
+)
+
+// codeNoCell marks a missing start or end target in a layout code.
+const codeNoCell = 0xFF
+
+// A layout code is a base64url string (without padding) of these bytes:
+//
+//	0      version (LayoutCodeVersion)
+//	1-32   road bitmap: one bit per cell, row by row, most significant bit first (252 bits)
+//	33     start cell index y*GridWidth+x, or 0xFF for none
+//	34     max length
+//	35     flags (cross adjacency, end on border, end target, weights, budgets)
+//	36     end target cell index, or 0xFF for none
+//	37     minimum end distance from the road
+//	38     objective
+//	39-40  target profit in 1/1000 (uint16)
+//	       [weights: hp, regen, attack speed as float32, with codeFlagWeights]
+//	       [budgets: count, then count pairs of tile type and int8 cards (-1 = unlimited), with codeFlagBudgets]
+//	last 2 bytes: low 16 bits of the CRC-32 (IEEE) of everything before them
+//
+// The code describes the road, start and rules; solutions are not included.
+
+// EncodeLayoutCode returns the shareable code for the road, start and rules of p.
+func EncodeLayoutCode(p PlanFile) (string, error) {
+	data := []byte{LayoutCodeVersion}
+
+	var roads [32]byte
+	var g Grid
+	for _, road := range p.Roads {
+		if !g.isValidCoordinate(road) {
+			return "", fmt.Errorf("road tile (%d, %d) is outside the grid", road.X, road.Y)
+		}
+		index := road.Y*GridWidth + road.X
+		roads[index/8] |= 0x80 >> (index % 8)
+	}
+	data = append(data, roads[:]...)
+
+	cellIndex := func(c *Coordinate) (byte, error) {
+		if c == nil {
+			return codeNoCell, nil
+		}
+		if !g.isValidCoordinate(*c) {
+			return 0, fmt.Errorf("cell (%d, %d) is outside the grid", c.X, c.Y)
+		}
+		return byte(c.Y*GridWidth + c.X), nil
+	}
+	start, err := cellIndex(p.Start)
+	if err != nil {
+		return "", fmt.Errorf("river start: %w", err)
+	}
+	if p.MaxLength < 0 || p.MaxLength > math.MaxUint8 {
+		return "", fmt.Errorf("max length %d does not fit in a layout code", p.MaxLength)
+	}
+	rules := p.Rules
+	var flags byte
+	if rules.DisableCrossRiverAdjacency {
+		flags |= codeFlagDisableCrossAdjacency
+	}
+	if rules.End.OnBorder {
+		flags |= codeFlagEndOnBorder
+	}
+	if rules.End.Target != nil {
+		flags |= codeFlagEndTarget
+	}
+	if rules.Landscape.Weights != (Stats{}) {
+		flags |= codeFlagWeights
+	}
+	if rules.Landscape.Budgets != nil {
+		flags |= codeFlagBudgets
+	}
+	target, err := cellIndex(rules.End.Target)
+	if err != nil {
+		return "", fmt.Errorf("end target: %w", err)
+	}
+	if rules.End.MinRoadDistance < 0 || rules.End.MinRoadDistance > math.MaxUint8 {
+		return "", fmt.Errorf("minimum road distance %d does not fit in a layout code", rules.End.MinRoadDistance)
+	}
+	if _, ok := objectiveNames[rules.Objective]; !ok {
+		return "", fmt.Errorf("unknown objective %d", int(rules.Objective))
+	}
+	targetProfit := math.Round(rules.TargetProfit * 1000)
+	if targetProfit < 0 || targetProfit > math.MaxUint16 {
+		return "", fmt.Errorf("target profit %g does not fit in a layout code", rules.TargetProfit)
+	}
+	data = append(data, start, byte(p.MaxLength), flags, target, byte(rules.End.MinRoadDistance), byte(rules.Objective))
+	data = binary.BigEndian.AppendUint16(data, uint16(targetProfit))
+
+	if flags&codeFlagWeights != 0 {
+		for _, w := range []float64{rules.Landscape.Weights.HP, rules.Landscape.Weights.Regen, rules.Landscape.Weights.AttackSpeed} {
+			data = binary.BigEndian.AppendUint32(data, math.Float32bits(float32(w)))
+		}
+	}
+	if flags&codeFlagBudgets != 0 {
+		var budgets []byte
+		for _, t := range LandscapeTypes {
+			n, ok := rules.Landscape.Budgets[t]
+			if !ok {
+				continue
+			}
+			if n < 0 {
+				n = -1
+			}
+			if n > math.MaxInt8 {
+				return "", fmt.Errorf("%s budget %d does not fit in a layout code", tileNames[t], n)
+			}
+			budgets = append(budgets, byte(t), byte(int8(n)))
+		}
+		data = append(data, byte(len(budgets)/2))
+		data = append(data, budgets...)
+	}
+
+	data = binary.BigEndian.AppendUint16(data, uint16(crc32.ChecksumIEEE(data)))
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeLayoutCode reads a code written by EncodeLayoutCode. Surrounding whitespace is ignored.
+func DecodeLayoutCode(code string) (PlanFile, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(code))
+	if err != nil {
+		return PlanFile{}, fmt.Errorf("layout code is not valid base64url: %w", err)
+	}
+	if len(data) < 1 {
+		return PlanFile{}, fmt.Errorf("layout code is empty")
+	}
+	if data[0] != LayoutCodeVersion {
+		return PlanFile{}, fmt.Errorf("unsupported layout code version %d", data[0])
+	}
+	const fixedLength = 41 // Version byte up to and including the target profit
+	if len(data) < fixedLength+2 {
+		return PlanFile{}, fmt.Errorf("layout code is too short (%d bytes)", len(data))
+	}
+	body, checksum := data[:len(data)-2], binary.BigEndian.Uint16(data[len(data)-2:])
+	if uint16(crc32.ChecksumIEEE(body)) != checksum {
+		return PlanFile{}, fmt.Errorf("layout code checksum mismatch (mistyped or truncated code?)")
+	}
+
+	p := PlanFile{Version: PlanFileVersion}
+	for index := 0; index < GridWidth*GridHeight; index++ {
+		if body[1+index/8]&(0x80>>(index%8)) != 0 {
+			p.Roads = append(p.Roads, Coordinate{X: index % GridWidth, Y: index / GridWidth})
+		}
+	}
+	cell := func(b byte) (*Coordinate, error) {
+		if b == codeNoCell {
+			return nil, nil
+		}
+		if int(b) >= GridWidth*GridHeight {
+			return nil, fmt.Errorf("cell index %d is outside the grid", b)
+		}
+		return &Coordinate{X: int(b) % GridWidth, Y: int(b) / GridWidth}, nil
+	}
+	if p.Start, err = cell(body[33]); err != nil {
+		return PlanFile{}, fmt.Errorf("river start: %w", err)
+	}
+	p.MaxLength = int(body[34])
+	flags := body[35]
+	p.Rules.DisableCrossRiverAdjacency = flags&codeFlagDisableCrossAdjacency != 0
+	p.Rules.End.OnBorder = flags&codeFlagEndOnBorder != 0
+	if flags&codeFlagEndTarget != 0 {
+		if p.Rules.End.Target, err = cell(body[36]); err != nil {
+			return PlanFile{}, fmt.Errorf("end target: %w", err)
+		}
+	}
+	p.Rules.End.MinRoadDistance = int(body[37])
+	p.Rules.Objective = Objective(body[38])
+	if _, ok := objectiveNames[p.Rules.Objective]; !ok {
+		return PlanFile{}, fmt.Errorf("unknown objective %d", body[38])
+	}
+	p.Rules.TargetProfit = float64(binary.BigEndian.Uint16(body[39:41])) / 1000
+
+	rest := body[fixedLength:]
+	if flags&codeFlagWeights != 0 {
+		if len(rest) < 12 {
+			return PlanFile{}, fmt.Errorf("layout code is missing its weights")
+		}
+		var weights [3]float64
+		for i := range weights {
+			// Going through the shortest decimal form turns e.g. float32(0.005) back into exactly 0.005
+			f := math.Float32frombits(binary.BigEndian.Uint32(rest[i*4:]))
+			weights[i], _ = strconv.ParseFloat(strconv.FormatFloat(float64(f), 'g', -1, 32), 64)
+		}
+		p.Rules.Landscape.Weights = Stats{HP: weights[0], Regen: weights[1], AttackSpeed: weights[2]}
+		rest = rest[12:]
+	}
+	if flags&codeFlagBudgets != 0 {
+		if len(rest) < 1 || len(rest) < 1+2*int(rest[0]) {
+			return PlanFile{}, fmt.Errorf("layout code is missing its budgets")
+		}
+		p.Rules.Landscape.Budgets = make(map[TileType]int)
+		for i := 0; i < int(rest[0]); i++ {
+			t := TileType(rest[1+2*i])
+			if !IsLandscape(t) {
+				return PlanFile{}, fmt.Errorf("budget for unknown landscape type %d", t)
+			}
+			p.Rules.Landscape.Budgets[t] = int(int8(rest[2+2*i]))
+		}
+		rest = rest[1+2*int(rest[0]):]
+	}
+	if len(rest) != 0 {
+		return PlanFile{}, fmt.Errorf("layout code has %d unexpected trailing bytes", len(rest))
+	}
+	if err := p.validate(); err != nil {
+		return PlanFile{}, err
+	}
+	return p, nil
+}
@@ -0,0 +1,113 @@
+package game
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLayoutCodeRoundTrip(t *testing.T) {
+	full := samplePlan(t, false)
+	plain := PlanFile{Version: PlanFileVersion, Roads: full.Roads, MaxLength: 35}
+	solved := samplePlan(t, true)
+	for _, tt := range []struct {
+		name string
+		plan PlanFile
+		want PlanFile
+	}{
+		{"every rule", full, full},
+		{"no rules", plain, plain},
+		{"solution is dropped", solved, full},
+	} {
+		code, err := EncodeLayoutCode(tt.plan)
+		if err != nil {
+			t.Fatalf("%s: EncodeLayoutCode: %v", tt.name, err)
+		}
+		got, err := DecodeLayoutCode("  " + code + "\n")
+		if err != nil {
+			t.Fatalf("%s: DecodeLayoutCode(%q): %v", tt.name, code, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: decoded\n%+v\nwant\n%+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestLayoutCodeIsStable pins the code of a plain layout, so shared codes keep working.
+func TestLayoutCodeIsStable(t *testing.T) {
+	const want = "AQAAAAAAAAAAAAAAAAAAAAP__-AAAAAAAAAAAAAAAAAACiMA_wAAAABRiw"
+	roads := rowRoadGrid(6)
+	p := PlanFile{Version: PlanFileVersion, Roads: roads.RoadTiles(), MaxLength: 35, Start: &Coordinate{X: 10, Y: 0}}
+	code, err := EncodeLayoutCode(p)
+	if err != nil {
+		t.Fatalf("EncodeLayoutCode: %v", err)
+	}
+	if code != want {
+		t.Errorf("code %q, want %q", code, want)
+	}
+}
+
+// recodeLayout re-encodes the bytes of a layout code with a valid checksum after edit changed them.
+func recodeLayout(t *testing.T, code string, edit func(body []byte) []byte) string {
+	t.Helper()
+	data, err := base64.RawURLEncoding.DecodeString(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := edit(append([]byte(nil), data[:len(data)-2]...))
+	body = binary.BigEndian.AppendUint16(body, uint16(crc32.ChecksumIEEE(body)))
+	return base64.RawURLEncoding.EncodeToString(body)
+}
+
+func TestDecodeLayoutCodeRejectsBadInput(t *testing.T) {
+	code, err := EncodeLayoutCode(samplePlan(t, false))
+	if err != nil {
+		t.Fatalf("EncodeLayoutCode: %v", err)
+	}
+	data, _ := base64.RawURLEncoding.DecodeString(code)
+	flipped := append([]byte(nil), data...)
+	flipped[5] ^= 0x10 // A road bit, without fixing the checksum
+
+	tests := []struct {
+		name string
+		code string
+		want string // Part of the error
+	}{
+		{"empty", "", "empty"},
+		{"not base64url", code[:10] + "+/" + code[12:], "base64url"},
+		{"bad checksum", base64.RawURLEncoding.EncodeToString(flipped), "checksum"},
+		{"truncated", code[:len(code)-4], "checksum"},
+		{"too short", code[:20], "too short"},
+		{"bad version", recodeLayout(t, code, func(b []byte) []byte { b[0] = 2; return b }), "version"},
+		{"start outside the grid", recodeLayout(t, code, func(b []byte) []byte { b[33] = 252; return b }), "outside the grid"},
+		{"unknown objective", recodeLayout(t, code, func(b []byte) []byte { b[38] = 99; return b }), "objective"},
+		{"trailing bytes", recodeLayout(t, code, func(b []byte) []byte { return append(b, 0) }), "trailing"},
+		{"missing budgets", recodeLayout(t, code, func(b []byte) []byte { return b[:len(b)-2] }), "budgets"},
+	}
+	for _, tt := range tests {
+		_, err := DecodeLayoutCode(tt.code)
+		if err == nil {
+			t.Errorf("%s: DecodeLayoutCode(%q) accepted the code", tt.name, tt.code)
+		} else if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %q, want one about %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestEncodeLayoutCodeRejectsValuesThatDoNotFit(t *testing.T) {
+	for name, edit := range map[string]func(p *PlanFile){
+		"road outside the grid": func(p *PlanFile) { p.Roads = append(p.Roads, Coordinate{X: 21, Y: 0}) },
+		"max length":            func(p *PlanFile) { p.MaxLength = 300 },
+		"target profit":         func(p *PlanFile) { p.Rules.TargetProfit = 100 },
+		"budget":                func(p *PlanFile) { p.Rules.Landscape.Budgets[Forest] = 200 },
+	} {
+		p := samplePlan(t, false)
+		edit(&p)
+		if _, err := EncodeLayoutCode(p); err == nil {
+			t.Errorf("%s: EncodeLayoutCode accepted it", name)
+		}
+	}
+}
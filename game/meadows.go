@@ -0,0 +1,104 @@
+package game
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// BloomingMeadowStats is what a meadow gives once it blooms (before the river bonus).
+// A plain meadow gives LandscapeBaseStats[Meadow].
+var BloomingMeadowStats = Stats{Regen: 3}
+
+// MeadowPlan is the result of PlanMeadows.
+type MeadowPlan struct {
+	Grid     Grid
+	Meadows  []Coordinate // Tiles where a meadow was placed
+	Blooming int          // How many of the placed meadows bloom
+	Regen    float64      // Total HP regeneration per day of the whole grid
+}
+
+// bloomsMeadow reports whether a tile of type t next to a meadow makes it bloom.
+// Any other landscape or a river counts; other meadows, roads and empty tiles do not.
+func bloomsMeadow(t TileType) bool {
+	return t == River || (IsLandscape(t) && t != Meadow)
+}
+
+// IsBloomingMeadow reports whether c would hold a Blooming Meadow, i.e. a meadow there
+// touches (Up, Down, Left, Right) any other landscape or river tile.
+func (g *Grid) IsBloomingMeadow(c Coordinate) bool {
+	for _, adj := range []Coordinate{{X: c.X, Y: c.Y - 1}, {X: c.X, Y: c.Y + 1}, {X: c.X - 1, Y: c.Y}, {X: c.X + 1, Y: c.Y}} {
+		if g.isValidCoordinate(adj) && bloomsMeadow(g[adj.Y][adj.X]) {
+			return true
+		}
+	}
+	return false
+}
+
+// meadowStats returns what a meadow at c gives, including blooming and the river bonus.
+func (g *Grid) meadowStats(c Coordinate) Stats {
+	base := LandscapeBaseStats[Meadow]
+	if g.IsBloomingMeadow(c) {
+		base = BloomingMeadowStats
+	}
+	return base.Scale(riverMultiplier(g.AdjacentRiverCount(c)))
+}
+
+// PlanMeadows places up to budget meadows on Empty tiles (never on Road or Forbidden tiles)
+// to maximise the total HP regeneration, given the rivers and landscapes already on the grid.
+// Meadows do not make each other bloom, so every tile's value is independent of the others
+// and taking the most valuable tiles is optimal. It is meant as a second pass after the river solve.
+// Debug output goes to log; nil means os.Stdout (see SearchOptions.Log).
+func (g *Grid) PlanMeadows(budget int, log io.Writer, progressCallback func(MeadowPlan), stopChannel <-chan struct{}) (MeadowPlan, error) {
+	logf(log, "Starting meadow planning with %d meadows\n", budget)
+	plan := MeadowPlan{Grid: *g}
+	if budget < 0 {
+		return plan, fmt.Errorf("meadow budget must not be negative, got %d", budget)
+	}
+
+	select {
+	case <-stopChannel:
+		logf(log, "Meadow planning was stopped prematurely via channel.\n")
+		return plan, fmt.Errorf("search stopped by user")
+	default:
+	}
+	plan.Meadows, plan.Blooming = placeMeadows(&plan.Grid, budget)
+	plan.Regen = plan.Grid.TotalStats().Regen
+	if progressCallback != nil {
+		progressCallback(plan)
+	}
+	logf(log, "Meadow planning complete. %d meadows (%d blooming), regen %.1f HP/day.\n", len(plan.Meadows), plan.Blooming, plan.Regen)
+	return plan, nil
+}
+
+// placeMeadows puts up to budget meadows (negative = no limit) on the Empty tiles of g where
+// they heal the most and returns where they went and how many of them bloom.
+func placeMeadows(g *Grid, budget int) ([]Coordinate, int) {
+	type spot struct {
+		Coord Coordinate
+		Regen float64
+	}
+	var spots []spot
+	for y := 0; y < GridHeight; y++ {
+		for x := 0; x < GridWidth; x++ {
+			if g[y][x] != Empty {
+				continue
+			}
+			c := Coordinate{X: x, Y: y}
+			spots = append(spots, spot{Coord: c, Regen: g.meadowStats(c).Regen})
+		}
+	}
+	sort.SliceStable(spots, func(i, j int) bool { return spots[i].Regen > spots[j].Regen })
+
+	var meadows []Coordinate
+	blooming := 0
+	for i := 0; i < len(spots) && (budget < 0 || i < budget); i++ {
+		c := spots[i].Coord
+		if g.IsBloomingMeadow(c) {
+			blooming++
+		}
+		g[c.Y][c.X] = Meadow
+		meadows = append(meadows, c)
+	}
+	return meadows, blooming
+}
@@ -0,0 +1,279 @@
+package game
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Objective selects how candidate river paths are ranked against each other.
+type Objective int
+
+const (
+	ObjectiveProfit         Objective = iota // Maximise raw profit (the classic behaviour)
+	ObjectiveProfitPerRiver                  // Maximise profit per river tile
+	ObjectiveProfitPerCard                   // Maximise profit per card placed (river and landscape tiles)
+	ObjectiveFewestCards                     // Reach SearchOptions.TargetProfit with as few cards as possible
+	ObjectiveMeadowHealing                   // Maximise HP regeneration; the meadow budget is planted where meadows heal most (see PlanMeadows)
+)
+
+// String returns a short human-readable name of the objective.
+func (o Objective) String() string {
+	switch o {
+	case ObjectiveProfit:
+		return "profit"
+	case ObjectiveProfitPerRiver:
+		return "profit per river tile"
+	case ObjectiveProfitPerCard:
+		return "profit per card"
+	case ObjectiveFewestCards:
+		return "fewest cards"
+	case ObjectiveMeadowHealing:
+		return "meadow healing"
+	default:
+		return fmt.Sprintf("objective(%d)", int(o))
+	}
+}
+
+// objectiveNames are the names used for objectives in plan files.
+var objectiveNames = map[Objective]string{
+	ObjectiveProfit:         "profit",
+	ObjectiveProfitPerRiver: "profit_per_river",
+	ObjectiveProfitPerCard:  "profit_per_card",
+	ObjectiveFewestCards:    "fewest_cards",
+	ObjectiveMeadowHealing:  "meadow_healing",
+}
+
+// MarshalText encodes the objective by name, e.g. "profit_per_card".
+func (o Objective) MarshalText() ([]byte, error) {
+	name, ok := objectiveNames[o]
+	if !ok {
+		return nil, fmt.Errorf("unknown objective %d", int(o))
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText decodes an objective name written by MarshalText.
+func (o *Objective) UnmarshalText(text []byte) error {
+	for objective, name := range objectiveNames {
+		if name == string(text) {
+			*o = objective
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown objective %q", text)
+}
+
+// score returns the objective value of a candidate (higher is better) and whether it
+// qualifies as a solution at all. For ObjectiveFewestCards the score is -cards plus a
+// tie-breaker below 1 that prefers the higher profit among equally cheap solutions.
+// For ObjectiveMeadowHealing it is the HP regeneration per day, whatever the weights.
+func (o *SearchOptions) score(profit float64, stats Stats, riverTiles, cards int) (float64, bool) {
+	switch o.Objective {
+	case ObjectiveProfitPerRiver:
+		return profit / float64(riverTiles), true
+	case ObjectiveProfitPerCard:
+		return profit / float64(cards), true
+	case ObjectiveFewestCards:
+		if profit < o.TargetProfit {
+			return 0, false
+		}
+		return -float64(cards) + profit/(1+profit), true
+	case ObjectiveMeadowHealing:
+		return stats.Regen, true
+	default:
+		return profit, true
+	}
+}
+
+// FormatScore describes a solution's value under the objective, e.g. "Per Card: 2.10%".
+func (o SearchOptions) FormatScore(sol RiverPathSolution) string {
+	switch o.Objective {
+	case ObjectiveProfitPerRiver:
+		return fmt.Sprintf("Per River Tile: %.2f%%", sol.Score*100)
+	case ObjectiveProfitPerCard:
+		return fmt.Sprintf("Per Card: %.2f%%", sol.Score*100)
+	case ObjectiveFewestCards:
+		return fmt.Sprintf("Cards: %d (Target %.0f%%)", sol.Cards, o.TargetProfit*100)
+	case ObjectiveMeadowHealing:
+		return fmt.Sprintf("Healing: %.1f HP/day", sol.Score)
+	default:
+		return fmt.Sprintf("Profit: %.2f%%", sol.Profit*100)
+	}
+}
+
+// BetterThan reports whether s beats other. A solution with negative Profit is "no solution yet".
+func (s RiverPathSolution) BetterThan(other RiverPathSolution) bool {
+	if s.Profit < 0 {
+		return false
+	}
+	return other.Profit < 0 || s.Score > other.Score
+}
+
+// gridBound is what scoreCeiling needs to know about the cards that were on the grid before the
+// search started (an imported board, for example). It is computed once per search by newGridBound.
+type gridBound struct {
+	value          float64 // Profit of the landscapes on the grid, every meadow counted as blooming
+	bankPairs      int     // (Empty, River) adjacencies: bank tiles that the rivers on the grid already raise
+	landscapePairs int     // (landscape, Empty) adjacencies: where a new river tile can raise a landscape on the grid
+	pairValue      float64 // Upper bound of the profit one more (landscape, river) adjacency adds
+	rivers         int     // River tiles on the grid
+	landscapes     int     // Landscape tiles on the grid
+	meadows        int     // Meadows ObjectiveMeadowHealing may plant away from the river (at most the Empty tiles)
+}
+
+// newGridBound collects the gridBound of the grid a search starts from.
+func newGridBound(grid *Grid, o *SearchOptions) *gridBound {
+	b := &gridBound{}
+	// Values are measured in what the objective scores: profit, or HP regeneration for ObjectiveMeadowHealing
+	landscape := o.Landscape
+	if o.Objective == ObjectiveMeadowHealing {
+		landscape.Weights = Stats{Regen: 1}
+		b.meadows = o.Landscape.budget(Meadow)
+	}
+	bestTileValue := 0.0
+	for _, t := range LandscapeTypes {
+		if landscape.budget(t) != 0 {
+			bestTileValue = maxFloat(bestTileValue, landscape.TileValue(t))
+		}
+	}
+	emptyTiles := 0
+	for y := 0; y < GridHeight; y++ {
+		for x := 0; x < GridWidth; x++ {
+			c := Coordinate{X: x, Y: y}
+			t := grid[y][x]
+			switch {
+			case t == River:
+				b.rivers++
+			case t == Empty:
+				emptyTiles++
+				b.bankPairs += grid.AdjacentRiverCount(c)
+			case IsLandscape(t):
+				b.landscapes++
+				// A meadow may start blooming, and each adjacent river adds at most twice the base value
+				base := landscape.Profit(LandscapeBaseStats[t])
+				if t == Meadow {
+					base = maxFloat(base, landscape.Profit(BloomingMeadowStats))
+				}
+				b.value += maxFloat(base*riverMultiplier(grid.AdjacentRiverCount(c)), landscape.Profit(grid.TileStats(c)))
+				bestTileValue = maxFloat(bestTileValue, base)
+				for _, adj := range []Coordinate{{X: x, Y: y - 1}, {X: x, Y: y + 1}, {X: x - 1, Y: y}, {X: x + 1, Y: y}} {
+					if grid.isValidCoordinate(adj) && grid[adj.Y][adj.X] == Empty {
+						b.landscapePairs++
+					}
+				}
+			}
+		}
+	}
+	b.pairValue = 2 * bestTileValue
+	if b.meadows < 0 || b.meadows > emptyTiles {
+		b.meadows = emptyTiles
+	}
+	return b
+}
+
+// scoreCeiling returns an upper bound on the score of any path that extends the current
+// path (already marked as River on grid) up to maxLen tiles. It relies on these facts:
+//   - every (landscape, river) adjacency adds at most pairValue to what the grid held before the
+//     search, whether the landscape is placed next to the river or was already there;
+//   - the tiles already in the path can only lose free neighbours, and each new river tile
+//     adds at most 3 (its fourth neighbour is the previous river tile);
+//   - bank tiles of rivers that were on the grid before can add one adjacency per such river;
+//   - for ObjectiveMeadowHealing, a meadow away from the river heals at most a blooming meadow's base.
+func (o *SearchOptions) scoreCeiling(grid *Grid, path []Coordinate, maxLen int) float64 {
+	b := o.bound
+	if b == nil {
+		b = &gridBound{}
+	}
+	pathPairs := 0
+	for _, riverTile := range path {
+		for _, adj := range []Coordinate{{X: riverTile.X, Y: riverTile.Y - 1}, {X: riverTile.X, Y: riverTile.Y + 1}, {X: riverTile.X - 1, Y: riverTile.Y}, {X: riverTile.X + 1, Y: riverTile.Y}} {
+			if grid.isValidCoordinate(adj) && (grid[adj.Y][adj.X] == Empty || IsLandscape(grid[adj.Y][adj.X])) {
+				pathPairs++
+			}
+		}
+	}
+	maxPairs := func(length int) int { return pathPairs + b.bankPairs + 3*(length-len(path)) }
+	maxProfit := func(pairs int) float64 { return b.value + b.pairValue*float64(pairs) }
+
+	switch o.Objective {
+	case ObjectiveProfitPerRiver:
+		// A ratio of two linear functions of the final length peaks at one of the ends
+		return maxFloat(maxProfit(maxPairs(len(path)))/float64(len(path)), maxProfit(maxPairs(maxLen))/float64(maxLen))
+	case ObjectiveProfitPerCard:
+		// Every adjacency beyond the ones to landscapes already on the grid needs a new landscape card
+		// with at most 4 of them, so cards >= earlier cards + length + (pairs - landscapePairs)/4.
+		// For a fixed length the ratio is monotonic on both sides of pairs = landscapePairs.
+		best := 0.0
+		for length := len(path); length <= maxLen; length++ {
+			for _, pairs := range []int{min(maxPairs(length), b.landscapePairs), maxPairs(length)} {
+				cards := float64(b.rivers+b.landscapes+length) + float64(max(pairs-b.landscapePairs, 0))/4
+				best = maxFloat(best, maxProfit(pairs)/cards)
+			}
+		}
+		return best
+	case ObjectiveFewestCards:
+		// At least one card per river tile; the tie-breaker stays below 1
+		return -float64(b.rivers+len(path)) + 1
+	case ObjectiveMeadowHealing:
+		return maxProfit(maxPairs(maxLen)) + BloomingMeadowStats.Regen*float64(b.meadows)
+	default:
+		return maxProfit(maxPairs(maxLen))
+	}
+}
+
+// maxFloat returns the larger of a and b.
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// countCards returns the number of cards on the grid: river tiles plus landscape tiles.
+func (g *Grid) countCards() int {
+	cards := 0
+	for y := 0; y < GridHeight; y++ {
+		for x := 0; x < GridWidth; x++ {
+			if g[y][x] == River || IsLandscape(g[y][x]) {
+				cards++
+			}
+		}
+	}
+	return cards
+}
+
+// trimLandscapesToTarget removes the least valuable landscape tiles placed on grid since before
+// (the grid they were placed on) as long as the profit stays at or above target, so
+// ObjectiveFewestCards does not pay for forests it does not need. Cards that were already on
+// before (an imported board, for example) are never removed. River-bank tiles never influence
+// each other's value, so removing the cheapest ones first is optimal.
+func trimLandscapesToTarget(grid, before *Grid, target float64, landscape *LandscapeOptions) (float64, Stats) {
+	stats := grid.TotalStats()
+	profit := landscape.Profit(stats)
+	if profit < target {
+		return profit, stats
+	}
+	type placed struct {
+		Coord Coordinate
+		Value float64
+	}
+	var tiles []placed
+	for y := 0; y < GridHeight; y++ {
+		for x := 0; x < GridWidth; x++ {
+			c := Coordinate{X: x, Y: y}
+			if IsLandscape(grid[y][x]) && before[y][x] == Empty {
+				tiles = append(tiles, placed{Coord: c, Value: landscape.Profit(grid.TileStats(c))})
+			}
+		}
+	}
+	sort.SliceStable(tiles, func(i, j int) bool { return tiles[i].Value < tiles[j].Value })
+	for _, t := range tiles {
+		if profit-t.Value < target {
+			break
+		}
+		grid[t.Coord.Y][t.Coord.X] = Empty
+		profit -= t.Value
+	}
+	stats = grid.TotalStats()
+	return landscape.Profit(stats), stats
+}
@@ -0,0 +1,104 @@
+package game
+
+import (
+	"math"
+	"testing"
+)
+
+// cardBoard returns a board that already holds cards, like an imported screenshot: a road along
+// row 6, 42 forests in rows 3 and 4 with a gap above column 10, a river on the left and a rock.
+func cardBoard() Grid {
+	g := rowRoadGrid(6)
+	for x := 0; x < GridWidth; x++ {
+		if x != 10 {
+			g[3][x] = Forest
+			g[4][x] = Forest
+		}
+	}
+	g[3][10] = Forest
+	g[4][10] = Forest
+	for y := 0; y < 3; y++ {
+		g[y][3] = River
+	}
+	g[1][14] = Rock
+	return g
+}
+
+func TestScoreCeilingKeepsBestPathOnBoardWithCards(t *testing.T) {
+	g := cardBoard()
+	start := Coordinate{X: 10, Y: 0}
+	objectives := []SearchOptions{
+		{Objective: ObjectiveProfit},
+		{Objective: ObjectiveProfitPerRiver},
+		{Objective: ObjectiveProfitPerCard},
+		{Objective: ObjectiveFewestCards, TargetProfit: 1.2},
+		{Objective: ObjectiveProfit, Landscape: LandscapeOptions{Weights: Stats{HP: 1, AttackSpeed: 1}, Budgets: map[TileType]int{Mountain: 4, Forest: -1}}},
+		{Objective: ObjectiveMeadowHealing, Landscape: LandscapeOptions{Budgets: map[TileType]int{Forest: -1, Meadow: 6}}},
+	}
+	for _, opts := range objectives {
+		for _, maxLen := range []int{8, 12, 15} {
+			pruned, err := g.FindOptimalRiverAndForests(start, maxLen, nil, nil, false, opts)
+			if err != nil {
+				t.Fatalf("%s, max length %d: %v", opts.Objective, maxLen, err)
+			}
+			unprunedOpts := opts
+			unprunedOpts.disablePruning = true
+			unpruned, err := g.FindOptimalRiverAndForests(start, maxLen, nil, nil, false, unprunedOpts)
+			if err != nil {
+				t.Fatalf("%s, max length %d, unpruned: %v", opts.Objective, maxLen, err)
+			}
+			if math.Abs(pruned.Score-unpruned.Score) > 1e-9 {
+				t.Errorf("%s, max length %d: pruned search scores %.4f, unpruned %.4f", opts.Objective, maxLen, pruned.Score, unpruned.Score)
+			}
+		}
+	}
+}
+
+func TestMeadowHealingPlantsMeadowBudget(t *testing.T) {
+	g := rowRoadGrid(6)
+	opts := SearchOptions{Objective: ObjectiveMeadowHealing, Landscape: LandscapeOptions{Budgets: map[TileType]int{Forest: -1, Meadow: 4}}}
+	sol, err := g.FindOptimalRiverAndForests(Coordinate{X: 10, Y: 0}, 8, nil, nil, false, opts)
+	if err != nil {
+		t.Fatalf("no solution: %v", err)
+	}
+	meadows, forests := 0, 0
+	for y := 0; y < GridHeight; y++ {
+		for x := 0; x < GridWidth; x++ {
+			switch sol.Grid[y][x] {
+			case Meadow:
+				meadows++
+			case Forest:
+				forests++
+			}
+		}
+	}
+	if meadows != 4 || forests == 0 {
+		t.Errorf("got %d meadows and %d forests, want 4 meadows and the rest of the banks as forests", meadows, forests)
+	}
+	if sol.Score != sol.Stats.Regen || sol.Score <= 0 {
+		t.Errorf("score %v, want the regeneration %v", sol.Score, sol.Stats.Regen)
+	}
+
+	withoutMeadows := opts
+	withoutMeadows.Landscape = LandscapeOptions{}
+	if sol, err := g.FindOptimalRiverAndForests(Coordinate{X: 10, Y: 0}, 8, nil, nil, false, withoutMeadows); err != nil || sol.Score != 0 {
+		t.Errorf("without a meadow budget: score %v (%v), want 0", sol.Score, err)
+	}
+}
+
+func TestFewestCardsKeepsCardsAlreadyOnTheBoard(t *testing.T) {
+	g := rowRoadGrid(6)
+	g[1][9] = Forest // Next to the river below
+	g[3][15] = Rock  // Away from it
+	opts := SearchOptions{Objective: ObjectiveFewestCards, TargetProfit: 0.01}
+	sol, err := g.EvaluatePath([]Coordinate{{X: 10, Y: 0}, {X: 10, Y: 1}, {X: 10, Y: 2}}, false, opts)
+	if err != nil {
+		t.Fatalf("EvaluatePath: %v", err)
+	}
+	if sol.Grid[1][9] != Forest || sol.Grid[3][15] != Rock {
+		t.Errorf("cards already on the board were removed: (9,1) is %v, (15,3) is %v", sol.Grid[1][9], sol.Grid[3][15])
+	}
+	if added := sol.Cards - 3 - 2; added != 0 {
+		t.Errorf("%d landscapes added, want none: the forest already on the board reaches the target", added)
+	}
+}
@@ -0,0 +1,129 @@
+package game
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// SearchOptions groups the optional rules applied by FindOptimalRiverAndForests.
+// The zero value reproduces the original behaviour (the river may end anywhere,
+// is surrounded by unlimited forests scored by attack speed, and raw profit is maximised).
+type SearchOptions struct {
+	End       EndConstraint    `json:"end"`       // Where the river is allowed to end
+	Landscape LandscapeOptions `json:"landscape"` // Which landscape cards go next to the river and how they are scored
+	Objective Objective        `json:"objective"` // How candidate paths are ranked (raw profit by default)
+	// TargetProfit is the profit ObjectiveFewestCards has to reach (0.5 = 50%).
+	TargetProfit float64 `json:"target_profit"`
+	// Log receives the search's debug output; nil means os.Stdout. Set it when stdout carries
+	// results, instead of redirecting os.Stdout, which would also affect other searches running.
+	Log io.Writer `json:"-"`
+
+	bound          *gridBound // Cards on the grid when the search started, for scoreCeiling
+	disablePruning bool       // Explore every path, even those scoreCeiling rules out (used by tests)
+}
+
+// logf writes a line of debug output to o.Log.
+func (o *SearchOptions) logf(format string, args ...any) {
+	logf(o.Log, format, args...)
+}
+
+// logf writes a line of debug output to w; nil means os.Stdout.
+func logf(w io.Writer, format string, args ...any) {
+	if w == nil {
+		w = os.Stdout
+	}
+	fmt.Fprintf(w, format, args...)
+}
+
+// EndConstraint restricts where a river path may end. A path only counts as a
+// solution when its last tile satisfies every constraint that is set.
+type EndConstraint struct {
+	OnBorder        bool        `json:"on_border"`         // Last tile must be on the grid border
+	Target          *Coordinate `json:"target,omitempty"`  // Last tile must be exactly this cell (nil = no target)
+	MinRoadDistance int         `json:"min_road_distance"` // Last tile must be at least this many tiles (Manhattan) from every Road tile; 0 disables
+}
+
+// IsActive reports whether any end constraint is set.
+func (c EndConstraint) IsActive() bool {
+	return c.OnBorder || c.Target != nil || c.MinRoadDistance > 0
+}
+
+// String returns a short human-readable description, e.g. "border + road dist >= 3".
+func (c EndConstraint) String() string {
+	if !c.IsActive() {
+		return "anywhere"
+	}
+	var parts []string
+	if c.OnBorder {
+		parts = append(parts, "border")
+	}
+	if c.Target != nil {
+		parts = append(parts, fmt.Sprintf("cell (%d,%d)", c.Target.X, c.Target.Y))
+	}
+	if c.MinRoadDistance > 0 {
+		parts = append(parts, fmt.Sprintf("road dist >= %d", c.MinRoadDistance))
+	}
+	return strings.Join(parts, " + ")
+}
+
+// validate checks that the constraint can be met at all on the given grid.
+func (c EndConstraint) validate(g *Grid) error {
+	if c.Target != nil {
+		if !g.isValidCoordinate(*c.Target) {
+			return fmt.Errorf("end target (%d, %d) is outside the grid", c.Target.X, c.Target.Y)
+		}
+		if g[c.Target.Y][c.Target.X] != Empty {
+			return fmt.Errorf("end target (%d, %d) is not Empty", c.Target.X, c.Target.Y)
+		}
+	}
+	if c.MinRoadDistance < 0 {
+		return fmt.Errorf("minimum road distance must not be negative, got %d", c.MinRoadDistance)
+	}
+	return nil
+}
+
+// isSatisfiedBy reports whether a path ending on lastTile meets every constraint.
+func (c EndConstraint) isSatisfiedBy(g *Grid, lastTile Coordinate) bool {
+	if c.OnBorder && !isBorderTile(lastTile) {
+		return false
+	}
+	if c.Target != nil && (lastTile.X != c.Target.X || lastTile.Y != c.Target.Y) {
+		return false
+	}
+	if c.MinRoadDistance > 0 && g.roadDistance(lastTile) < c.MinRoadDistance {
+		return false
+	}
+	return true
+}
+
+// canReachTarget reports whether the target (if any) is still reachable from
+// tile with at most remaining additional river tiles.
+func (c EndConstraint) canReachTarget(tile Coordinate, remaining int) bool {
+	if c.Target == nil {
+		return true
+	}
+	return abs(tile.X-c.Target.X)+abs(tile.Y-c.Target.Y) <= remaining
+}
+
+// isBorderTile reports whether c lies on the outer edge of the grid.
+func isBorderTile(c Coordinate) bool {
+	return c.X == 0 || c.X == GridWidth-1 || c.Y == 0 || c.Y == GridHeight-1
+}
+
+// roadDistance returns the Manhattan distance from c to the nearest Road tile.
+// If the grid has no road at all, a distance larger than the grid is returned.
+func (g *Grid) roadDistance(c Coordinate) int {
+	best := GridWidth + GridHeight
+	for y := 0; y < GridHeight; y++ {
+		for x := 0; x < GridWidth; x++ {
+			if g[y][x] == Road {
+				if d := abs(x-c.X) + abs(y-c.Y); d < best {
+					best = d
+				}
+			}
+		}
+	}
+	return best
+}
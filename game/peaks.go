@@ -0,0 +1,267 @@
+package game
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// peakSize is the side length of a Mountain Peak formation.
+const peakSize = 3
+
+// PeakPlanOptions configures PlanMountainPeaks.
+type PeakPlanOptions struct {
+	Rocks     int  // Rock cards available
+	Mountains int  // Mountain cards available
+	NearRiver bool // Only place tiles that touch an existing River tile (peaks need at least one such tile)
+	// Log receives the planner's debug output; nil means os.Stdout (see SearchOptions.Log).
+	Log io.Writer
+}
+
+// PeakPlan is the result of PlanMountainPeaks.
+type PeakPlan struct {
+	Grid  Grid
+	Peaks []Coordinate // Top-left corner of every 3x3 Mountain Peak
+	HP    float64      // Total max HP bonus of the grid (0.01 = 1%)
+}
+
+// MarkMountainPeaks turns every 3x3 block made only of Rock and Mountain tiles into
+// MountainPeak tiles. Blocks are matched row by row from the top-left and never overlap.
+// It returns the top-left corner of each peak found.
+func (g *Grid) MarkMountainPeaks() []Coordinate {
+	var peaks []Coordinate
+	for y := 0; y+peakSize <= GridHeight; y++ {
+		for x := 0; x+peakSize <= GridWidth; x++ {
+			if !g.isPeakFormation(x, y) {
+				continue
+			}
+			for dy := 0; dy < peakSize; dy++ {
+				for dx := 0; dx < peakSize; dx++ {
+					g[y+dy][x+dx] = MountainPeak
+				}
+			}
+			peaks = append(peaks, Coordinate{X: x, Y: y})
+		}
+	}
+	return peaks
+}
+
+// isPeakFormation reports whether the 3x3 block with top-left (x, y) is all Rock or Mountain.
+func (g *Grid) isPeakFormation(x, y int) bool {
+	for dy := 0; dy < peakSize; dy++ {
+		for dx := 0; dx < peakSize; dx++ {
+			if t := g[y+dy][x+dx]; t != Rock && t != Mountain {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// peakCandidate is a 3x3 block of Empty tiles where a peak could be formed.
+type peakCandidate struct {
+	TopLeft Coordinate
+	Value   float64 // HP bonus of the formed peak, including river bonuses
+}
+
+// PlanMountainPeaks places rock and mountain cards on Empty tiles (Road and Forbidden tiles
+// are never used) to maximise the total max HP bonus. Cards that fit into 3x3 blocks form
+// Mountain Peaks; the rest are placed individually on the most valuable tiles.
+// The search tries every set of non-overlapping peak positions, pruning sets that cannot beat
+// the best plan found so far. progressCallback is called on every improvement.
+func (g *Grid) PlanMountainPeaks(opts PeakPlanOptions, progressCallback func(PeakPlan), stopChannel <-chan struct{}) (PeakPlan, error) {
+	logf(opts.Log, "Starting mountain peak planning with %d rocks, %d mountains, NearRiver: %t\n", opts.Rocks, opts.Mountains, opts.NearRiver)
+	if opts.Rocks < 0 || opts.Mountains < 0 {
+		return PeakPlan{Grid: *g}, fmt.Errorf("card budgets must not be negative (rocks %d, mountains %d)", opts.Rocks, opts.Mountains)
+	}
+	initialGrid := *g
+	totalCards := opts.Rocks + opts.Mountains
+	maxPeaks := totalCards / (peakSize * peakSize)
+
+	// Collect every block that could hold a peak, most valuable first
+	var candidates []peakCandidate
+	for y := 0; y+peakSize <= GridHeight; y++ {
+		for x := 0; x+peakSize <= GridWidth; x++ {
+			if value, ok := initialGrid.peakCandidateValue(x, y, opts.NearRiver); ok {
+				candidates = append(candidates, peakCandidate{TopLeft: Coordinate{X: x, Y: y}, Value: value})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Value > candidates[j].Value })
+
+	// Upper bounds for pruning: the HP already on the grid (existing rocks could still join a peak),
+	// and the best values single cards could reach, as prefix sums over the most valuable tiles
+	peakTileHP := LandscapeBaseStats[MountainPeak].HP
+	baseCeiling := 0.0
+	var singleValues []float64
+	for y := 0; y < GridHeight; y++ {
+		for x := 0; x < GridWidth; x++ {
+			c := Coordinate{X: x, Y: y}
+			adjacentRivers := initialGrid.AdjacentRiverCount(c)
+			switch initialGrid[y][x] {
+			case Rock, Mountain:
+				baseCeiling += peakTileHP * riverMultiplier(adjacentRivers)
+			case Empty:
+				if !opts.NearRiver || adjacentRivers > 0 {
+					singleValues = append(singleValues, LandscapeBaseStats[Mountain].HP*riverMultiplier(adjacentRivers))
+				}
+			default:
+				baseCeiling += initialGrid.TileStats(c).HP
+			}
+		}
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(singleValues)))
+	singlePrefix := make([]float64, len(singleValues)+1)
+	for i, v := range singleValues {
+		singlePrefix[i+1] = singlePrefix[i] + v
+	}
+	bestSingles := func(cards int) float64 {
+		if cards > len(singleValues) {
+			cards = len(singleValues)
+		}
+		return singlePrefix[cards]
+	}
+
+	best := PeakPlan{Grid: initialGrid, HP: -1}
+	var chosen []int
+	var search func(next int, chosenValue float64)
+	search = func(next int, chosenValue float64) {
+		select {
+		case <-stopChannel:
+			return
+		default:
+		}
+
+		// Evaluate the current set of peaks with the remaining cards placed individually
+		plan := initialGrid.buildPeakPlan(candidates, chosen, opts)
+		if plan.HP > best.HP {
+			best = plan
+			if progressCallback != nil {
+				progressCallback(best)
+			}
+		}
+
+		remainingCards := totalCards - len(chosen)*peakSize*peakSize
+		for i := next; i < len(candidates); i++ {
+			if len(chosen) == maxPeaks {
+				return
+			}
+			// Every later candidate is worth at most candidates[i].Value, so once the bound fails here it fails for the rest
+			ceiling := 0.0
+			for p := 1; len(chosen)+p <= maxPeaks; p++ {
+				if c := float64(p)*candidates[i].Value + bestSingles(remainingCards-p*peakSize*peakSize); c > ceiling {
+					ceiling = c
+				}
+			}
+			if baseCeiling+chosenValue+ceiling <= best.HP {
+				return
+			}
+			if overlapsChosen(candidates, chosen, i) {
+				continue
+			}
+			chosen = append(chosen, i)
+			search(i+1, chosenValue+candidates[i].Value)
+			chosen = chosen[:len(chosen)-1]
+		}
+	}
+	search(0, 0)
+
+	select {
+	case <-stopChannel:
+		logf(opts.Log, "Peak planning was stopped prematurely via channel.\n")
+		return best, fmt.Errorf("search stopped by user")
+	default:
+	}
+	logf(opts.Log, "Peak planning complete. Best HP bonus: %.2f%% with %d peak(s).\n", best.HP*100, len(best.Peaks))
+	return best, nil
+}
+
+// peakCandidateValue returns the HP bonus of a peak at top-left (x, y), and whether
+// the block can hold one (all Empty and, with nearRiver, touching the river).
+func (g *Grid) peakCandidateValue(x, y int, nearRiver bool) (float64, bool) {
+	value := 0.0
+	touchesRiver := false
+	for dy := 0; dy < peakSize; dy++ {
+		for dx := 0; dx < peakSize; dx++ {
+			if g[y+dy][x+dx] != Empty {
+				return 0, false
+			}
+			adjacentRivers := g.AdjacentRiverCount(Coordinate{X: x + dx, Y: y + dy})
+			if adjacentRivers > 0 {
+				touchesRiver = true
+			}
+			value += LandscapeBaseStats[MountainPeak].HP * riverMultiplier(adjacentRivers)
+		}
+	}
+	if nearRiver && !touchesRiver {
+		return 0, false
+	}
+	return value, true
+}
+
+// overlapsChosen reports whether candidate i shares a tile with any chosen candidate.
+func overlapsChosen(candidates []peakCandidate, chosen []int, i int) bool {
+	a := candidates[i].TopLeft
+	for _, j := range chosen {
+		b := candidates[j].TopLeft
+		if abs(a.X-b.X) < peakSize && abs(a.Y-b.Y) < peakSize {
+			return true
+		}
+	}
+	return false
+}
+
+// buildPeakPlan fills the chosen peak blocks (rocks first, since a peak tile is worth the same
+// either way) and places the leftover cards individually, mountains on the best tiles first.
+func (g Grid) buildPeakPlan(candidates []peakCandidate, chosen []int, opts PeakPlanOptions) PeakPlan {
+	rocks, mountains := opts.Rocks, opts.Mountains
+	for _, i := range chosen {
+		topLeft := candidates[i].TopLeft
+		for dy := 0; dy < peakSize; dy++ {
+			for dx := 0; dx < peakSize; dx++ {
+				if rocks > 0 {
+					g[topLeft.Y+dy][topLeft.X+dx] = Rock
+					rocks--
+				} else {
+					g[topLeft.Y+dy][topLeft.X+dx] = Mountain
+					mountains--
+				}
+			}
+		}
+	}
+
+	// Remaining Empty tiles, most adjacent rivers first
+	type spot struct {
+		Coord         Coordinate
+		AdjacentRiver int
+	}
+	var spots []spot
+	for y := 0; y < GridHeight; y++ {
+		for x := 0; x < GridWidth; x++ {
+			if g[y][x] != Empty {
+				continue
+			}
+			c := Coordinate{X: x, Y: y}
+			adjacentRivers := g.AdjacentRiverCount(c)
+			if opts.NearRiver && adjacentRivers == 0 {
+				continue
+			}
+			spots = append(spots, spot{Coord: c, AdjacentRiver: adjacentRivers})
+		}
+	}
+	sort.SliceStable(spots, func(i, j int) bool { return spots[i].AdjacentRiver > spots[j].AdjacentRiver })
+	for _, s := range spots {
+		if mountains > 0 {
+			g[s.Coord.Y][s.Coord.X] = Mountain
+			mountains--
+		} else if rocks > 0 {
+			g[s.Coord.Y][s.Coord.X] = Rock
+			rocks--
+		} else {
+			break
+		}
+	}
+
+	peaks := g.MarkMountainPeaks()
+	return PeakPlan{Grid: g, Peaks: peaks, HP: g.TotalStats().HP}
+}
@@ -0,0 +1,277 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// PlanFileVersion is the version of the plan file format written by WritePlanFile.
+// Files with a higher version are rejected by ReadPlanFile.
+const PlanFileVersion = 1
+
+// PlanFile is the JSON document used to save a road layout, the rules it was solved with
+// and (optionally) the solution. Example:
+//
+//	{
+//	  "version": 1,
+//	  "roads": [{"x": 0, "y": 6}, {"x": 1, "y": 6}],
+//	  "rules": {
+//	    "disable_cross_river_adjacency": false,
+//	    "end": {"on_border": false, "min_road_distance": 0},
+//	    "landscape": {"weights": {"hp": 0, "regen": 0, "attack_speed": 0}, "budgets": null},
+//	    "objective": "profit",
+//	    "target_profit": 0
+//	  },
+//	  "max_length": 35,
+//	  "start": {"x": 3, "y": 5},
+//	  "solution": {"path": [...], "profit": 1.16, "stats": {...}, "grid": ["RRR~F...", ...]}
+//	}
+//
+// Grids are stored as GridHeight rows of GridWidth tile symbols (see TileSymbol).
+type PlanFile struct {
+	Version   int           `json:"version"`
+	Roads     []Coordinate  `json:"roads"`
+	Rules     PlanRules     `json:"rules"`
+	MaxLength int           `json:"max_length"`
+	Start     *Coordinate   `json:"start,omitempty"`    // Selected river start (nil = none selected)
+	Solution  *PlanSolution `json:"solution,omitempty"` // nil when no solution was calculated
+}
+
+// PlanRules are the search rules stored in a plan file.
+type PlanRules struct {
+	DisableCrossRiverAdjacency bool `json:"disable_cross_river_adjacency"`
+	SearchOptions
+}
+
+// PlanSolution is a solved river stored in a plan file.
+type PlanSolution struct {
+	Path   []Coordinate `json:"path"`
+	Profit float64      `json:"profit"`
+	Stats  Stats        `json:"stats"`
+	Grid   Grid         `json:"grid"` // Road, river and landscape tiles of the solution
+}
+
+// tileSymbols maps every TileType to the symbol used by Print and in plan files.
+var tileSymbols = map[TileType]byte{
+	Empty:        '.',
+	Road:         'R',
+	River:        '~',
+	Forest:       'F',
+	Forbidden:    'X',
+	Rock:         'o',
+	Mountain:     'M',
+	Meadow:       'w',
+	Thicket:      'T',
+	MountainPeak: '^',
+}
+
+// tileNames are the names used for tile types in plan files (e.g. as landscape budget keys).
+var tileNames = map[TileType]string{
+	Empty:        "empty",
+	Road:         "road",
+	River:        "river",
+	Forest:       "forest",
+	Forbidden:    "forbidden",
+	Rock:         "rock",
+	Mountain:     "mountain",
+	Meadow:       "meadow",
+	Thicket:      "thicket",
+	MountainPeak: "mountain_peak",
+}
+
+// TileSymbol returns the one-character symbol of t, or '?' for an unknown type.
+func TileSymbol(t TileType) byte {
+	if symbol, ok := tileSymbols[t]; ok {
+		return symbol
+	}
+	return '?'
+}
+
+// ParseTileSymbol returns the tile type written as symbol by TileSymbol.
+func ParseTileSymbol(symbol byte) (TileType, bool) {
+	for t, s := range tileSymbols {
+		if s == symbol {
+			return t, true
+		}
+	}
+	return Empty, false
+}
+
+// MarshalText encodes the tile type by name, e.g. "thicket".
+func (t TileType) MarshalText() ([]byte, error) {
+	name, ok := tileNames[t]
+	if !ok {
+		return nil, fmt.Errorf("unknown tile type %d", int(t))
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText decodes a tile type name written by MarshalText.
+func (t *TileType) UnmarshalText(text []byte) error {
+	for tileType, name := range tileNames {
+		if name == string(text) {
+			*t = tileType
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown tile type %q", text)
+}
+
+// Rows returns the grid as GridHeight strings of tile symbols, top row first.
+func (g *Grid) Rows() []string {
+	rows := make([]string, GridHeight)
+	for y := 0; y < GridHeight; y++ {
+		var row strings.Builder
+		for x := 0; x < GridWidth; x++ {
+			row.WriteByte(TileSymbol(g[y][x]))
+		}
+		rows[y] = row.String()
+	}
+	return rows
+}
+
+// ParseRows builds a grid from rows written by Rows.
+func ParseRows(rows []string) (Grid, error) {
+	var g Grid
+	if len(rows) != GridHeight {
+		return g, fmt.Errorf("expected %d grid rows, got %d", GridHeight, len(rows))
+	}
+	for y, row := range rows {
+		if len(row) != GridWidth {
+			return g, fmt.Errorf("grid row %d has %d tiles, expected %d", y, len(row), GridWidth)
+		}
+		for x := 0; x < GridWidth; x++ {
+			t, ok := ParseTileSymbol(row[x])
+			if !ok {
+				return g, fmt.Errorf("unknown tile symbol %q at (%d, %d)", row[x], x, y)
+			}
+			g[y][x] = t
+		}
+	}
+	return g, nil
+}
+
+// MarshalJSON encodes the grid as its Rows.
+func (g Grid) MarshalJSON() ([]byte, error) {
+	return json.Marshal(g.Rows())
+}
+
+// UnmarshalJSON decodes a grid written by MarshalJSON.
+func (g *Grid) UnmarshalJSON(data []byte) error {
+	var rows []string
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return err
+	}
+	parsed, err := ParseRows(rows)
+	if err != nil {
+		return err
+	}
+	*g = parsed
+	return nil
+}
+
+// RoadTiles returns the coordinates of every Road tile, row by row.
+func (g *Grid) RoadTiles() []Coordinate {
+	var roads []Coordinate
+	for y := 0; y < GridHeight; y++ {
+		for x := 0; x < GridWidth; x++ {
+			if g[y][x] == Road {
+				roads = append(roads, Coordinate{X: x, Y: y})
+			}
+		}
+	}
+	return roads
+}
+
+// RoadGrid returns a grid with the file's roads and their Forbidden tiles set.
+func (p *PlanFile) RoadGrid() Grid {
+	g := NewGrid()
+	g.SetRoad(p.Roads)
+	return g
+}
+
+// validate checks that everything in the file fits on the grid.
+func (p *PlanFile) validate() error {
+	if p.Version < 1 {
+		return fmt.Errorf("missing or invalid plan file version %d", p.Version)
+	}
+	if p.Version > PlanFileVersion {
+		return fmt.Errorf("plan file version %d is newer than the supported version %d", p.Version, PlanFileVersion)
+	}
+	var g Grid
+	for _, road := range p.Roads {
+		if !g.isValidCoordinate(road) {
+			return fmt.Errorf("road tile (%d, %d) is outside the grid", road.X, road.Y)
+		}
+	}
+	if p.Start != nil && !g.isValidCoordinate(*p.Start) {
+		return fmt.Errorf("river start (%d, %d) is outside the grid", p.Start.X, p.Start.Y)
+	}
+	if p.Rules.End.Target != nil && !g.isValidCoordinate(*p.Rules.End.Target) {
+		return fmt.Errorf("end target (%d, %d) is outside the grid", p.Rules.End.Target.X, p.Rules.End.Target.Y)
+	}
+	if p.MaxLength < 0 {
+		return fmt.Errorf("max length must not be negative, got %d", p.MaxLength)
+	}
+	if p.Solution != nil {
+		for _, c := range p.Solution.Path {
+			if !g.isValidCoordinate(c) {
+				return fmt.Errorf("solution path tile (%d, %d) is outside the grid", c.X, c.Y)
+			}
+			if p.Solution.Grid[c.Y][c.X] != River {
+				return fmt.Errorf("solution path tile (%d, %d) is not a river tile in the solution grid", c.X, c.Y)
+			}
+		}
+	}
+	return nil
+}
+
+// WritePlanFile writes p as indented JSON, stamped with the current PlanFileVersion.
+func WritePlanFile(w io.Writer, p PlanFile) error {
+	p.Version = PlanFileVersion
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode plan file: %w", err)
+	}
+	data = append(data, '\n')
+	_, err = w.Write(data)
+	return err
+}
+
+// ReadPlanFile reads and validates a plan file written by WritePlanFile.
+func ReadPlanFile(r io.Reader) (PlanFile, error) {
+	var p PlanFile
+	if err := json.NewDecoder(r).Decode(&p); err != nil {
+		return PlanFile{}, fmt.Errorf("failed to decode plan file: %w", err)
+	}
+	if err := p.validate(); err != nil {
+		return PlanFile{}, err
+	}
+	return p, nil
+}
+
+// SavePlanFile writes p to the file at path, replacing it if it exists.
+func SavePlanFile(path string, p PlanFile) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WritePlanFile(file, p); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// LoadPlanFile reads the plan file at path.
+func LoadPlanFile(path string) (PlanFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return PlanFile{}, err
+	}
+	defer file.Close()
+	return ReadPlanFile(file)
+}
@@ -0,0 +1,84 @@
+package game
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// samplePlan returns a plan with a road along row 6, a start and every kind of rule set.
+// With solved, it also holds the solution of the river down from the start.
+func samplePlan(t *testing.T, solved bool) PlanFile {
+	t.Helper()
+	target := Coordinate{X: 12, Y: 0}
+	roads := rowRoadGrid(6)
+	p := PlanFile{
+		Version:   PlanFileVersion,
+		Roads:     roads.RoadTiles(),
+		MaxLength: 20,
+		Start:     &Coordinate{X: 10, Y: 0},
+		Rules: PlanRules{
+			DisableCrossRiverAdjacency: true,
+			SearchOptions: SearchOptions{
+				End:          EndConstraint{OnBorder: true, Target: &target, MinRoadDistance: 3},
+				Landscape:    LandscapeOptions{Weights: Stats{HP: 1, Regen: 0.005, AttackSpeed: 2}, Budgets: map[TileType]int{Forest: -1, Meadow: 4}},
+				Objective:    ObjectiveFewestCards,
+				TargetProfit: 0.5,
+			},
+		},
+	}
+	if solved {
+		sol, err := roads.EvaluatePath([]Coordinate{{X: 10, Y: 0}, {X: 10, Y: 1}, {X: 11, Y: 1}, {X: 12, Y: 1}, {X: 12, Y: 0}}, false, SearchOptions{Landscape: p.Rules.Landscape})
+		if err != nil {
+			t.Fatalf("EvaluatePath: %v", err)
+		}
+		p.Solution = &PlanSolution{Path: sol.Path, Profit: sol.Profit, Stats: sol.Stats, Grid: sol.Grid}
+	}
+	return p
+}
+
+func TestPlanFileRoundTrip(t *testing.T) {
+	for _, solved := range []bool{false, true} {
+		p := samplePlan(t, solved)
+		var sb strings.Builder
+		if err := WritePlanFile(&sb, p); err != nil {
+			t.Fatalf("WritePlanFile: %v", err)
+		}
+		got, err := ReadPlanFile(strings.NewReader(sb.String()))
+		if err != nil {
+			t.Fatalf("ReadPlanFile: %v\n%s", err, sb.String())
+		}
+		if !reflect.DeepEqual(got, p) {
+			t.Errorf("solved %t: read back\n%+v\nwant\n%+v", solved, got, p)
+		}
+	}
+}
+
+func TestReadPlanFileRejectsBadInput(t *testing.T) {
+	var sb strings.Builder
+	if err := WritePlanFile(&sb, samplePlan(t, true)); err != nil {
+		t.Fatalf("WritePlanFile: %v", err)
+	}
+	valid := sb.String()
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"missing version", strings.Replace(valid, `"version": 1`, `"version": 0`, 1)},
+		{"newer version", strings.Replace(valid, `"version": 1`, `"version": 99`, 1)},
+		{"truncated", valid[:len(valid)/2]},
+		{"road outside the grid", strings.Replace(valid, `"roads": [`, `"roads": [{"x": 21, "y": 0}, `, 1)},
+		{"unknown objective", strings.Replace(valid, `"fewest_cards"`, `"most_cards"`, 1)},
+		{"bad grid symbol", strings.Replace(valid, `"RRRRRRRRRRRRRRRRRRRRR"`, `"RRRRRRRRRRRRRRRRRRRR?"`, 1)},
+		{"short grid row", strings.Replace(valid, `"RRRRRRRRRRRRRRRRRRRRR"`, `"RRRRRRRRRRRRRRRRRRRR"`, 1)},
+		{"path off the river", strings.Replace(valid, `"path": [`, `"path": [{"x": 0, "y": 0}, `, 1)},
+	}
+	for _, tt := range tests {
+		if tt.data == valid {
+			t.Fatalf("%s: the test edit did not change the file", tt.name)
+		}
+		if _, err := ReadPlanFile(strings.NewReader(tt.data)); err == nil {
+			t.Errorf("%s: ReadPlanFile accepted the file", tt.name)
+		}
+	}
+}
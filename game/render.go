@@ -0,0 +1,225 @@
+package game
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"strconv"
+)
+
+// Colours shared by the window and the image renderers.
+var (
+	BackgroundColor     = color.RGBA{R: 50, G: 50, B: 50, A: 255}    // Gaps between tiles
+	RiverPathColor      = color.RGBA{R: 120, G: 200, B: 255, A: 255} // River polyline
+	StartMarkerColor    = color.RGBA{R: 255, G: 40, B: 40, A: 255}   // Ring around the river start
+	tileLabelColor      = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	legendTextColor     = color.RGBA{R: 230, G: 230, B: 230, A: 255}
+	legendSwatchOutline = color.RGBA{R: 20, G: 20, B: 20, A: 255}
+)
+
+// tileColors are the display colours of every tile type.
+var tileColors = map[TileType]color.RGBA{
+	Empty:        {R: 100, G: 100, B: 100, A: 255}, // Gray
+	Road:         {R: 200, G: 200, B: 0, A: 255},   // Yellowish
+	River:        {R: 0, G: 0, B: 200, A: 255},     // Blue
+	Forest:       {R: 0, G: 150, B: 0, A: 255},     // Green
+	Forbidden:    {R: 150, G: 0, B: 0, A: 255},     // Dark Red
+	Rock:         {R: 140, G: 130, B: 120, A: 255}, // Stone Gray
+	Mountain:     {R: 110, G: 80, B: 50, A: 255},   // Brown
+	Meadow:       {R: 170, G: 210, B: 90, A: 255},  // Light Green
+	Thicket:      {R: 0, G: 90, B: 40, A: 255},     // Dark Green
+	MountainPeak: {R: 225, G: 225, B: 235, A: 255}, // Snowy White
+}
+
+// bloomingMeadowColor is used instead of the Meadow colour for Blooming Meadows.
+var bloomingMeadowColor = color.RGBA{R: 230, G: 170, B: 210, A: 255} // Pink
+
+// TileColor returns the display colour of the tile at c. Blooming meadows are pink.
+func (g *Grid) TileColor(c Coordinate) color.RGBA {
+	t := g[c.Y][c.X]
+	if t == Meadow && g.IsBloomingMeadow(c) {
+		return bloomingMeadowColor
+	}
+	if tileColor, ok := tileColors[t]; ok {
+		return tileColor
+	}
+	return color.RGBA{R: 30, G: 30, B: 30, A: 255} // Dark Gray for unknown
+}
+
+// RenderOptions configures RenderSolution.
+type RenderOptions struct {
+	TileSize  int              // Pixels per tile; 0 means 32
+	Landscape LandscapeOptions // Used for the per-tile profit labels (the solution's own profit is shown in the legend)
+	Title     string           // Optional first legend line
+}
+
+// tileSize returns the effective tile size.
+func (o *RenderOptions) tileSize() int {
+	if o.TileSize <= 0 {
+		return 32
+	}
+	return o.TileSize
+}
+
+// RenderSolution draws sol into a new image: the tiles, the river as a polyline, a ring on
+// the river start, the profit of every landscape tile (in %), and a legend with the total profit.
+// It only uses the standard library, so it works without a display.
+func RenderSolution(sol RiverPathSolution, opts RenderOptions) *image.RGBA {
+	tileSize := opts.tileSize()
+	const legendScale = 2
+	lineHeight := (glyphHeight + 3) * legendScale
+	gridWidth, gridHeight := GridWidth*tileSize, GridHeight*tileSize
+
+	// Legend lines, then one swatch row per used tile type
+	lines := legendLines(sol, opts.Title)
+	var usedTypes []TileType
+	for _, t := range []TileType{Road, Forbidden, River, Forest, Thicket, Rock, Mountain, MountainPeak, Meadow} {
+		found := false
+		for y := 0; y < GridHeight && !found; y++ {
+			for x := 0; x < GridWidth && !found; x++ {
+				found = sol.Grid[y][x] == t
+			}
+		}
+		if found {
+			usedTypes = append(usedTypes, t)
+		}
+	}
+	swatchRows := 0
+	swatchCursor := gridWidth // Forces a new row for the first swatch
+	swatchWidth := func(t TileType) int { return lineHeight + 4 + textWidth(tileNames[t], legendScale) + 3*lineHeight/2 }
+	for _, t := range usedTypes {
+		if swatchCursor+swatchWidth(t) > gridWidth {
+			swatchRows++
+			swatchCursor = lineHeight / 2
+		}
+		swatchCursor += swatchWidth(t)
+	}
+	legendHeight := (len(lines)+swatchRows)*lineHeight + lineHeight
+
+	img := image.NewRGBA(image.Rect(0, 0, gridWidth, gridHeight+legendHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(BackgroundColor), image.Point{}, draw.Src)
+
+	// Tiles with their profit labels
+	for y := 0; y < GridHeight; y++ {
+		for x := 0; x < GridWidth; x++ {
+			c := Coordinate{X: x, Y: y}
+			tileRect := image.Rect(x*tileSize, y*tileSize, (x+1)*tileSize-1, (y+1)*tileSize-1)
+			draw.Draw(img, tileRect, image.NewUniform(sol.Grid.TileColor(c)), image.Point{}, draw.Src)
+			if !IsLandscape(sol.Grid[y][x]) {
+				continue
+			}
+			label := strconv.FormatFloat(opts.Landscape.Profit(sol.Grid.TileStats(c))*100, 'f', -1, 64)
+			if len(label) > 4 {
+				label = fmt.Sprintf("%.1f", opts.Landscape.Profit(sol.Grid.TileStats(c))*100)
+			}
+			labelScale := 1
+			if tileSize >= 48 {
+				labelScale = 2
+			}
+			labelX := x*tileSize + (tileSize-1-textWidth(label, labelScale))/2
+			labelY := y*tileSize + (tileSize-1-glyphHeight*labelScale)/2
+			drawText(img, labelX+1, labelY+1, label, labelScale, color.Black) // Shadow keeps labels readable on light tiles
+			drawText(img, labelX, labelY, label, labelScale, tileLabelColor)
+		}
+	}
+
+	// River polyline and start marker
+	center := func(c Coordinate) image.Point {
+		return image.Point{X: c.X*tileSize + tileSize/2, Y: c.Y*tileSize + tileSize/2}
+	}
+	lineWidth := tileSize / 8
+	if lineWidth < 2 {
+		lineWidth = 2
+	}
+	for i := 0; i+1 < len(sol.Path); i++ {
+		drawThickLine(img, center(sol.Path[i]), center(sol.Path[i+1]), lineWidth, RiverPathColor)
+	}
+	if len(sol.Path) > 0 {
+		drawRing(img, center(sol.Path[0]), tileSize/3, lineWidth, StartMarkerColor)
+	}
+
+	// Legend
+	textY := gridHeight + lineHeight/2
+	for _, line := range lines {
+		drawText(img, lineHeight/2, textY, line, legendScale, legendTextColor)
+		textY += lineHeight
+	}
+	swatchCursor = gridWidth
+	textY -= lineHeight
+	for _, t := range usedTypes {
+		if swatchCursor+swatchWidth(t) > gridWidth {
+			textY += lineHeight
+			swatchCursor = lineHeight / 2
+		}
+		swatch := image.Rect(swatchCursor, textY, swatchCursor+glyphHeight*legendScale, textY+glyphHeight*legendScale)
+		draw.Draw(img, swatch.Inset(-1), image.NewUniform(legendSwatchOutline), image.Point{}, draw.Src)
+		draw.Draw(img, swatch, image.NewUniform(tileColors[t]), image.Point{}, draw.Src)
+		drawText(img, swatchCursor+lineHeight+4, textY, tileNames[t], legendScale, legendTextColor)
+		swatchCursor += swatchWidth(t)
+	}
+	return img
+}
+
+// legendLines returns the text lines of the legend: the title (if any), then profit and stats.
+func legendLines(sol RiverPathSolution, title string) []string {
+	var lines []string
+	if title != "" {
+		lines = append(lines, title)
+	}
+	if sol.Profit >= 0 && len(sol.Path) > 0 {
+		lines = append(lines, fmt.Sprintf("Profit: %.2f%%  River: %d tiles", sol.Profit*100, len(sol.Path)))
+		lines = append(lines, sol.Stats.String())
+	} else {
+		lines = append(lines, "No solution")
+	}
+	return lines
+}
+
+// drawThickLine draws a straight line from a to b as squares of the given width along it.
+func drawThickLine(img draw.Image, a, b image.Point, width int, c color.Color) {
+	src := image.NewUniform(c)
+	steps := abs(b.X-a.X) + abs(b.Y-a.Y)
+	if steps == 0 {
+		steps = 1
+	}
+	for i := 0; i <= steps; i++ {
+		x := a.X + (b.X-a.X)*i/steps
+		y := a.Y + (b.Y-a.Y)*i/steps
+		draw.Draw(img, image.Rect(x-width/2, y-width/2, x-width/2+width, y-width/2+width), src, image.Point{}, draw.Over)
+	}
+}
+
+// drawRing draws a circle outline of the given radius and width around center.
+func drawRing(img draw.Image, center image.Point, radius, width int, c color.Color) {
+	inner := (radius - width) * (radius - width)
+	outer := radius * radius
+	for dy := -radius; dy <= radius; dy++ {
+		for dx := -radius; dx <= radius; dx++ {
+			if d := dx*dx + dy*dy; d <= outer && d > inner {
+				img.Set(center.X+dx, center.Y+dy, c)
+			}
+		}
+	}
+}
+
+// WriteSolutionPNG renders sol and writes it as PNG.
+func WriteSolutionPNG(w io.Writer, sol RiverPathSolution, opts RenderOptions) error {
+	return png.Encode(w, RenderSolution(sol, opts))
+}
+
+// SaveSolutionPNG renders sol to a PNG file at path, replacing it if it exists.
+func SaveSolutionPNG(path string, sol RiverPathSolution, opts RenderOptions) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WriteSolutionPNG(file, sol, opts); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,78 @@
+package game
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// renderSample returns a short river down from (10,0) with forests around it and a rock away
+// from it at (3,10).
+func renderSample(t *testing.T) RiverPathSolution {
+	t.Helper()
+	g := rowRoadGrid(6)
+	sol, err := g.EvaluatePath([]Coordinate{{X: 10, Y: 0}, {X: 10, Y: 1}, {X: 11, Y: 1}, {X: 12, Y: 1}, {X: 12, Y: 0}}, false, SearchOptions{})
+	if err != nil {
+		t.Fatalf("EvaluatePath: %v", err)
+	}
+	sol.Grid[10][3] = Rock
+	return sol
+}
+
+// pixelAt returns the colour of img at (x, y).
+func pixelAt(img image.Image, x, y int) color.RGBA {
+	return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+}
+
+// tilePixel returns the colour of img inside the top-left corner of the tile at (x, y), away
+// from the profit label and the river line.
+func tilePixel(img image.Image, x, y, tileSize int) color.RGBA {
+	return pixelAt(img, x*tileSize+2, y*tileSize+2)
+}
+
+func TestWriteSolutionPNG(t *testing.T) {
+	sol := renderSample(t)
+	for _, tileSize := range []int{0, 48} {
+		var buf bytes.Buffer
+		if err := WriteSolutionPNG(&buf, sol, RenderOptions{TileSize: tileSize, Title: "Sample"}); err != nil {
+			t.Fatalf("WriteSolutionPNG: %v", err)
+		}
+		img, err := png.Decode(&buf)
+		if err != nil {
+			t.Fatalf("decoding the PNG: %v", err)
+		}
+		if tileSize == 0 {
+			tileSize = 32
+		}
+		if b := img.Bounds(); b.Dx() != GridWidth*tileSize || b.Dy() <= GridHeight*tileSize {
+			t.Fatalf("tile size %d: image is %dx%d, want %d wide with a legend below %d", tileSize, b.Dx(), b.Dy(), GridWidth*tileSize, GridHeight*tileSize)
+		}
+		for _, tc := range []struct {
+			x, y int
+			want color.RGBA
+		}{
+			{0, 0, tileColors[Empty]},
+			{0, 5, tileColors[Forbidden]},
+			{0, 6, tileColors[Road]},
+			{11, 1, tileColors[River]},
+			{9, 0, tileColors[Forest]},
+			{3, 10, tileColors[Rock]},
+		} {
+			if got := tilePixel(img, tc.x, tc.y, tileSize); got != tc.want {
+				t.Errorf("tile size %d: tile (%d,%d) is %v, want %v", tileSize, tc.x, tc.y, got, tc.want)
+			}
+		}
+		center := func(x, y int) (int, int) { return x*tileSize + tileSize/2, y*tileSize + tileSize/2 }
+		if x, y := center(11, 1); pixelAt(img, x, y) != RiverPathColor {
+			t.Errorf("tile size %d: river line centre is %v, want %v", tileSize, pixelAt(img, x, y), RiverPathColor)
+		}
+		if x, y := center(10, 0); pixelAt(img, x+tileSize/3-1, y) != StartMarkerColor {
+			t.Errorf("tile size %d: no start ring right of (10,0)", tileSize)
+		}
+		if got := pixelAt(img, tileSize-1, tileSize-1); got != BackgroundColor {
+			t.Errorf("tile size %d: gap between tiles is %v, want %v", tileSize, got, BackgroundColor)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package game
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// MinRiverLength is the shortest river length searched by Solve.
+const MinRiverLength = 5
+
+// SolveOptions configures Solve.
+type SolveOptions struct {
+	Starts                     []Coordinate // River starts to search; empty means every valid start (GetValidRiverStarts)
+	MaxLength                  int          // Lengths MinRiverLength..MaxLength are searched from every start
+	DisableCrossRiverAdjacency bool
+	Search                     SearchOptions
+	Workers                    int // Starts searched in parallel; 0 means runtime.NumCPU()
+	// Slots, if set, is a CPU budget shared between several Solve calls: every (start, length)
+	// search holds one slot (a value sent into the channel) while it runs. Its capacity is the budget.
+	Slots chan struct{}
+}
+
+// SolveProgress is reported by Solve after every finished (start, length) search.
+type SolveProgress struct {
+	Start    Coordinate        // Start of the search that just finished
+	Length   int               // Max length of the search that just finished
+	Done     int               // (start, length) searches finished so far; searches cut short by the stop channel do not count
+	Total    int               // (start, length) searches in total (Done == Total means the search space was covered)
+	Best     RiverPathSolution // Best solution over all starts so far (Profit < 0 if none yet)
+	Improved bool              // Whether Best changed with this search
+}
+
+// Solve runs the iterative length search of the window without any UI: for every start it
+// searches each length from MinRiverLength to opts.MaxLength and keeps the best solution under
+// opts.Search (see BetterThan). roads is the road layout (SetRoad already applied).
+// progress may be nil; calls to it are serialized. When stopChannel is closed, Solve returns
+// the best solution found so far.
+func Solve(roads Grid, opts SolveOptions, progress func(SolveProgress), stopChannel <-chan struct{}) (RiverPathSolution, error) {
+	best := RiverPathSolution{Grid: roads, Profit: -1.0}
+	if opts.MaxLength < MinRiverLength {
+		return best, fmt.Errorf("max length %d is below the minimum river length of %d", opts.MaxLength, MinRiverLength)
+	}
+	if err := opts.Search.End.validate(&roads); err != nil {
+		return best, err
+	}
+	starts := opts.Starts
+	if len(starts) == 0 {
+		starts = roads.GetValidRiverStarts()
+	}
+	if len(starts) == 0 {
+		return best, fmt.Errorf("the road layout has no valid river starts")
+	}
+	for _, start := range starts {
+		if !roads.isValidCoordinate(start) {
+			return best, fmt.Errorf("river start (%d, %d) is outside the grid", start.X, start.Y)
+		}
+		if !roads.isValidRiverStart(start) {
+			return best, fmt.Errorf("the river cannot start at (%d, %d): it must start on an Empty border tile that is not a corner", start.X, start.Y)
+		}
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	var mu sync.Mutex // Guards best and the progress counters
+	done := 0
+	total := len(starts) * (opts.MaxLength - MinRiverLength + 1)
+	startQueue := make(chan Coordinate, len(starts))
+	for _, start := range starts {
+		startQueue <- start
+	}
+	close(startQueue)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(starts); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range startQueue {
+				for length := MinRiverLength; length <= opts.MaxLength; length++ {
+					select {
+					case <-stopChannel:
+						return
+					default:
+					}
+					if opts.Slots != nil {
+						select {
+						case opts.Slots <- struct{}{}:
+						case <-stopChannel:
+							return
+						}
+					}
+					grid := roads // Grid is an array, so this is a fresh copy for every search
+					sol, err := grid.FindOptimalRiverAndForests(start, length, nil, stopChannel, opts.DisableCrossRiverAdjacency, opts.Search)
+					if opts.Slots != nil {
+						<-opts.Slots
+					}
+					interrupted := err != nil && err.Error() == "search stopped by user"
+					if err != nil && sol.Profit < 0 && !interrupted {
+						opts.Search.logf("[Solve] Start %v, length %d: %v\n", start, length, err)
+					}
+
+					mu.Lock()
+					if !interrupted {
+						done++
+					}
+					improved := sol.BetterThan(best)
+					if improved {
+						best = sol
+					}
+					if progress != nil {
+						progress(SolveProgress{Start: start, Length: length, Done: done, Total: total, Best: best, Improved: improved})
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if best.Profit < 0 {
+		select {
+		case <-stopChannel:
+			return best, fmt.Errorf("search stopped before a solution was found")
+		default:
+		}
+		return best, fmt.Errorf("no river path satisfies the rules (max length %d, end: %s)", opts.MaxLength, opts.Search.End)
+	}
+	return best, nil
+}
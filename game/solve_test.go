@@ -0,0 +1,37 @@
+package game
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSolveWritesDebugOutputToSearchLog(t *testing.T) {
+	var searchLog bytes.Buffer
+	opts := SolveOptions{
+		Starts:    []Coordinate{{X: 10, Y: 0}},
+		MaxLength: 6,
+		Search:    SearchOptions{Log: &searchLog},
+		Workers:   2,
+	}
+	best, err := Solve(rowRoadGrid(6), opts, nil, nil)
+	if err != nil {
+		t.Fatalf("Solve: %v", err)
+	}
+	if best.Profit <= 0 || len(best.Path) == 0 || len(best.Path) > 6 {
+		t.Errorf("unexpected best solution: profit %v, path %v", best.Profit, best.Path)
+	}
+	if !strings.Contains(searchLog.String(), "Search complete.") {
+		t.Errorf("search log %q does not report the finished searches", searchLog.String())
+	}
+}
+
+func TestSolveRejectsInvalidStarts(t *testing.T) {
+	for _, start := range []Coordinate{{X: 10, Y: 3}, {X: 0, Y: 0}, {X: 0, Y: 6}, {X: 30, Y: 0}} {
+		opts := SolveOptions{Starts: []Coordinate{start}, MaxLength: 6, Search: SearchOptions{Log: io.Discard}}
+		if _, err := Solve(rowRoadGrid(6), opts, nil, nil); err == nil {
+			t.Errorf("Solve accepted the river start %v", start)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package game
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"strings"
+)
+
+// svgLayerOrder is the order in which tile layers are written (later layers are drawn on top).
+var svgLayerOrder = []TileType{Empty, Forbidden, Road, River, Forest, Thicket, Rock, Mountain, MountainPeak, Meadow}
+
+// svgColor formats c as an SVG colour, e.g. "#00c800".
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// svgEscape escapes s for use in SVG text and attribute values.
+func svgEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// WriteSolutionSVG writes sol as an SVG document: one group ("layer") per tile type, the river as a
+// path, a start marker and a legend. Every landscape tile has a hover title with its adjacent
+// river count and profit. Only TileSize, Landscape and Title of opts are used.
+func WriteSolutionSVG(w io.Writer, sol RiverPathSolution, opts RenderOptions) error {
+	tileSize := opts.tileSize()
+	lineHeight := tileSize * 3 / 4
+	gridWidth, gridHeight := GridWidth*tileSize, GridHeight*tileSize
+
+	legend := legendLines(sol, opts.Title)
+	height := gridHeight + (len(legend)+1)*lineHeight
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n", gridWidth, height, gridWidth, height)
+	fmt.Fprintf(bw, "  <rect id=\"background\" width=\"%d\" height=\"%d\" fill=\"%s\"/>\n", gridWidth, height, svgColor(BackgroundColor))
+
+	// One layer per tile type, skipping empty layers
+	for _, t := range svgLayerOrder {
+		var tiles strings.Builder
+		for y := 0; y < GridHeight; y++ {
+			for x := 0; x < GridWidth; x++ {
+				if sol.Grid[y][x] != t {
+					continue
+				}
+				c := Coordinate{X: x, Y: y}
+				fmt.Fprintf(&tiles, "    <rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"%s\"", x*tileSize, y*tileSize, tileSize-1, tileSize-1, svgColor(sol.Grid.TileColor(c)))
+				if !IsLandscape(t) {
+					tiles.WriteString("/>\n")
+					continue
+				}
+				name := tileNames[t]
+				if t == Meadow && sol.Grid.IsBloomingMeadow(c) {
+					name = "blooming meadow"
+				}
+				stats := sol.Grid.TileStats(c)
+				title := fmt.Sprintf("%s at (%d, %d): %d adjacent river tile(s), profit %.2f%% (%s)", name, x, y, sol.Grid.AdjacentRiverCount(c), opts.Landscape.Profit(stats)*100, stats)
+				fmt.Fprintf(&tiles, "><title>%s</title></rect>\n", svgEscape(title))
+			}
+		}
+		if tiles.Len() == 0 {
+			continue
+		}
+		fmt.Fprintf(bw, "  <g id=\"layer-%s\">\n%s  </g>\n", strings.ReplaceAll(tileNames[t], "_", "-"), tiles.String())
+	}
+
+	// River path and start marker
+	if len(sol.Path) > 0 {
+		var d strings.Builder
+		for i, c := range sol.Path {
+			command := "L"
+			if i == 0 {
+				command = "M"
+			}
+			fmt.Fprintf(&d, "%s%d %d ", command, c.X*tileSize+tileSize/2, c.Y*tileSize+tileSize/2)
+		}
+		strokeWidth := tileSize / 8
+		if strokeWidth < 2 {
+			strokeWidth = 2
+		}
+		fmt.Fprintf(bw, "  <path id=\"river\" d=\"%s\" fill=\"none\" stroke=\"%s\" stroke-width=\"%d\" stroke-linejoin=\"round\" stroke-linecap=\"round\" pointer-events=\"none\"/>\n", strings.TrimSpace(d.String()), svgColor(RiverPathColor), strokeWidth)
+		start := sol.Path[0]
+		fmt.Fprintf(bw, "  <circle id=\"start\" cx=\"%d\" cy=\"%d\" r=\"%d\" fill=\"none\" stroke=\"%s\" stroke-width=\"%d\"><title>River start (%d, %d)</title></circle>\n",
+			start.X*tileSize+tileSize/2, start.Y*tileSize+tileSize/2, tileSize/3, svgColor(StartMarkerColor), strokeWidth, start.X, start.Y)
+	}
+
+	// Legend
+	fmt.Fprintf(bw, "  <g id=\"legend\" font-family=\"monospace\" font-size=\"%d\" fill=\"%s\">\n", lineHeight*2/3, svgColor(legendTextColor))
+	for i, line := range legend {
+		fmt.Fprintf(bw, "    <text x=\"%d\" y=\"%d\">%s</text>\n", lineHeight/2, gridHeight+(i+1)*lineHeight, svgEscape(line))
+	}
+	fmt.Fprintf(bw, "  </g>\n")
+	fmt.Fprintf(bw, "</svg>\n")
+	return bw.Flush()
+}
+
+// SaveSolutionSVG writes sol as SVG to the file at path, replacing it if it exists.
+func SaveSolutionSVG(path string, sol RiverPathSolution, opts RenderOptions) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WriteSolutionSVG(file, sol, opts); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
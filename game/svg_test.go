@@ -0,0 +1,75 @@
+package game
+
+import (
+	"encoding/xml"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// svgSummary parses an SVG document and returns how often each element occurs, the ids of the
+// groups in order and the size of the root element.
+func svgSummary(t *testing.T, doc string) (counts map[string]int, groups []string, width, height int) {
+	t.Helper()
+	counts = map[string]int{}
+	decoder := xml.NewDecoder(strings.NewReader(doc))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("SVG is not well-formed XML: %v\n%s", err, doc)
+		}
+		element, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		counts[element.Name.Local]++
+		for _, attr := range element.Attr {
+			switch {
+			case element.Name.Local == "g" && attr.Name.Local == "id":
+				groups = append(groups, attr.Value)
+			case element.Name.Local == "svg" && attr.Name.Local == "width":
+				width, _ = strconv.Atoi(attr.Value)
+			case element.Name.Local == "svg" && attr.Name.Local == "height":
+				height, _ = strconv.Atoi(attr.Value)
+			}
+		}
+	}
+	return counts, groups, width, height
+}
+
+func TestWriteSolutionSVG(t *testing.T) {
+	sol := renderSample(t)
+	noRiver := sol
+	noRiver.Path = nil
+	for _, tc := range []struct {
+		name   string
+		sol    RiverPathSolution
+		counts map[string]int
+	}{
+		// A background rect and one per tile; a title on the 8 forests, the rock and the start;
+		// legend lines for the title, the profit and the stats
+		{"river", sol, map[string]int{"svg": 1, "rect": 1 + GridWidth*GridHeight, "g": 7, "title": 10, "path": 1, "circle": 1, "text": 3}},
+		{"no river", noRiver, map[string]int{"svg": 1, "rect": 1 + GridWidth*GridHeight, "g": 7, "title": 9, "text": 2}},
+	} {
+		var sb strings.Builder
+		if err := WriteSolutionSVG(&sb, tc.sol, RenderOptions{Title: "Sample <1>"}); err != nil {
+			t.Fatalf("%s: WriteSolutionSVG: %v", tc.name, err)
+		}
+		counts, groups, width, height := svgSummary(t, sb.String())
+		if !reflect.DeepEqual(counts, tc.counts) {
+			t.Errorf("%s: elements %v, want %v", tc.name, counts, tc.counts)
+		}
+		wantGroups := []string{"layer-empty", "layer-forbidden", "layer-road", "layer-river", "layer-forest", "layer-rock", "legend"}
+		if !reflect.DeepEqual(groups, wantGroups) {
+			t.Errorf("%s: groups %v, want %v", tc.name, groups, wantGroups)
+		}
+		if width != GridWidth*32 || height <= GridHeight*32 {
+			t.Errorf("%s: size %dx%d, want %d wide with a legend below %d", tc.name, width, height, GridWidth*32, GridHeight*32)
+		}
+	}
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
-	"bytes" // Needed for bytes.NewReader with the new clipboard library
 	"fmt"
 	"image"
 	"image/color" // Needed for decoding PNG from clipboard
 	"log"
 	"os"
+	"path/filepath"
+	"reflect"
+	"riverplan/capture"
+	"riverplan/detect"
 	"riverplan/game"
 	"runtime" // Added import
+	"strings"
 	"sync"
 	"time"
 
@@ -24,44 +28,15 @@ import (
 const (
 	gameAreaWidth             = game.GridWidth * tileSize
 	screenWidth               = gameAreaWidth + panelWidth // Total window width
-	screenHeight              = game.GridHeight * tileSize
-	tileSize                  = 32 // Size of each tile in pixels
-	minRiverLength            = 5
+	screenHeight              = max(game.GridHeight*tileSize, minPanelHeight)
+	minPanelHeight            = 560 // The side panel needs room for the status text and up to 8 buttons
+	tileSize                  = 40  // Size of each tile in pixels
+	minRiverLength            = game.MinRiverLength
 	maxRiverLengthCap         = 35 // Absolute cap for slider adjustment (CHANGED FROM 100 to 35)
 	defaultInitialRiverLength = 35
-	// brightnessDifferenceThreshold is the amount by which a tile's brightness must exceed the
-	// reference tile's brightness to be considered a road.
-	brightnessDifferenceThreshold = 15.0
-
-	// Define the target road color (Loop Hero roads are brownish-yellow)
-	// This might need adjustment. Let's start with a sample color.
-	// Values are R, G, B (0-255).
-	// roadColorR = 110 // Adjusted based on user screenshot - NO LONGER USED
-	// roadColorG = 110 // Adjusted based on user screenshot - NO LONGER USED
-	// roadColorB = 110 // Adjusted based on user screenshot - NO LONGER USED
-	// Threshold for color matching (sum of absolute differences in R, G, B values)
-	// colorThreshold = 70 // Adjusted: Made stricter to avoid non-road tiles like campfire - NO LONGER USED
-
-	// Anchor colors for grid detection
-	anchorDarkGrayR      = 58  // Reverted to user-specified #3A3F3F for top-left
-	anchorDarkGrayG      = 63  // Reverted to user-specified #3A3F3F for top-left
-	anchorDarkGrayB      = 63  // Reverted to user-specified #3A3F3F for top-left
-	anchorGreenPatternR  = 142 // User-specified #8E8F7B for top-right
-	anchorGreenPatternG  = 143 // User-specified #8E8F7B for top-right
-	anchorGreenPatternB  = 123 // User-specified #8E8F7B for top-right
-	anchorColorThreshold = 30  // Threshold for matching anchor colors (can be tuned)
-
-	// Percentage-based grid coordinates (derived from 2560x1440 example)
-	// Top-left X of grid = 0px -> 0%
-	// Top-left Y of grid = 100px -> 100/1440 = 6.944%
-	// Top-right X of grid = 2099px -> 2099/2560 = 81.99%
-	// Grid bottom Y: TopY_px + ( (TopRightX_px - TopLeftX_px)/21 tiles * 12 tiles ) = 100 + ( (2099-0)/21 * 12 ) = 100 + 1199.428 = 1299.428px
-	//                 1299.428px / 1440px height = 90.2379...%
-	gridStartXPercent  = 0.0
-	gridStartYPercent  = 0.0694444444
-	gridEndXPercent    = 0.819921875
-	gridBottomYPercent = 0.9023791667
-
+	peakPlanRockCards         = 18 // Rock cards used by "Plan Mountain Peaks"
+	peakPlanMountainCards     = 9  // Mountain cards used by "Plan Mountain Peaks"
+	meadowPlanCards           = 8  // Meadow cards used by "Place Meadows"
 	// UI Button constants
 	// panelWidth, buttonHeight, buttonMargin, buttonPadding, textOffsetY moved to ui.go
 )
@@ -74,8 +49,77 @@ const (
 	StatePlacingRiverSource
 	StateCalculating
 	StateShowingResult
+	StateReviewingDetection // Checking a screenshot detection before it replaces the grid (see review.go)
+	StateCalibratingGrid    // Picking the grid corners on a screenshot by hand (see calibrate.go)
 )
 
+// PanelPage selects which group of buttons the side panel shows.
+type PanelPage int
+
+const (
+	PageMain  PanelPage = iota // State-specific action buttons
+	PageRules                  // Search rule toggles (cross adjacency, river end)
+	PageFiles                  // Saving and loading plan files
+)
+
+// endConstraintPreset is one entry of the "End:" button cycle on the rules page.
+type endConstraintPreset struct {
+	Label           string
+	OnBorder        bool
+	UseTarget       bool // Use the cell picked with RMB while selecting the river source
+	MinRoadDistance int
+}
+
+var endConstraintPresets = []endConstraintPreset{
+	{Label: "Anywhere"},
+	{Label: "Any Border", OnBorder: true},
+	{Label: "Target Cell (RMB)", UseTarget: true},
+	{Label: ">= 2 From Road", MinRoadDistance: 2},
+	{Label: ">= 3 From Road", MinRoadDistance: 3},
+	{Label: ">= 4 From Road", MinRoadDistance: 4},
+}
+
+// targetEndPresetIndex is the index of the "Target Cell" preset in endConstraintPresets.
+const targetEndPresetIndex = 2
+
+// objectivePreset is one entry of the "Goal:" button cycle on the rules page.
+type objectivePreset struct {
+	Label        string
+	Objective    game.Objective
+	TargetProfit float64 // Only used by game.ObjectiveFewestCards
+}
+
+var objectivePresets = []objectivePreset{
+	{Label: "Max Profit", Objective: game.ObjectiveProfit},
+	{Label: "Profit / River Tile", Objective: game.ObjectiveProfitPerRiver},
+	{Label: "Profit / Card", Objective: game.ObjectiveProfitPerCard},
+	{Label: "Fewest Cards >= 50%", Objective: game.ObjectiveFewestCards, TargetProfit: 0.5},
+	{Label: "Fewest Cards >= 100%", Objective: game.ObjectiveFewestCards, TargetProfit: 1.0},
+	{Label: "Meadow Healing", Objective: game.ObjectiveMeadowHealing},
+}
+
+// landscapePreset is one entry of the "Land:" button cycle on the rules page.
+type landscapePreset struct {
+	Label   string
+	Options game.LandscapeOptions
+}
+
+var landscapePresets = []landscapePreset{
+	{Label: "Forests Only"}, // Zero value: unlimited forests, attack speed only
+	{Label: "Speed (+8 Thicket)", Options: game.LandscapeOptions{
+		Weights: game.Stats{AttackSpeed: 1},
+		Budgets: map[game.TileType]int{game.Forest: -1, game.Thicket: 8},
+	}},
+	{Label: "HP (Rock+Mountain)", Options: game.LandscapeOptions{
+		Weights: game.Stats{HP: 1},
+		Budgets: map[game.TileType]int{game.Rock: -1, game.Mountain: 8},
+	}},
+	{Label: "Mixed", Options: game.LandscapeOptions{
+		Weights: game.Stats{AttackSpeed: 1, HP: 1, Regen: 0.005},
+		Budgets: map[game.TileType]int{game.Forest: -1, game.Thicket: 6, game.Rock: 6, game.Mountain: 4, game.Meadow: 6},
+	}},
+}
+
 // Button struct for UI elements - moved to ui.go
 
 // Game implements ebiten.Game interface.
@@ -89,22 +133,35 @@ type Game struct {
 	selectedRiverStart              game.Coordinate
 	validRiverStarts                []game.Coordinate // To highlight valid spots for user
 	calculationStartTime            time.Time
-	stopCalcChannel                 chan struct{} // Single channel to stop the calculation goroutine
-	currentMaxRiverLength           int           // User-adjustable, potentially for next calculation
-	lengthUsedForCurrentCalculation int           // New: Stores the max length the current calculation was started with
-	maxLenUsedForFinalSolution      int           // Max length used to get the g.finalBestSolution
-	DisableCrossRiverAdjacency      bool          // New: Toggle for cross-river adjacency rule
+	stopCalcChannel                 chan struct{}           // Single channel to stop the calculation goroutine
+	currentMaxRiverLength           int                     // User-adjustable, potentially for next calculation
+	lengthUsedForCurrentCalculation int                     // New: Stores the max length the current calculation was started with
+	maxLenUsedForFinalSolution      int                     // Max length used to get the g.finalBestSolution
+	DisableCrossRiverAdjacency      bool                    // New: Toggle for cross-river adjacency rule
+	endPresetIndex                  int                     // Index into endConstraintPresets
+	endTarget                       *game.Coordinate        // River end cell picked with RMB (used by the "Target Cell" preset)
+	landscapePresetIndex            int                     // Index into landscapePresets
+	objectivePresetIndex            int                     // Index into objectivePresets
+	optionsForCurrentCalculation    game.SearchOptions      // Search options the current/last calculation was started with
+	peaksNearRiver                  bool                    // Mountain peak planner only places tiles touching the river
+	isPlanningLandscape             bool                    // A second-pass planner (e.g. mountain peaks) is running
+	review                          *detectionReview        // Detection being reviewed (StateReviewingDetection and StateCalibratingGrid)
+	calibration                     *gridCalibration        // Calibration wizard (StateCalibratingGrid only)
+	gridProfiles                    detect.Profiles         // Grid calibrations by screenshot resolution, used by every import
+	detectDebugDumps                bool                    // Every detection run writes a debug dump (see review.go)
+	watchSettings                   screenshotWatchSettings // Screenshot folder and watch mode (see watch.go)
+	screenshotWatcher               *screenshotWatcher      // Running watcher, nil when not watching
 	mu                              sync.Mutex
 
 	// Fields for global iterative calculation state management
 	absoluteBestOverallSolution game.RiverPathSolution // Best solution found across all goroutines
-	activeCalculationGoroutines sync.WaitGroup         // To track active worker goroutines
 	calculationID               int                    // Incremental ID for each calculation run
 	currentCalculationID        int                    // ID of the currently active calculation sweep
-	numWorkersForCurrentCalc    int                    // Number of workers launched for the current calculation (1 for single, N for global)
+	numWorkersForCurrentCalc    int                    // Number of starts scanned by the current calculation (1 for single, N for global)
 
 	// UI elements - can be dynamic based on state
-	buttons []Button
+	buttons   []Button
+	panelPage PanelPage // Which button group the panel currently shows
 
 	// Rects for custom UI controls like river length adjuster
 	minusRiverLengthButtonRect image.Rectangle // Will be removed or repurposed
@@ -134,6 +191,7 @@ func NewGame() *Game {
 		calculationID:               0,
 		currentCalculationID:        0,
 		numWorkersForCurrentCalc:    0, // Initialize
+		gridProfiles:                loadGridProfiles(),
 	}
 	// Initialize solutions with the empty grid state
 	g.finalBestSolution.Grid = g.grid
@@ -143,9 +201,68 @@ func NewGame() *Game {
 	// g.absoluteBestOverallSolution.Grid = g.grid // Initialize with current grid // Corrected above
 	g.updateButtonsForState()   // Initialize buttons
 	g.updateCalculationStatus() // Initialize status
+	g.watchSettings = loadScreenshotWatchSettings()
+	if err := g.startScreenshotWatcher(); err != nil {
+		log.Printf("Error starting screenshot watcher: %v", err)
+		g.calculationStatus += fmt.Sprintf("\nWatch Err: %v", err)
+	} else if g.screenshotWatcher != nil {
+		g.calculationStatus += fmt.Sprintf("\nWatching %s for new screenshots.", g.watchSettings.Dir)
+	}
+	g.updateButtonsForState() // The files page shows the watch mode
 	return g
 }
 
+// currentSearchOptions builds the game.SearchOptions for the next calculation from the UI settings.
+func (g *Game) currentSearchOptions() game.SearchOptions {
+	return presetSearchOptions(g.endPresetIndex, g.endTarget, g.landscapePresetIndex, g.objectivePresetIndex)
+}
+
+// presetSearchOptions builds game.SearchOptions from rule preset indices. endTarget is only
+// used when the end preset is "Target Cell". Shared by the window and the terminal UI.
+func presetSearchOptions(endPresetIndex int, endTarget *game.Coordinate, landscapePresetIndex, objectivePresetIndex int) game.SearchOptions {
+	preset := endConstraintPresets[endPresetIndex]
+	opts := game.SearchOptions{
+		End:          game.EndConstraint{OnBorder: preset.OnBorder, MinRoadDistance: preset.MinRoadDistance},
+		Landscape:    landscapePresets[landscapePresetIndex].Options,
+		Objective:    objectivePresets[objectivePresetIndex].Objective,
+		TargetProfit: objectivePresets[objectivePresetIndex].TargetProfit,
+	}
+	if preset.UseTarget && endTarget != nil {
+		target := *endTarget
+		opts.End.Target = &target
+	}
+	return opts
+}
+
+// matchRulePresets maps loaded rules back onto the rule presets and returns their indices and
+// the end target. Settings no preset matches fall back to the first preset.
+func matchRulePresets(rules game.PlanRules) (endPresetIndex int, endTarget *game.Coordinate, landscapePresetIndex, objectivePresetIndex int) {
+	for i, preset := range endConstraintPresets {
+		end := rules.End
+		if preset.OnBorder == end.OnBorder && preset.MinRoadDistance == end.MinRoadDistance && preset.UseTarget == (end.Target != nil) {
+			endPresetIndex = i
+			if end.Target != nil {
+				target := *end.Target
+				endTarget = &target
+			}
+			break
+		}
+	}
+	for i, preset := range landscapePresets {
+		if reflect.DeepEqual(preset.Options, rules.Landscape) {
+			landscapePresetIndex = i
+			break
+		}
+	}
+	for i, preset := range objectivePresets {
+		if preset.Objective == rules.Objective && (preset.Objective != game.ObjectiveFewestCards || preset.TargetProfit == rules.TargetProfit) {
+			objectivePresetIndex = i
+			break
+		}
+	}
+	return endPresetIndex, endTarget, landscapePresetIndex, objectivePresetIndex
+}
+
 // Helper to update calculationStatus string based on current state and data
 func (g *Game) updateCalculationStatus() {
 	switch g.gameState {
@@ -158,6 +275,10 @@ func (g *Game) updateCalculationStatus() {
 		} else {
 			statusText += "\nClick valid border tile for river source."
 		}
+		statusText += fmt.Sprintf("\nRiver End: %s", g.currentSearchOptions().End)
+		if endConstraintPresets[g.endPresetIndex].UseTarget && g.endTarget == nil {
+			statusText += "\nRMB an empty tile to set the end."
+		}
 		g.calculationStatus = statusText
 	case StateCalculating:
 		scanType := "Global Scan"
@@ -166,6 +287,7 @@ func (g *Game) updateCalculationStatus() {
 		}
 		status := fmt.Sprintf("%s (Max %d):\n", scanType, g.lengthUsedForCurrentCalculation)
 		status += fmt.Sprintf("Scanning %d start(s) (Adj: %t)\n", g.numWorkersForCurrentCalc, g.DisableCrossRiverAdjacency)
+		status += fmt.Sprintf("River End: %s\n", g.optionsForCurrentCalculation.End)
 
 		profitOverall := 0.0
 		pathLenOverall := 0
@@ -176,6 +298,9 @@ func (g *Game) updateCalculationStatus() {
 			pathLenOverall = len(g.absoluteBestOverallSolution.Path)
 			pathStart = g.absoluteBestOverallSolution.Path[0]
 			status += fmt.Sprintf("Best Found: %.2f%% (Path %d)\n", profitOverall, pathLenOverall)
+			if g.optionsForCurrentCalculation.Objective != game.ObjectiveProfit {
+				status += g.optionsForCurrentCalculation.FormatScore(g.absoluteBestOverallSolution) + "\n"
+			}
 			status += fmt.Sprintf("From Start: (%d,%d)\n", pathStart.X, pathStart.Y)
 		} else {
 			status += "Best Found: None yet\n"
@@ -192,8 +317,18 @@ func (g *Game) updateCalculationStatus() {
 			profit*100,
 			len(g.finalBestSolution.Path),
 			g.maxLenUsedForFinalSolution)
+		if g.finalBestSolution.Profit >= 0 && g.finalBestSolution.Stats != (game.Stats{AttackSpeed: g.finalBestSolution.Profit}) {
+			status += fmt.Sprintf("\n%s", g.finalBestSolution.Stats) // Show the stat breakdown when it differs from plain attack speed
+		}
+		if g.finalBestSolution.Profit >= 0 && g.optionsForCurrentCalculation.Objective != game.ObjectiveProfit {
+			status += "\n" + g.optionsForCurrentCalculation.FormatScore(g.finalBestSolution)
+		}
 		status += fmt.Sprintf("\nAdj. MaxLen: %d (PgUp/PgDn: 5-%d).", g.currentMaxRiverLength, maxRiverLengthCap)
 		g.calculationStatus = status
+	case StateReviewingDetection:
+		g.calculationStatus = g.reviewStatus()
+	case StateCalibratingGrid:
+		g.calculationStatus = g.calibrationStatus()
 	}
 }
 
@@ -314,6 +449,10 @@ func (g *Game) Update() error {
 					g.updateCalculationStatus() // Update status to show selected start, e.g., "Selected Start: (X,Y)"
 					g.updateButtonsForState()   // Update buttons, e.g., "Start Calculation" button might become fully enabled or change text
 				}
+			case StateReviewingDetection:
+				g.cycleReviewTile(gridX, gridY, false)
+			case StateCalibratingGrid:
+				g.pickCalibrationCorner(mouseX-panelWidth, mouseY)
 			}
 		}
 	}
@@ -383,9 +522,36 @@ func (g *Game) Update() error {
 		}
 	}
 
+	// RMB while reviewing a detection steps the tile's type backwards
+	if g.gameState == StateReviewingDetection && inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonRight) {
+		mouseX, mouseY := ebiten.CursorPosition()
+		if mouseX >= panelWidth { // Only if cursor is in game area
+			g.cycleReviewTile((mouseX-panelWidth)/tileSize, mouseY/tileSize, true)
+		}
+	}
+
+	// RMB while picking the river source sets the river end target
+	if g.gameState == StatePlacingRiverSource && inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonRight) {
+		mouseX, mouseY := ebiten.CursorPosition()
+		if mouseX >= panelWidth { // Only if cursor is in game area
+			gridX, gridY := (mouseX-panelWidth)/tileSize, mouseY/tileSize
+			if gridX >= 0 && gridX < game.GridWidth && gridY >= 0 && gridY < game.GridHeight && g.roadLayoutGrid[gridY][gridX] == game.Empty {
+				g.endTarget = &game.Coordinate{X: gridX, Y: gridY}
+				g.endPresetIndex = targetEndPresetIndex
+				fmt.Printf("[DEBUG] River end target set by RMB: (%d, %d)\n", gridX, gridY)
+				g.updateCalculationStatus()
+				g.updateButtonsForState()
+			}
+		}
+	}
+
 	// Global Escape handling
 	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
 		switch g.gameState {
+		case StateReviewingDetection:
+			g.discardDetectionReview()
+		case StateCalibratingGrid:
+			g.cancelGridCalibration()
 		case StatePlacingRiverSource:
 			// Transition back to StatePlacingRoad
 			g.gameState = StatePlacingRoad
@@ -471,15 +637,25 @@ func (g *Game) Draw(screen *ebiten.Image) {
 		drawGrid = g.grid
 	}
 
-	gameSubImage.Fill(color.RGBA{R: 50, G: 50, B: 50, A: 255})
+	gameSubImage.Fill(game.BackgroundColor)
+
+	// A detection under review replaces the grid with the screenshot and its classification
+	if g.gameState == StateReviewingDetection {
+		g.drawDetectionReview(gameSubImage)
+		screen.DrawImage(gameSubImage, gameImageOp)
+		return
+	}
+	if g.gameState == StateCalibratingGrid {
+		g.drawGridCalibration(gameSubImage)
+		screen.DrawImage(gameSubImage, gameImageOp)
+		return
+	}
 
 	for y := 0; y < game.GridHeight; y++ {
 		for x := 0; x < game.GridWidth; x++ {
 			tileX, tileY := float64(x*tileSize), float64(y*tileSize)
 			var tileColor color.Color
 
-			currentTileType := drawGrid[y][x]
-
 			// Highlight valid river starts in yellow if in that state, on top of the Empty tile color
 			isHighlightedStart := false
 			if g.gameState == StatePlacingRiverSource {
@@ -494,25 +670,17 @@ func (g *Game) Draw(screen *ebiten.Image) {
 			if isHighlightedStart {
 				tileColor = color.RGBA{R: 255, G: 255, B: 0, A: 255} // Bright Yellow for valid start
 			} else {
-				switch currentTileType {
-				case game.Empty:
-					tileColor = color.RGBA{R: 100, G: 100, B: 100, A: 255} // Gray
-				case game.Road:
-					tileColor = color.RGBA{R: 200, G: 200, B: 0, A: 255} // Yellowish for Road
-				case game.River:
-					tileColor = color.RGBA{R: 0, G: 0, B: 200, A: 255} // Blue
-				case game.Forest:
-					tileColor = color.RGBA{R: 0, G: 150, B: 0, A: 255} // Green
-				case game.Forbidden:
-					tileColor = color.RGBA{R: 150, G: 0, B: 0, A: 255} // Dark Red
-				default:
-					tileColor = color.RGBA{R: 30, G: 30, B: 30, A: 255} // Dark Gray for unknown
-				}
+				tileColor = drawGrid.TileColor(game.Coordinate{X: x, Y: y}) // Shared with the PNG renderer
 			}
 			ebitenutil.DrawRect(gameSubImage, tileX, tileY, float64(tileSize-1), float64(tileSize-1), tileColor)
 		}
 	}
 
+	// Mark the river end target while it is in use
+	if g.endTarget != nil && endConstraintPresets[g.endPresetIndex].UseTarget && (g.gameState == StatePlacingRiverSource || g.gameState == StateCalculating) {
+		ebitenutil.DrawRect(gameSubImage, float64(g.endTarget.X*tileSize), float64(g.endTarget.Y*tileSize), float64(tileSize-1), float64(tileSize-1), color.RGBA{R: 0, G: 200, B: 200, A: 160}) // Semi-transparent cyan on end target
+	}
+
 	// Draw the current path from overallBestSolutionInIterativeRun if calculating iteratively
 	// This section needs to be updated to use g.absoluteBestOverallSolution
 	if g.gameState == StateCalculating && g.absoluteBestOverallSolution.Profit >= 0 && len(g.absoluteBestOverallSolution.Path) > 0 {
@@ -545,106 +713,99 @@ func (g *Game) Layout(outsideWidth, outsideHeight int) (int, int) {
 	return screenWidth, screenHeight
 }
 
-// This is the new worker goroutine function.
-// It calculates paths for a single starting tile across various lengths.
-func (g *Game) runPathCalculationWorker(
-	startNode game.Coordinate,
-	userSelectedMaxLength int,
-	stopChan chan struct{}, // Shared stop channel for all workers of a calculation batch
-	disableCrossAdjacencyForCalc bool,
-	roadLayoutAtCalcStart game.Grid, // Pass a copy of the roadLayoutGrid at the time of calculation start
-	workerCalcID int, // The calculation ID this worker belongs to
-) {
-	defer g.activeCalculationGoroutines.Done() // Signal that this worker has finished
-
-	fmt.Printf("[Worker %v, CalcID %d] Started. MaxLen: %d\n", startNode, workerCalcID, userSelectedMaxLength)
-
-	// Each worker has its own best solution found for its startNode. Grid is copied by value automatically.
-	workerOverallBestSolution := game.RiverPathSolution{Grid: roadLayoutAtCalcStart, Profit: -1.0, Path: nil}
-
-	for lengthToTest := minRiverLength; lengthToTest <= userSelectedMaxLength; lengthToTest++ {
-		// --- Check for stop signal or outdated calculation before starting a length test ---
-		select {
-		case <-stopChan:
-			fmt.Printf("[Worker %v, CalcID %d] Stop signal received before testing length %d. Exiting.\n", startNode, workerCalcID, lengthToTest)
-			return // Exit worker if global stop is signaled
-		default:
-			// Non-blocking check if this worker's calculation ID is still current
-			g.mu.Lock()
-			if workerCalcID != g.currentCalculationID {
-				g.mu.Unlock()
-				fmt.Printf("[Worker %v, CalcID %d] Outdated (current global CalcID is %d). Exiting before length %d.\n", startNode, workerCalcID, g.currentCalculationID, lengthToTest)
-				return // Exit if this worker is for an old calculation batch
-			}
-			// Optional: Update some per-worker progress indicator if we had one
-			// fmt.Printf("[Worker %v, CalcID %d] Testing length %d...\n", startNode, workerCalcID, lengthToTest)
-			g.mu.Unlock()
-		}
-
-		// This will store the best solution found for the current startNode and current lengthToTest. Grid is copied by value.
-		currentLengthBestSolution := game.RiverPathSolution{Grid: roadLayoutAtCalcStart, Profit: -1.0, Path: nil}
-
-		// Progress callback for FindOptimalRiverAndForests for this specific length test
-		lengthProgressCb := func(intermediateSolutionForThisLengthTest game.RiverPathSolution) {
-			// This callback is for the current startNode and lengthToTest
-			// It finds the best solution *for this specific lengthToTest*
-			if intermediateSolutionForThisLengthTest.Profit > currentLengthBestSolution.Profit {
-				currentLengthBestSolution = intermediateSolutionForThisLengthTest
-			}
-		}
-
-		// FindOptimalRiverAndForests expects to operate on a grid.
-		// It modifies the grid it's called on. So, give it a fresh copy of the road layout for each length.
-		// Since game.Grid is an array type, assignment creates a copy.
-		gridForThisLengthTest := roadLayoutAtCalcStart
-		_, errThisLength := gridForThisLengthTest.FindOptimalRiverAndForests(startNode, lengthToTest, lengthProgressCb, stopChan, disableCrossAdjacencyForCalc)
+// startGlobalCalculation scans every valid river start of the road layout with the current
+// max length and rules ("Calculate All Valid Starts", "Recalculate All", and imports from the
+// screenshot watcher with auto-calculate on).
+// NOTE: g.mu is assumed to be HELD by the caller
+func (g *Game) startGlobalCalculation() {
+	g.validRiverStarts = g.roadLayoutGrid.GetValidRiverStarts() // Ensure it's fresh
+	g.startCalculation(g.validRiverStarts)
+}
 
-		// --- After a length is fully tested (or stopped partway for this length) ---
-		g.mu.Lock()
-		// Double-check if this worker is still for the current calculation before updating global state
-		if workerCalcID != g.currentCalculationID {
-			g.mu.Unlock()
-			fmt.Printf("[Worker %v, CalcID %d] Outdated (current global CalcID is %d) after testing length %d. Discarding result.\n", startNode, workerCalcID, g.currentCalculationID, lengthToTest)
-			return // Exit if outdated
-		}
+// startCalculation runs game.Solve for starts with the current max length and rules in the
+// background, the same search the solve command runs, and shows the best solution so far.
+// NOTE: g.mu is assumed to be HELD by the caller
+func (g *Game) startCalculation(starts []game.Coordinate) {
+	g.gameState = StateCalculating
+	g.panelPage = PageMain
+	g.updateButtonsForState() // Ensure Stop button appears immediately
+	g.calculationStartTime = time.Now()
+
+	// Grid is an array type, assignment copies. Initialize with the current road layout.
+	g.absoluteBestOverallSolution = game.RiverPathSolution{Grid: g.roadLayoutGrid, Profit: -1.0, Path: nil}
+	g.stopCalcChannel = make(chan struct{})                     // Make sure this is fresh for each new calculation cycle
+	g.lengthUsedForCurrentCalculation = g.currentMaxRiverLength // Store the user's target max length
+	g.optionsForCurrentCalculation = g.currentSearchOptions()
+	g.calculationID++
+	g.currentCalculationID = g.calculationID
+	g.numWorkersForCurrentCalc = len(starts)
+
+	fmt.Printf("[DEBUG] Launching Calculation. MaxLen: %d, StopChan: %p, DisableCrossAdj: %t, NumStarts: %d, CalcID: %d\n",
+		g.lengthUsedForCurrentCalculation, g.stopCalcChannel, g.DisableCrossRiverAdjacency, g.numWorkersForCurrentCalc, g.currentCalculationID)
+
+	opts := game.SolveOptions{
+		Starts:                     starts,
+		MaxLength:                  g.lengthUsedForCurrentCalculation,
+		DisableCrossRiverAdjacency: g.DisableCrossRiverAdjacency,
+		Search:                     g.optionsForCurrentCalculation,
+	}
+	go g.runCalculation(g.currentCalculationID, g.stopCalcChannel, g.roadLayoutGrid, opts) // Pass roadLayoutGrid by value
+}
 
-		if errThisLength == nil { // Completed this length test successfully
-			if currentLengthBestSolution.Profit > workerOverallBestSolution.Profit {
-				workerOverallBestSolution = currentLengthBestSolution
-				// The grid in workerOverallBestSolution is the one modified by FindOptimalRiverAndForests
-			}
-		} else if errThisLength.Error() == "search stopped by user" {
-			// If search for this length was stopped, currentLengthBestSolution might hold a partial (but valid) result.
-			if currentLengthBestSolution.Profit > workerOverallBestSolution.Profit {
-				workerOverallBestSolution = currentLengthBestSolution
+// runCalculation runs the calculation started by startCalculation and shows its result when it ends.
+func (g *Game) runCalculation(calcID int, stopChan chan struct{}, roadLayout game.Grid, opts game.SolveOptions) {
+	if len(opts.Starts) == 0 {
+		fmt.Println("[DEBUG] No valid river starts to calculate.")
+	} else {
+		_, err := game.Solve(roadLayout, opts, func(p game.SolveProgress) {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			if calcID != g.currentCalculationID {
+				return // Outdated calculation, its result is discarded
 			}
-			// This worker will now update global best (if applicable) and then exit due to the stop signal.
-		} else {
-			fmt.Printf("[Worker %v, CalcID %d] Error testing length %d: %v\n", startNode, workerCalcID, lengthToTest, errThisLength)
-		}
-
-		// Compare this worker's best solution found so far (workerOverallBestSolution)
-		// with the global absolute best solution.
-		if workerOverallBestSolution.Profit > g.absoluteBestOverallSolution.Profit {
-			fmt.Printf("[Worker %v, CalcID %d] New global best found! Profit: %.2f%% (was %.2f%%). Path len: %d, At length test: %d\n",
-				startNode, workerCalcID, workerOverallBestSolution.Profit*100, g.absoluteBestOverallSolution.Profit*100, len(workerOverallBestSolution.Path), lengthToTest)
-			g.absoluteBestOverallSolution = workerOverallBestSolution // This worker's best is now the global best
-			// Update the main game grid for live display if the solution is valid
-			if g.absoluteBestOverallSolution.Profit >= 0 && len(g.absoluteBestOverallSolution.Path) > 0 { // Path is sufficient to check validity
-				g.grid = g.absoluteBestOverallSolution.Grid // Show the new best grid
+			if p.Improved {
+				fmt.Printf("[DEBUG] CalcID %d: new best from %v at length %d. Profit: %.2f%%, path len: %d\n",
+					calcID, p.Start, p.Length, p.Best.Profit*100, len(p.Best.Path))
+				g.absoluteBestOverallSolution = p.Best
+				if p.Best.Profit >= 0 && len(p.Best.Path) > 0 {
+					g.grid = p.Best.Grid // Show the new best grid
+				}
 			}
+			g.updateCalculationStatus() // Update the status text on the UI panel
+		}, stopChan)
+		if err != nil {
+			fmt.Printf("[DEBUG] CalcID %d: %v\n", calcID, err)
 		}
-		g.updateCalculationStatus() // Update the status text on the UI panel
-		g.mu.Unlock()
-
-		// If an error occurred that indicates a stop (like "search stopped by user"), exit the worker's loop.
-		if errThisLength != nil && errThisLength.Error() == "search stopped by user" {
-			fmt.Printf("[Worker %v, CalcID %d] Confirming exit due to stop signal after processing length %d.\n", startNode, workerCalcID, lengthToTest)
-			return
-		}
-	} // End of loop for lengthToTest
+	}
 
-	fmt.Printf("[Worker %v, CalcID %d] Finished all lengths.\n", startNode, workerCalcID)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if calcID != g.currentCalculationID {
+		fmt.Printf("[DEBUG] Calculation for outdated CalcID %d (current %d) finished. No state change.\n", calcID, g.currentCalculationID)
+		return
+	}
+	g.gameState = StateShowingResult
+	g.finalBestSolution = g.absoluteBestOverallSolution
+	if g.finalBestSolution.Path != nil {
+		g.maxLenUsedForFinalSolution = len(g.finalBestSolution.Path)
+		g.grid = g.finalBestSolution.Grid
+	} else { // If no path, reset to road layout
+		g.finalBestSolution.Grid = roadLayout // Assignment copies array
+		g.finalBestSolution.Profit = -1.0
+		g.maxLenUsedForFinalSolution = 0
+		g.grid = roadLayout
+	}
+	select {
+	case <-stopChan:
+	default:
+		close(stopChan)
+	}
+	if g.stopCalcChannel == stopChan {
+		g.stopCalcChannel = nil
+	}
+	g.updateButtonsForState()
+	g.updateCalculationStatus()
+	fmt.Printf("[DEBUG] Calculation %d: Transitioned to StateShowingResult. Final best profit: %.2f%%\n", calcID, g.finalBestSolution.Profit*100)
 }
 
 func (g *Game) updateButtonsForState() {
@@ -652,33 +813,39 @@ func (g *Game) updateButtonsForState() {
 	buttonMinX := buttonMargin
 	buttonMaxX := panelWidth - buttonMargin
 
+	// The rules page replaces the state buttons until "Back" is pressed (not reachable while calculating)
+	if g.panelPage == PageRules && g.gameState != StateCalculating {
+		g.appendRulesPageButtons(buttonMinX, buttonMaxX)
+		return
+	}
+	if g.panelPage == PageFiles && g.gameState != StateCalculating {
+		g.appendFilesPageButtons(buttonMinX, buttonMaxX)
+		return
+	}
+
 	switch g.gameState {
 	case StatePlacingRoad:
-		// Add Cross Adjacency Toggle Button for StatePlacingRoad
-		crossAdjTextRoad := "Cross Adj: OFF"
-		if g.DisableCrossRiverAdjacency {
-			crossAdjTextRoad = "Cross Adj: ON"
-		}
+		g.buttons = append(g.buttons, g.rulesPageButton(buttonMinX, buttonMaxX))
+		g.buttons = append(g.buttons, g.filesPageButton(buttonMinX, buttonMaxX))
 		g.buttons = append(g.buttons, Button{
 			Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
-			Text: crossAdjTextRoad,
+			Text: "Detect Road from Image File",
 			OnClick: func(g *Game) {
-				g.DisableCrossRiverAdjacency = !g.DisableCrossRiverAdjacency
-				g.updateButtonsForState() // Refresh button panel
+				g.handleDetectRoadFromImage()
 			},
 		})
 		g.buttons = append(g.buttons, Button{
 			Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
-			Text: "Detect Road from Image File",
+			Text: "Detect from Clipboard",
 			OnClick: func(g *Game) {
-				g.handleDetectRoadFromImage()
+				g.handleDetectRoadFromClipboard()
 			},
 		})
 		g.buttons = append(g.buttons, Button{
 			Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
-			Text: "Detect from Clipboard",
+			Text: "Capture Game Window",
 			OnClick: func(g *Game) {
-				g.handleDetectRoadFromClipboard()
+				g.handleCaptureGameWindow()
 			},
 		})
 		// Ensure no trailing comma here before the next button or end of list
@@ -703,19 +870,8 @@ func (g *Game) updateButtonsForState() {
 			},
 		})
 	case StatePlacingRiverSource:
-		// Add Cross Adjacency Toggle Button for StatePlacingRiverSource
-		crossAdjTextSource := "Cross Adj: OFF"
-		if g.DisableCrossRiverAdjacency {
-			crossAdjTextSource = "Cross Adj: ON"
-		}
-		g.buttons = append(g.buttons, Button{
-			Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
-			Text: crossAdjTextSource,
-			OnClick: func(g *Game) {
-				g.DisableCrossRiverAdjacency = !g.DisableCrossRiverAdjacency
-				g.updateButtonsForState() // Refresh button panel
-			},
-		})
+		g.buttons = append(g.buttons, g.rulesPageButton(buttonMinX, buttonMaxX))
+		g.buttons = append(g.buttons, g.filesPageButton(buttonMinX, buttonMaxX))
 
 		// Button for calculating only the selected start
 		selectedStartButtonText := "Calculate Selected Start (Pick One)"
@@ -738,73 +894,7 @@ func (g *Game) updateButtonsForState() {
 					return // Do nothing if no valid source is selected
 				}
 				fmt.Printf("[DEBUG] Calculate Selected Start button clicked for (%d,%d).\n", g.selectedRiverStart.X, g.selectedRiverStart.Y)
-				g.gameState = StateCalculating
-				g.updateButtonsForState()
-				g.calculationStartTime = time.Now()
-				g.absoluteBestOverallSolution = game.RiverPathSolution{Grid: g.roadLayoutGrid, Profit: -1.0, Path: nil}
-				g.stopCalcChannel = make(chan struct{})
-				g.lengthUsedForCurrentCalculation = g.currentMaxRiverLength
-				g.calculationID++
-				g.currentCalculationID = g.calculationID
-				// For single start calculation, validRiverStarts will contain only the selected one
-				startsForThisCalc := []game.Coordinate{g.selectedRiverStart}
-				g.numWorkersForCurrentCalc = 1 // Single worker
-
-				fmt.Printf("[DEBUG] Launching Single Start Calculation. MaxLen: %d, DisableCrossAdj: %t, Start: (%d,%d), CalcID: %d\n",
-					g.lengthUsedForCurrentCalculation, g.DisableCrossRiverAdjacency, g.selectedRiverStart.X, g.selectedRiverStart.Y, g.currentCalculationID)
-
-				go func(masterCalcID int, masterStopChan chan struct{}, maxLength int, disableAdj bool, roadLayout game.Grid, specificStarts []game.Coordinate) {
-					defer func() {
-						g.mu.Lock()
-						defer g.mu.Unlock()
-						if masterCalcID != g.currentCalculationID {
-							fmt.Printf("[DEBUG] Master goroutine for outdated SINGLE START calcID %d (current %d) finished. No state change.\n", masterCalcID, g.currentCalculationID)
-							return
-						}
-						fmt.Printf("[DEBUG] Master goroutine (SINGLE START calcID %d) finished.\n", masterCalcID)
-						g.gameState = StateShowingResult
-						g.finalBestSolution = g.absoluteBestOverallSolution
-						if g.finalBestSolution.Path == nil {
-							g.finalBestSolution.Grid = roadLayout
-							g.finalBestSolution.Profit = -1.0
-						}
-						if g.finalBestSolution.Path != nil {
-							g.maxLenUsedForFinalSolution = len(g.finalBestSolution.Path)
-						} else {
-							g.maxLenUsedForFinalSolution = 0
-						}
-						if g.finalBestSolution.Path != nil {
-							g.grid = g.finalBestSolution.Grid
-						} else {
-							g.grid = roadLayout
-						}
-						if masterStopChan != nil {
-							select {
-							case <-masterStopChan:
-							default:
-								close(masterStopChan)
-							}
-							if g.stopCalcChannel == masterStopChan {
-								g.stopCalcChannel = nil
-							}
-						}
-						g.updateButtonsForState()
-						g.updateCalculationStatus()
-						fmt.Printf("[DEBUG] Single Start Calc: Transitioned to StateShowingResult. Final best profit: %.2f%%\n", g.finalBestSolution.Profit*100)
-					}()
-
-					if len(specificStarts) == 0 { // Should not happen if button logic is correct
-						fmt.Println("[DEBUG] No start specified for single start calculation. Aborting.")
-						return
-					}
-					// Only one worker for the specific start
-					g.activeCalculationGoroutines.Add(1)
-					fmt.Printf("[DEBUG] Master goroutine (SINGLE START calcID %d): Launching worker for start %v\n", masterCalcID, specificStarts[0])
-					go g.runPathCalculationWorker(specificStarts[0], maxLength, masterStopChan, disableAdj, roadLayout, masterCalcID)
-
-					g.activeCalculationGoroutines.Wait() // Wait for the single worker
-					fmt.Printf("[DEBUG] Master goroutine (SINGLE START calcID %d): Wait finished.\n", masterCalcID)
-				}(g.currentCalculationID, g.stopCalcChannel, g.lengthUsedForCurrentCalculation, g.DisableCrossRiverAdjacency, g.roadLayoutGrid, startsForThisCalc)
+				g.startCalculation([]game.Coordinate{g.selectedRiverStart})
 			},
 		})
 
@@ -826,98 +916,7 @@ func (g *Game) updateButtonsForState() {
 			Text: startCalcButtonText,
 			OnClick: func(g *Game) {
 				fmt.Printf("[DEBUG] Start Global Calculation button clicked.\n")
-				g.gameState = StateCalculating
-				g.updateButtonsForState() // Ensure Stop button appears immediately
-				g.calculationStartTime = time.Now()
-
-				// Initialize for iterative calculation
-				// g.isIterativeCalculationActive = true // REMOVED
-				// g.overallBestSolutionInIterativeRun = game.RiverPathSolution{Grid: g.roadLayoutGrid, Profit: -1.0, Path: nil} // REMOVED
-				// g.intermediateBestSolution = g.overallBestSolutionInIterativeRun // REMOVED
-				// Grid is an array type, so assignment copies. Initialize with the current road layout.
-				g.absoluteBestOverallSolution = game.RiverPathSolution{Grid: g.roadLayoutGrid, Profit: -1.0, Path: nil}
-
-				g.stopCalcChannel = make(chan struct{}) // Make sure this is fresh for each new calculation cycle
-				// fmt.Printf("[DEBUG] Set to StateCalculating. Target MaxLen %d. stopCalcChannel created: %p\n", g.currentMaxRiverLength, g.stopCalcChannel) // Old log
-
-				// gridForCalculation := g.roadLayoutGrid // This will be handled by workers with copies // REMOVED
-				// startNode := g.selectedRiverStart // No longer a single start node here // REMOVED
-				// stopChan := g.stopCalcChannel // Will be passed to master goroutine // REMOVED
-				// userSelectedMaxLength := g.currentMaxRiverLength // Will be passed // REMOVED
-				// disableCrossAdjacencyForCalc := g.DisableCrossRiverAdjacency // Will be passed // REMOVED
-
-				g.lengthUsedForCurrentCalculation = g.currentMaxRiverLength // Store the user's target max length
-				g.calculationID++
-				g.currentCalculationID = g.calculationID
-				allValidStarts := g.roadLayoutGrid.GetValidRiverStarts() // Ensure it's fresh
-				g.numWorkersForCurrentCalc = len(allValidStarts)         // Set number of workers
-
-				fmt.Printf("[DEBUG] Launching Global Calculation. MaxLen: %d, StopChan: %p, DisableCrossAdj: %t, NumStarts: %d, CalcID: %d\n",
-					g.lengthUsedForCurrentCalculation, g.stopCalcChannel, g.DisableCrossRiverAdjacency, g.numWorkersForCurrentCalc, g.currentCalculationID)
-
-				// --- Launch Master Goroutine ---
-				go func(masterCalcID int, masterStopChan chan struct{}, maxLength int, disableAdj bool, roadLayout game.Grid, initialStarts []game.Coordinate) {
-					defer func() {
-						g.mu.Lock()
-						defer g.mu.Unlock()
-						if masterCalcID != g.currentCalculationID {
-							fmt.Printf("[DEBUG] Master goroutine for outdated RECALC ID %d (current %d) finished. No state change.\n", masterCalcID, g.currentCalculationID)
-							return
-						}
-						fmt.Printf("[DEBUG] Master goroutine (RECALC ID %d) finished.\n", masterCalcID)
-						g.gameState = StateShowingResult
-						g.finalBestSolution = g.absoluteBestOverallSolution
-						if g.finalBestSolution.Path == nil { // If no path, reset to road layout
-							g.finalBestSolution.Grid = roadLayout // Assignment copies array
-							// Path already nil
-							g.finalBestSolution.Profit = -1.0
-						}
-						if g.finalBestSolution.Path != nil {
-							g.maxLenUsedForFinalSolution = len(g.finalBestSolution.Path)
-						} else {
-							g.maxLenUsedForFinalSolution = 0
-						}
-						if g.finalBestSolution.Path != nil {
-							g.grid = g.finalBestSolution.Grid
-						} else {
-							g.grid = roadLayout // Assignment copies array
-						}
-						if masterStopChan != nil {
-							select {
-							case <-masterStopChan:
-							default:
-								close(masterStopChan)
-							}
-							if g.stopCalcChannel == masterStopChan {
-								g.stopCalcChannel = nil
-							}
-						}
-						g.updateButtonsForState()
-						g.updateCalculationStatus()
-						fmt.Printf("[DEBUG] Recalculation: Transitioned to StateShowingResult. Final best profit: %.2f%%\n", g.finalBestSolution.Profit*100)
-					}()
-
-					if len(initialStarts) == 0 {
-						fmt.Println("[DEBUG] No valid river starts for recalculation.")
-						return
-					}
-					for _, startNode := range initialStarts {
-						select {
-						case <-masterStopChan:
-							fmt.Printf("[DEBUG] Master goroutine (RECALC ID %d): stop signal before worker for %v.\n", masterCalcID, startNode)
-							g.activeCalculationGoroutines.Wait()
-							return
-						default:
-						}
-						g.activeCalculationGoroutines.Add(1)
-						fmt.Printf("[DEBUG] Master goroutine (RECALC ID %d): Launching worker for start %v\n", masterCalcID, startNode)
-						// Pass roadLayout by value (it's an array, so it gets copied)
-						go g.runPathCalculationWorker(startNode, maxLength, masterStopChan, disableAdj, roadLayout, masterCalcID)
-					}
-					fmt.Printf("[DEBUG] Master goroutine (RECALC ID %d): All %d workers launched. Waiting...\n", masterCalcID, len(initialStarts))
-					g.activeCalculationGoroutines.Wait()
-					fmt.Printf("[DEBUG] Master goroutine (RECALC ID %d): Wait finished.\n", masterCalcID)
-				}(g.currentCalculationID, g.stopCalcChannel, g.lengthUsedForCurrentCalculation, g.DisableCrossRiverAdjacency, g.roadLayoutGrid, g.validRiverStarts) // Pass roadLayoutGrid by value
+				g.startGlobalCalculation()
 			},
 		})
 		g.buttons = append(g.buttons, Button{
@@ -976,92 +975,32 @@ func (g *Game) updateButtonsForState() {
 		})
 
 	case StateShowingResult:
+		g.buttons = append(g.buttons, g.rulesPageButton(buttonMinX, buttonMaxX))
+		g.buttons = append(g.buttons, g.filesPageButton(buttonMinX, buttonMaxX))
 		g.buttons = append(g.buttons, Button{
 			Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
 			Text: "Recalculate All (New Max Len)",          // Changed text
 			OnClick: func(g *Game) {
-				// This will now trigger a new global calculation, similar to "Start Global Calculation"
 				fmt.Printf("Recalculating All with MaxLen: %d\n", g.currentMaxRiverLength)
-				g.gameState = StateCalculating
-				g.updateButtonsForState()
-				g.calculationStartTime = time.Now()
-
-				// Grid is an array type, assignment copies.
-				g.absoluteBestOverallSolution = game.RiverPathSolution{Grid: g.roadLayoutGrid, Profit: -1.0, Path: nil}
-				g.stopCalcChannel = make(chan struct{})
-				g.lengthUsedForCurrentCalculation = g.currentMaxRiverLength
-				g.calculationID++
-				g.currentCalculationID = g.calculationID
-				g.validRiverStarts = g.roadLayoutGrid.GetValidRiverStarts() // Refresh valid starts
-
-				fmt.Printf("[DEBUG] Launching Global Recalculation. MaxLen: %d, StopChan: %p, DisableCrossAdj: %t, NumStarts: %d, CalcID: %d\n",
-					g.lengthUsedForCurrentCalculation, g.stopCalcChannel, g.DisableCrossRiverAdjacency, len(g.validRiverStarts), g.currentCalculationID)
-
-				// --- Launch Master Goroutine (copied from Start Global Calculation) ---
-				go func(masterCalcID int, masterStopChan chan struct{}, maxLength int, disableAdj bool, roadLayout game.Grid, initialStarts []game.Coordinate) {
-					defer func() {
-						g.mu.Lock()
-						defer g.mu.Unlock()
-						if masterCalcID != g.currentCalculationID {
-							fmt.Printf("[DEBUG] Master goroutine for outdated RECALC ID %d (current %d) finished. No state change.\n", masterCalcID, g.currentCalculationID)
-							return
-						}
-						fmt.Printf("[DEBUG] Master goroutine (RECALC ID %d) finished.\n", masterCalcID)
-						g.gameState = StateShowingResult
-						g.finalBestSolution = g.absoluteBestOverallSolution
-						if g.finalBestSolution.Path == nil { // If no path, reset to road layout
-							g.finalBestSolution.Grid = roadLayout // Assignment copies array
-							// Path already nil
-							g.finalBestSolution.Profit = -1.0
-						}
-						if g.finalBestSolution.Path != nil {
-							g.maxLenUsedForFinalSolution = len(g.finalBestSolution.Path)
-						} else {
-							g.maxLenUsedForFinalSolution = 0
-						}
-						if g.finalBestSolution.Path != nil {
-							g.grid = g.finalBestSolution.Grid
-						} else {
-							g.grid = roadLayout // Assignment copies array
-						}
-						if masterStopChan != nil {
-							select {
-							case <-masterStopChan:
-							default:
-								close(masterStopChan)
-							}
-							if g.stopCalcChannel == masterStopChan {
-								g.stopCalcChannel = nil
-							}
-						}
-						g.updateButtonsForState()
-						g.updateCalculationStatus()
-						fmt.Printf("[DEBUG] Recalculation: Transitioned to StateShowingResult. Final best profit: %.2f%%\n", g.finalBestSolution.Profit*100)
-					}()
-
-					if len(initialStarts) == 0 {
-						fmt.Println("[DEBUG] No valid river starts for recalculation.")
-						return
-					}
-					for _, startNode := range initialStarts {
-						select {
-						case <-masterStopChan:
-							fmt.Printf("[DEBUG] Master goroutine (RECALC ID %d): stop signal before worker for %v.\n", masterCalcID, startNode)
-							g.activeCalculationGoroutines.Wait()
-							return
-						default:
-						}
-						g.activeCalculationGoroutines.Add(1)
-						fmt.Printf("[DEBUG] Master goroutine (RECALC ID %d): Launching worker for start %v\n", masterCalcID, startNode)
-						// Pass roadLayout by value (it's an array, so it gets copied)
-						go g.runPathCalculationWorker(startNode, maxLength, masterStopChan, disableAdj, roadLayout, masterCalcID)
-					}
-					fmt.Printf("[DEBUG] Master goroutine (RECALC ID %d): All %d workers launched. Waiting...\n", masterCalcID, len(initialStarts))
-					g.activeCalculationGoroutines.Wait()
-					fmt.Printf("[DEBUG] Master goroutine (RECALC ID %d): Wait finished.\n", masterCalcID)
-				}(g.currentCalculationID, g.stopCalcChannel, g.lengthUsedForCurrentCalculation, g.DisableCrossRiverAdjacency, g.roadLayoutGrid, g.validRiverStarts) // Pass roadLayoutGrid by value
+				g.startGlobalCalculation()
 			},
 		})
+		if len(g.finalBestSolution.Path) > 0 {
+			g.buttons = append(g.buttons, Button{
+				Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+				Text: "Plan Mountain Peaks",
+				OnClick: func(g *Game) {
+					g.handlePlanMountainPeaks()
+				},
+			})
+			g.buttons = append(g.buttons, Button{
+				Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+				Text: fmt.Sprintf("Place Meadows (%d)", meadowPlanCards),
+				OnClick: func(g *Game) {
+					g.handlePlaceMeadows()
+				},
+			})
+		}
 		g.buttons = append(g.buttons, Button{
 			Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
 			Text: "Change River Start",
@@ -1101,6 +1040,11 @@ func (g *Game) updateButtonsForState() {
 				g.updateButtonsForState() // Ensure buttons refresh for the new state
 			},
 		})
+
+	case StateReviewingDetection:
+		g.appendReviewButtons(buttonMinX, buttonMaxX)
+	case StateCalibratingGrid:
+		g.appendCalibrationButtons(buttonMinX, buttonMaxX)
 	}
 
 	// "Reset All (Clear Map)" button is always available
@@ -1111,6 +1055,469 @@ func (g *Game) updateButtonsForState() {
 	})
 }
 
+// rulesPageButton returns the button that opens the rules page.
+func (g *Game) rulesPageButton(buttonMinX, buttonMaxX int) Button {
+	return Button{
+		Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+		Text: "Rules...",
+		OnClick: func(g *Game) {
+			g.panelPage = PageRules
+			g.updateButtonsForState()
+		},
+	}
+}
+
+// appendRulesPageButtons adds the search rule toggles shown on the rules page.
+func (g *Game) appendRulesPageButtons(buttonMinX, buttonMaxX int) {
+	crossAdjText := "Cross Adj: OFF"
+	if g.DisableCrossRiverAdjacency {
+		crossAdjText = "Cross Adj: ON"
+	}
+	g.buttons = append(g.buttons, Button{
+		Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+		Text: crossAdjText,
+		OnClick: func(g *Game) {
+			g.DisableCrossRiverAdjacency = !g.DisableCrossRiverAdjacency
+			g.updateButtonsForState() // Refresh button panel
+		},
+	})
+	g.buttons = append(g.buttons, Button{
+		Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+		Text: "End: " + endConstraintPresets[g.endPresetIndex].Label,
+		OnClick: func(g *Game) {
+			g.endPresetIndex = (g.endPresetIndex + 1) % len(endConstraintPresets)
+			g.updateCalculationStatus()
+			g.updateButtonsForState()
+		},
+	})
+	g.buttons = append(g.buttons, Button{
+		Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+		Text: "Land: " + landscapePresets[g.landscapePresetIndex].Label,
+		OnClick: func(g *Game) {
+			g.landscapePresetIndex = (g.landscapePresetIndex + 1) % len(landscapePresets)
+			g.updateCalculationStatus()
+			g.updateButtonsForState()
+		},
+	})
+	g.buttons = append(g.buttons, Button{
+		Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+		Text: "Goal: " + objectivePresets[g.objectivePresetIndex].Label,
+		OnClick: func(g *Game) {
+			g.objectivePresetIndex = (g.objectivePresetIndex + 1) % len(objectivePresets)
+			g.updateCalculationStatus()
+			g.updateButtonsForState()
+		},
+	})
+	peaksText := "Peaks Near River: OFF"
+	if g.peaksNearRiver {
+		peaksText = "Peaks Near River: ON"
+	}
+	g.buttons = append(g.buttons, Button{
+		Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+		Text: peaksText,
+		OnClick: func(g *Game) {
+			g.peaksNearRiver = !g.peaksNearRiver
+			g.updateButtonsForState()
+		},
+	})
+	g.buttons = append(g.buttons, Button{
+		Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+		Text: "Back",
+		OnClick: func(g *Game) {
+			g.panelPage = PageMain
+			g.updateButtonsForState()
+		},
+	})
+}
+
+// filesPageButton returns the button that opens the files page.
+func (g *Game) filesPageButton(buttonMinX, buttonMaxX int) Button {
+	return Button{
+		Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+		Text: "Files...",
+		OnClick: func(g *Game) {
+			g.panelPage = PageFiles
+			g.updateButtonsForState()
+		},
+	}
+}
+
+// appendFilesPageButtons adds the save/load buttons shown on the files page.
+func (g *Game) appendFilesPageButtons(buttonMinX, buttonMaxX int) {
+	g.buttons = append(g.buttons, Button{
+		Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+		Text: "Save Plan (JSON)",
+		OnClick: func(g *Game) {
+			g.handleSavePlanFile()
+		},
+	})
+	g.buttons = append(g.buttons, Button{
+		Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+		Text: "Save Map (Text)",
+		OnClick: func(g *Game) {
+			g.handleSaveASCIIMap()
+		},
+	})
+	g.buttons = append(g.buttons, Button{
+		Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+		Text: "Load Plan (JSON/Text)",
+		OnClick: func(g *Game) {
+			g.handleLoadPlanFile()
+		},
+	})
+	g.buttons = append(g.buttons, Button{
+		Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+		Text: "Export Image (PNG/SVG/GIF)",
+		OnClick: func(g *Game) {
+			g.handleExportImage()
+		},
+	})
+	g.buttons = append(g.buttons, Button{
+		Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+		Text: "Copy Code",
+		OnClick: func(g *Game) {
+			g.handleCopyLayoutCode()
+		},
+	})
+	g.buttons = append(g.buttons, Button{
+		Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+		Text: "Paste Code",
+		OnClick: func(g *Game) {
+			g.handlePasteLayoutCode()
+		},
+	})
+	g.appendWatchButtons(buttonMinX, buttonMaxX)
+	g.buttons = append(g.buttons, Button{
+		Rect: image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+		Text: "Back",
+		OnClick: func(g *Game) {
+			g.panelPage = PageMain
+			g.updateButtonsForState()
+		},
+	})
+}
+
+// currentPlanFile collects the road layout, rules, start and result into a game.PlanFile.
+func (g *Game) currentPlanFile() game.PlanFile {
+	// NOTE: g.mu is assumed to be HELD by the caller
+	roadGrid := g.roadLayoutGrid
+	if g.gameState == StatePlacingRoad {
+		roadGrid = g.grid // The road is still being edited and not finalized yet
+	}
+	plan := game.PlanFile{
+		Roads:     roadGrid.RoadTiles(),
+		Rules:     game.PlanRules{DisableCrossRiverAdjacency: g.DisableCrossRiverAdjacency, SearchOptions: g.currentSearchOptions()},
+		MaxLength: g.currentMaxRiverLength,
+	}
+	if g.gameState == StateShowingResult {
+		plan.Rules = game.PlanRules{DisableCrossRiverAdjacency: g.DisableCrossRiverAdjacency, SearchOptions: g.optionsForCurrentCalculation}
+		plan.MaxLength = g.lengthUsedForCurrentCalculation
+	}
+	if !(g.selectedRiverStart.X == 0 && g.selectedRiverStart.Y == 0) { // (0,0) means no start is selected
+		start := g.selectedRiverStart
+		plan.Start = &start
+	}
+	if g.gameState == StateShowingResult && len(g.finalBestSolution.Path) > 0 {
+		plan.Solution = &game.PlanSolution{
+			Path:   g.finalBestSolution.Path,
+			Profit: g.finalBestSolution.Profit,
+			Stats:  g.finalBestSolution.Stats,
+			Grid:   g.finalBestSolution.Grid,
+		}
+	}
+	return plan
+}
+
+// applyPlanFile restores the UI from a loaded plan file. Rules are mapped back onto the
+// closest rule presets; settings no preset matches fall back to the first preset.
+func (g *Game) applyPlanFile(plan game.PlanFile) {
+	// NOTE: g.mu is assumed to be HELD by the caller
+	g.DisableCrossRiverAdjacency = plan.Rules.DisableCrossRiverAdjacency
+	g.endPresetIndex, g.endTarget, g.landscapePresetIndex, g.objectivePresetIndex = matchRulePresets(plan.Rules)
+	if plan.MaxLength >= minRiverLength && plan.MaxLength <= maxRiverLengthCap {
+		g.currentMaxRiverLength = plan.MaxLength
+	}
+	g.lengthUsedForCurrentCalculation = g.currentMaxRiverLength
+	g.optionsForCurrentCalculation = g.currentSearchOptions()
+
+	g.roadLayoutGrid = plan.RoadGrid()
+	g.validRiverStarts = g.roadLayoutGrid.GetValidRiverStarts()
+	g.selectedRiverStart = game.Coordinate{}
+	if plan.Start != nil {
+		g.selectedRiverStart = *plan.Start
+	}
+	g.finalBestSolution = game.RiverPathSolution{Grid: g.roadLayoutGrid, Profit: -1.0, Path: nil}
+	g.absoluteBestOverallSolution = g.finalBestSolution
+	g.maxLenUsedForFinalSolution = 0
+	g.calculationID++ // Results of planners still running for the old result are discarded
+	g.currentCalculationID = g.calculationID
+
+	switch {
+	case plan.Solution != nil:
+		g.finalBestSolution = game.RiverPathSolution{
+			Path:   plan.Solution.Path,
+			Profit: plan.Solution.Profit,
+			Stats:  plan.Solution.Stats,
+			Grid:   plan.Solution.Grid,
+		}
+		g.maxLenUsedForFinalSolution = len(plan.Solution.Path)
+		g.grid = plan.Solution.Grid
+		g.gameState = StateShowingResult
+	case plan.Start != nil:
+		g.grid = g.roadLayoutGrid
+		g.gameState = StatePlacingRiverSource
+	default:
+		g.grid = g.roadLayoutGrid
+		g.gameState = StatePlacingRoad
+	}
+	g.panelPage = PageMain
+	g.updateButtonsForState()
+	g.updateCalculationStatus()
+}
+
+// handleSavePlanFile asks for a file name and saves the current plan as JSON.
+func (g *Game) handleSavePlanFile() {
+	// NOTE: g.mu is assumed to be HELD by the caller (button click inside Update)
+	filePath, err := dialog.File().Filter("River Plan (JSON)", "json").Title("Save Plan").Save()
+	if err != nil {
+		if err == dialog.Cancelled {
+			log.Println("Save cancelled.")
+		} else {
+			log.Printf("Error opening save dialog: %v", err)
+			g.calculationStatus = "Error: Could not open save dialog."
+		}
+		return
+	}
+	if filepath.Ext(filePath) == "" {
+		filePath += ".json"
+	}
+	if err := game.SavePlanFile(filePath, g.currentPlanFile()); err != nil {
+		log.Printf("Error saving plan file '%s': %v", filePath, err)
+		g.calculationStatus = fmt.Sprintf("Error: Failed to save %s", filepath.Base(filePath))
+		return
+	}
+	log.Printf("Saved plan to %s", filePath)
+	g.updateCalculationStatus()
+	g.calculationStatus += fmt.Sprintf("\nSaved %s", filepath.Base(filePath))
+}
+
+// handleSaveASCIIMap asks for a file name and saves the current plan as an ASCII map.
+func (g *Game) handleSaveASCIIMap() {
+	// NOTE: g.mu is assumed to be HELD by the caller (button click inside Update)
+	filePath, err := dialog.File().Filter("ASCII Map", "txt").Title("Save Map").Save()
+	if err != nil {
+		if err == dialog.Cancelled {
+			log.Println("Save cancelled.")
+		} else {
+			log.Printf("Error opening save dialog: %v", err)
+			g.calculationStatus = "Error: Could not open save dialog."
+		}
+		return
+	}
+	if filepath.Ext(filePath) == "" {
+		filePath += ".txt"
+	}
+	if err := game.SaveASCIIMap(filePath, g.currentPlanFile()); err != nil {
+		log.Printf("Error saving map '%s': %v", filePath, err)
+		g.calculationStatus = fmt.Sprintf("Error: Failed to save %s", filepath.Base(filePath))
+		return
+	}
+	log.Printf("Saved map to %s", filePath)
+	g.updateCalculationStatus()
+	g.calculationStatus += fmt.Sprintf("\nSaved %s", filepath.Base(filePath))
+}
+
+// handleLoadPlanFile asks for a plan file (JSON or ASCII map) and restores the road, rules and result from it.
+func (g *Game) handleLoadPlanFile() {
+	// NOTE: g.mu is assumed to be HELD by the caller (button click inside Update)
+	filePath, err := dialog.File().Filter("River Plan (JSON, ASCII Map)", "json", "txt").Title("Load Plan").Load()
+	if err != nil {
+		if err == dialog.Cancelled {
+			log.Println("Load cancelled.")
+		} else {
+			log.Printf("Error opening file dialog: %v", err)
+			g.calculationStatus = "Error: Could not open file dialog."
+		}
+		return
+	}
+	plan, err := game.LoadPlan(filePath)
+	if err != nil {
+		log.Printf("Error loading plan file '%s': %v", filePath, err)
+		g.calculationStatus = fmt.Sprintf("Load Err: %v", err)
+		return
+	}
+	g.applyPlanFile(plan)
+	log.Printf("Loaded plan from %s (%d road tiles, solution: %t)", filePath, len(plan.Roads), plan.Solution != nil)
+	g.calculationStatus += fmt.Sprintf("\nLoaded %s", filepath.Base(filePath))
+}
+
+// handleExportImage asks for a file name and renders the current result (or the road layout)
+// to an image. The extension picks the format: .png (default), .svg, or .gif for the animated
+// card-by-card placement of the result.
+func (g *Game) handleExportImage() {
+	// NOTE: g.mu is assumed to be HELD by the caller (button click inside Update)
+	filePath, err := dialog.File().Filter("Images (PNG, SVG, GIF)", "png", "svg", "gif").Title("Export Image").Save()
+	if err != nil {
+		if err == dialog.Cancelled {
+			log.Println("Export cancelled.")
+		} else {
+			log.Printf("Error opening save dialog: %v", err)
+			g.calculationStatus = "Error: Could not open save dialog."
+		}
+		return
+	}
+	if filepath.Ext(filePath) == "" {
+		filePath += ".png"
+	}
+	sol := game.RiverPathSolution{Grid: g.grid, Profit: -1.0}
+	landscape := landscapePresets[g.landscapePresetIndex].Options
+	if g.gameState == StateShowingResult {
+		sol = g.finalBestSolution
+		landscape = g.optionsForCurrentCalculation.Landscape
+	}
+	if err := saveSolutionImage(filePath, sol, landscape); err != nil {
+		log.Printf("Error exporting '%s': %v", filePath, err)
+		g.calculationStatus = fmt.Sprintf("Error: %v", err)
+		return
+	}
+	log.Printf("Exported %s", filePath)
+	g.updateCalculationStatus()
+	g.calculationStatus += fmt.Sprintf("\nExported %s", filepath.Base(filePath))
+}
+
+// saveSolutionImage renders sol to filePath in the format picked by the extension: .png, .svg,
+// or .gif for the animated placement (which needs a river). Shared by the window and the terminal UI.
+func saveSolutionImage(filePath string, sol game.RiverPathSolution, landscape game.LandscapeOptions) error {
+	format := strings.ToLower(strings.TrimPrefix(filepath.Ext(filePath), "."))
+	opts := game.RenderOptions{Landscape: landscape, Title: strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))}
+	var save func(string, game.RiverPathSolution, game.RenderOptions) error
+	switch format {
+	case "png":
+		save = game.SaveSolutionPNG
+	case "svg":
+		save = game.SaveSolutionSVG
+	case "gif":
+		if len(sol.Path) == 0 {
+			return fmt.Errorf("GIF export needs a result")
+		}
+		save = game.SavePlacementGIF
+	default:
+		return fmt.Errorf("unknown image format .%s", format)
+	}
+	if err := save(filePath, sol, opts); err != nil {
+		return fmt.Errorf("failed to export %s: %w", filepath.Base(filePath), err)
+	}
+	return nil
+}
+
+// handleCopyLayoutCode copies the layout code of the current road, start and rules to the clipboard.
+func (g *Game) handleCopyLayoutCode() {
+	// NOTE: g.mu is assumed to be HELD by the caller (button click inside Update)
+	code, err := game.EncodeLayoutCode(g.currentPlanFile())
+	if err != nil {
+		log.Printf("Error encoding layout code: %v", err)
+		g.calculationStatus = fmt.Sprintf("Code Err: %v", err)
+		return
+	}
+	if err := clipboard.Init(); err != nil {
+		log.Printf("Error initializing clipboard (golang.design/x/clipboard): %v", err)
+		g.calculationStatus = fmt.Sprintf("Clipboard Init Err: %v", err)
+		return
+	}
+	clipboard.Write(clipboard.FmtText, []byte(code))
+	log.Printf("Copied layout code to clipboard: %s", code)
+	g.updateCalculationStatus()
+	g.calculationStatus += "\nLayout code copied."
+}
+
+// handlePasteLayoutCode loads the road, start and rules from a layout code on the clipboard.
+func (g *Game) handlePasteLayoutCode() {
+	// NOTE: g.mu is assumed to be HELD by the caller (button click inside Update)
+	if err := clipboard.Init(); err != nil {
+		log.Printf("Error initializing clipboard (golang.design/x/clipboard): %v", err)
+		g.calculationStatus = fmt.Sprintf("Clipboard Init Err: %v", err)
+		return
+	}
+	code := string(clipboard.Read(clipboard.FmtText))
+	if code == "" {
+		g.calculationStatus = "Error: Clipboard has no text."
+		return
+	}
+	plan, err := game.DecodeLayoutCode(code)
+	if err != nil {
+		log.Printf("Error decoding layout code %q: %v", code, err)
+		g.calculationStatus = fmt.Sprintf("Code Err: %v", err)
+		return
+	}
+	g.applyPlanFile(plan)
+	log.Printf("Loaded layout code (%d road tiles)", len(plan.Roads))
+	g.calculationStatus += "\nLayout code loaded."
+}
+
+// handlePlanMountainPeaks runs the mountain peak planner on the current result in the background.
+// The river banks are cleared first so peaks can claim them, then the remaining river-adjacent
+// tiles are re-planted with the selected landscape preset.
+func (g *Game) handlePlanMountainPeaks() {
+	// NOTE: g.mu is assumed to be HELD by the caller (button click inside Update)
+	if g.isPlanningLandscape || len(g.finalBestSolution.Path) == 0 {
+		return
+	}
+	g.isPlanningLandscape = true
+	g.calculationStatus = "Planning mountain peaks..."
+
+	baseGrid := g.finalBestSolution.Grid.WithoutLandscapes()
+	riverPath := g.finalBestSolution.Path
+	landscape := landscapePresets[g.landscapePresetIndex].Options
+	opts := game.PeakPlanOptions{Rocks: peakPlanRockCards, Mountains: peakPlanMountainCards, NearRiver: g.peaksNearRiver}
+	planCalcID := g.currentCalculationID
+
+	go func() {
+		plan, err := baseGrid.PlanMountainPeaks(opts, nil, nil)
+
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.isPlanningLandscape = false
+		if g.gameState != StateShowingResult || planCalcID != g.currentCalculationID {
+			fmt.Println("[DEBUG] Mountain peak plan finished for an outdated result. Discarding.")
+			return
+		}
+		if err != nil {
+			log.Printf("Error planning mountain peaks: %v", err)
+			g.calculationStatus = fmt.Sprintf("Peak Plan Err: %v", err)
+			return
+		}
+		profit, stats := plan.Grid.PlaceLandscapes(riverPath, landscape)
+		g.finalBestSolution.Grid = plan.Grid
+		g.finalBestSolution.Profit = profit
+		g.finalBestSolution.Stats = stats
+		g.grid = plan.Grid
+		g.updateCalculationStatus()
+		g.calculationStatus += fmt.Sprintf("\nPeaks: %d (HP +%.2f%%)", len(plan.Peaks), plan.HP*100)
+	}()
+}
+
+// handlePlaceMeadows runs the meadow planner as a second pass on the current result.
+// It is fast enough to run directly inside Update.
+func (g *Game) handlePlaceMeadows() {
+	// NOTE: g.mu is assumed to be HELD by the caller (button click inside Update)
+	if g.isPlanningLandscape || len(g.finalBestSolution.Path) == 0 {
+		return
+	}
+	plan, err := g.finalBestSolution.Grid.PlanMeadows(meadowPlanCards, nil, nil, nil)
+	if err != nil {
+		log.Printf("Error placing meadows: %v", err)
+		g.calculationStatus = fmt.Sprintf("Meadow Plan Err: %v", err)
+		return
+	}
+	landscape := landscapePresets[g.landscapePresetIndex].Options
+	g.finalBestSolution.Grid = plan.Grid
+	g.finalBestSolution.Stats = plan.Grid.TotalStats()
+	g.finalBestSolution.Profit = landscape.Profit(g.finalBestSolution.Stats)
+	g.grid = plan.Grid
+	g.updateCalculationStatus()
+	g.calculationStatus += fmt.Sprintf("\nMeadows: %d (%d blooming)", len(plan.Meadows), plan.Blooming)
+}
+
 func (g *Game) resetButtonAction(resetType string) {
 	// NOTE: g.mu is assumed to be HELD by the caller (e.g., the Update method)
 	// Do not attempt to lock/unlock g.mu within this function.
@@ -1142,6 +1549,14 @@ func (g *Game) resetButtonAction(resetType string) {
 		g.lengthUsedForCurrentCalculation = defaultInitialRiverLength // Reset this as well
 		g.maxLenUsedForFinalSolution = 0
 		g.DisableCrossRiverAdjacency = false
+		g.endPresetIndex = 0
+		g.endTarget = nil
+		g.landscapePresetIndex = 0
+		g.objectivePresetIndex = 0
+		g.peaksNearRiver = false
+		g.panelPage = PageMain
+		g.review = nil // Drop a detection under review
+		g.calibration = nil
 
 		// Reset solution holders, ensuring their grids point to the new empty grid
 		newEmptySolution := game.RiverPathSolution{Grid: game.NewGrid(), Profit: -1.0, Path: nil} // Use NewGrid() for array type
@@ -1178,70 +1593,8 @@ func (g *Game) resetButtonAction(resetType string) {
 	g.updateCalculationStatus() // Refresh status message
 }
 
-// detectAndCropGrid attempts to find the 12x21 game grid within a larger image and returns the cropped grid.
-func detectAndCropGrid(fullImage image.Image) (image.Image, error) {
-	imgBounds := fullImage.Bounds()
-	imgWidth := float64(imgBounds.Dx())
-	imgHeight := float64(imgBounds.Dy())
-
-	if imgWidth == 0 || imgHeight == 0 {
-		return nil, fmt.Errorf("input image has zero width or height")
-	}
-
-	// Calculate pixel coordinates from percentages
-	gridStartX := int(imgWidth * gridStartXPercent)
-	gridStartY := int(imgHeight * gridStartYPercent)
-	gridEndX := int(imgWidth * gridEndXPercent)
-	gridBottomY := int(imgHeight * gridBottomYPercent)
-
-	gridPixelWidth := gridEndX - gridStartX
-	gridPixelHeight := gridBottomY - gridStartY
-
-	if gridPixelWidth <= 0 || gridPixelHeight <= 0 {
-		return nil, fmt.Errorf("calculated grid pixel width (%.0f) or height (%.0f) is zero or negative. Image: %.0fx%.0f. Percentages: X(%.2f-%.2f) Y(%.2f-%.2f)",
-			float64(gridPixelWidth), float64(gridPixelHeight), imgWidth, imgHeight, gridStartXPercent*100, gridEndXPercent*100, gridStartYPercent*100, gridBottomYPercent*100)
-	}
-
-	// Define the crop rectangle
-	cropRect := image.Rect(gridStartX, gridStartY, gridEndX, gridBottomY)
-
-	log.Printf("Calculated crop rectangle: %+v (from ImgSize: %.0fx%.0f)", cropRect, imgWidth, imgHeight)
-
-	// Check if cropRect is valid and within the bounds of the original image
-	if !cropRect.In(imgBounds) && (cropRect.Min.X != imgBounds.Min.X || cropRect.Min.Y != imgBounds.Min.Y || cropRect.Max.X != imgBounds.Max.X || cropRect.Max.Y != imgBounds.Max.Y) {
-		// It's okay if the calculated rect is IDENTICAL to imgBounds (e.g. if percentages are 0-100 for a pre-cropped image)
-		// But if it's different AND not fully contained, it's an error.
-		// We also need to handle potential off-by-one from float to int conversions if rect is almost full image size
-		// A more lenient check might be needed if rounding causes issues near image edges
-		actualImgBoundsForCheck := imgBounds
-		if cropRect.Min.X >= actualImgBoundsForCheck.Max.X ||
-			cropRect.Min.Y >= actualImgBoundsForCheck.Max.Y ||
-			cropRect.Max.X <= actualImgBoundsForCheck.Min.X ||
-			cropRect.Max.Y <= actualImgBoundsForCheck.Min.Y ||
-			cropRect.Min.X < actualImgBoundsForCheck.Min.X ||
-			cropRect.Min.Y < actualImgBoundsForCheck.Min.Y ||
-			cropRect.Max.X > actualImgBoundsForCheck.Max.X ||
-			cropRect.Max.Y > actualImgBoundsForCheck.Max.Y {
-			return nil, fmt.Errorf("calculated crop rectangle %+v is outside image bounds %+v", cropRect, actualImgBoundsForCheck)
-		}
-		log.Printf("Warning: Crop rectangle %+v not strictly 'In' image bounds %+v, but seems valid due to edge calculations.", cropRect, actualImgBoundsForCheck)
-	}
-
-	// Crop the image
-	subImager, ok := fullImage.(interface {
-		SubImage(r image.Rectangle) image.Image
-	})
-	if !ok {
-		return nil, fmt.Errorf("image type does not support SubImage operation")
-	}
-	croppedImage := subImager.SubImage(cropRect)
-	log.Printf("Successfully cropped grid using percentages. Original: %.0fx%.0f, CropRect: %+v, Cropped: %dx%d",
-		imgWidth, imgHeight, cropRect, croppedImage.Bounds().Dx(), croppedImage.Bounds().Dy())
-	return croppedImage, nil
-}
-
 func (g *Game) handleDetectRoadFromImage() {
-	filePath, err := dialog.File().Filter("PNG Images", "png").Load()
+	filePath, err := dialog.File().Filter("Images (PNG, JPEG, BMP, WebP)", screenshotExtensions...).Load()
 	if err != nil {
 		if err == dialog.Cancelled {
 			log.Println("File selection cancelled.")
@@ -1254,177 +1607,13 @@ func (g *Game) handleDetectRoadFromImage() {
 
 	log.Printf("Selected file: %s", filePath)
 
-	file, err := os.Open(filePath)
-	if err != nil {
-		log.Printf("Error opening image file '%s': %v", filePath, err)
-		g.calculationStatus = fmt.Sprintf("Error: Failed to open %s", filePath)
-		return
-	}
-	defer file.Close()
-
-	fullImg, _, err := image.Decode(file) // Underscore for format string as we don't need it
-	if err != nil {
-		log.Printf("Error decoding image file '%s': %v", filePath, err)
-		g.calculationStatus = fmt.Sprintf("Error: Failed to decode %s", filePath)
-		return
-	}
-
-	// New Step: Detect and crop the grid from the full image
-	img, err := detectAndCropGrid(fullImg)
-	if err != nil {
-		log.Printf("Error detecting/cropping game grid: %v", err)
-		g.calculationStatus = fmt.Sprintf("Grid Detect Err: %v", err)
-		return // Don't proceed if grid detection failed
-	}
-
-	bounds := img.Bounds()
-	imgWidth := float64(bounds.Dx())  // This is cropped image width
-	imgHeight := float64(bounds.Dy()) // This is cropped image height
-
-	// Calculate cell dimensions from the image size.
-	cellWidth := imgWidth / float64(game.GridWidth)
-	cellHeight := imgHeight / float64(game.GridHeight)
-
-	if cellWidth <= 0 || cellHeight <= 0 {
-		log.Printf("Error: Image dimensions (%dx%d) result in zero or negative cell size.", bounds.Dx(), bounds.Dy())
-		g.calculationStatus = "Error: Invalid image dimensions for grid."
-		return
-	}
-
-	detectedRoadTiles := []game.Coordinate{}
-
-	// --- New brightness-based road detection ---
-	// 1. Determine the reference brightness from the top-left tile (0,0)
-	const sampleAreaSize = 4 // Sample a 4x4 area
-
-	// Calculate center of the top-left tile (0,0) in terms of image content coordinates
-	// Shifted sampling point closer to top-left (0.3, 0.3 relative offset)
-	targetCellX_ref := int((0.0 + 0.3) * cellWidth)
-	targetCellY_ref := int((0.0 + 0.3) * cellHeight)
-
-	// Define the 4x4 sampling rectangle for the reference tile, relative to image content top-left (0,0)
-	referenceRect := image.Rect(
-		targetCellX_ref-sampleAreaSize/2,
-		targetCellY_ref-sampleAreaSize/2,
-		targetCellX_ref+sampleAreaSize/2,
-		targetCellY_ref+sampleAreaSize/2,
-	)
-	referenceBrightness := getAverageBrightness(img, referenceRect)
-	// log.Printf("Reference brightness (tile 0,0): %.2f from rect %+v", referenceBrightness, referenceRect) // Removed unnecessary log
-
-	// 2. Iterate through all tiles and compare their brightness to the reference
-	//    excluding the bottom row (y from 0 to game.GridHeight-2)
-	for y := 0; y < game.GridHeight-1; y++ {
-		for x := 0; x < game.GridWidth; x++ {
-			// Calculate the center pixel of the current cell in the image content
-			// Shifted sampling point closer to top-left (0.3, 0.3 relative offset)
-			sampleCX := int((float64(x) + 0.3) * cellWidth)
-			sampleCY := int((float64(y) + 0.3) * cellHeight)
-
-			// Define the 4x4 sampling rectangle for the current tile, relative to image content top-left (0,0)
-			currentTileSampleRect := image.Rect(
-				sampleCX-sampleAreaSize/2,
-				sampleCY-sampleAreaSize/2,
-				sampleCX+sampleAreaSize/2,
-				sampleCY+sampleAreaSize/2,
-			)
-
-			currentTileBrightness := getAverageBrightness(img, currentTileSampleRect)
-
-			// If current tile is brighter than reference + threshold, consider it a road
-			if currentTileBrightness > referenceBrightness+brightnessDifferenceThreshold {
-				detectedRoadTiles = append(detectedRoadTiles, game.Coordinate{X: x, Y: y})
-				// log.Printf("Tile (%d,%d) is ROAD. Brightness: %.2f (Ref: %.2f + Thresh: %.2f). Rect: %+v", x, y, currentTileBrightness, referenceBrightness, brightnessDifferenceThreshold, currentTileSampleRect) // Removed unnecessary log
-			} else {
-				// log.Printf("Tile (%d,%d) is NOT ROAD. Brightness: %.2f (Ref: %.2f + Thresh: %.2f). Rect: %+v", x, y, currentTileBrightness, referenceBrightness, brightnessDifferenceThreshold, currentTileSampleRect) // Removed unnecessary log
-			}
-		}
-	}
-	// --- End new brightness-based road detection ---
-
-	g.grid = game.NewGrid() // Clear existing grid before applying new roads
-	g.grid.SetRoad(detectedRoadTiles)
-
-	// Update related game state after road detection
-	g.roadLayoutGrid = g.grid // Store this as the base road layout for calculations
-	g.finalBestSolution.Grid = g.grid
-	g.finalBestSolution.Profit = -1.0
-	g.finalBestSolution.Path = nil
-	// g.intermediateBestSolution = g.finalBestSolution // REMOVED
-	g.absoluteBestOverallSolution = game.RiverPathSolution{Grid: g.grid, Profit: -1.0, Path: nil}
-
-	log.Printf("Detected %d road tiles from image.", len(detectedRoadTiles))
-	g.calculationStatus = fmt.Sprintf("Detected %d road tiles. Finalize or edit.", len(detectedRoadTiles))
-	g.updateButtonsForState()   // To refresh UI if needed (e.g., button states)
-	g.updateCalculationStatus() // To show new status
-}
-
-// processDetectedImage takes a captured/loaded image, crops it, and runs road detection.
-// It updates the game state with the detected road layout.
-func (g *Game) processDetectedImage(fullImg image.Image, sourceDescription string) {
-	img, err := detectAndCropGrid(fullImg)
+	result, err := detect.DetectFile(filePath, g.detectOptions())
 	if err != nil {
-		log.Printf("Error detecting/cropping game grid from %s: %v", sourceDescription, err)
-		g.calculationStatus = fmt.Sprintf("Grid Detect Err from %s: %v", sourceDescription, err)
-		return
-	}
-
-	bounds := img.Bounds()
-	imgWidth := float64(bounds.Dx())
-	imgHeight := float64(bounds.Dy())
-
-	cellWidth := imgWidth / float64(game.GridWidth)
-	cellHeight := imgHeight / float64(game.GridHeight)
-
-	if cellWidth <= 0 || cellHeight <= 0 {
-		log.Printf("Error: Image dimensions (%dx%d) from %s result in zero or negative cell size.", bounds.Dx(), bounds.Dy(), sourceDescription)
-		g.calculationStatus = fmt.Sprintf("Error: Invalid image dimensions for grid from %s.", sourceDescription)
+		log.Printf("Error detecting road from '%s': %v", filePath, err)
+		g.calculationStatus = fmt.Sprintf("Grid Detect Err from %s: %v", filepath.Base(filePath), err)
 		return
 	}
-
-	detectedRoadTiles := []game.Coordinate{}
-
-	const sampleAreaSize = 4
-	targetCellX_ref := int((0.0 + 0.3) * cellWidth)
-	targetCellY_ref := int((0.0 + 0.3) * cellHeight)
-	referenceRect := image.Rect(
-		targetCellX_ref-sampleAreaSize/2,
-		targetCellY_ref-sampleAreaSize/2,
-		targetCellX_ref+sampleAreaSize/2,
-		targetCellY_ref+sampleAreaSize/2,
-	)
-	referenceBrightness := getAverageBrightness(img, referenceRect)
-
-	for y := 0; y < game.GridHeight-1; y++ {
-		for x := 0; x < game.GridWidth; x++ {
-			sampleCX := int((float64(x) + 0.3) * cellWidth)
-			sampleCY := int((float64(y) + 0.3) * cellHeight)
-			currentTileSampleRect := image.Rect(
-				sampleCX-sampleAreaSize/2,
-				sampleCY-sampleAreaSize/2,
-				sampleCX+sampleAreaSize/2,
-				sampleCY+sampleAreaSize/2,
-			)
-			currentTileBrightness := getAverageBrightness(img, currentTileSampleRect)
-			if currentTileBrightness > referenceBrightness+brightnessDifferenceThreshold {
-				detectedRoadTiles = append(detectedRoadTiles, game.Coordinate{X: x, Y: y})
-			}
-		}
-	}
-
-	g.grid = game.NewGrid()
-	g.grid.SetRoad(detectedRoadTiles)
-	g.roadLayoutGrid = g.grid
-	g.finalBestSolution.Grid = g.grid
-	g.finalBestSolution.Profit = -1.0
-	g.finalBestSolution.Path = nil
-	// g.intermediateBestSolution = g.finalBestSolution // REMOVED
-	g.absoluteBestOverallSolution = game.RiverPathSolution{Grid: g.grid, Profit: -1.0, Path: nil}
-
-	log.Printf("Detected %d road tiles from %s.", len(detectedRoadTiles), sourceDescription)
-	g.calculationStatus = fmt.Sprintf("Detected %d road tiles from %s. Finalize or edit.", len(detectedRoadTiles), sourceDescription)
-	g.updateButtonsForState()
-	g.updateCalculationStatus()
+	g.processDetectedImage(result, filepath.Base(filePath))
 }
 
 // abs is a helper function for absolute integer value.
@@ -1435,47 +1624,6 @@ func abs(x int) int {
 	return x
 }
 
-// getAverageBrightness calculates the average brightness of pixels within a given rectangle in an image.
-// The relativeRect's coordinates are 0-indexed relative to the logical top-left of the img's content.
-func getAverageBrightness(img image.Image, relativeRect image.Rectangle) float64 {
-	var totalBrightness float64
-	var count int
-
-	imgBounds := img.Bounds()
-	imgContentWidth := imgBounds.Dx()
-	imgContentHeight := imgBounds.Dy()
-
-	// Iterate over the pixels in the relativeRect
-	for ry := relativeRect.Min.Y; ry < relativeRect.Max.Y; ry++ {
-		for rx := relativeRect.Min.X; rx < relativeRect.Max.X; rx++ {
-			// Check if the relative coordinates (rx, ry) are within the image's content dimensions
-			if rx >= 0 && rx < imgContentWidth && ry >= 0 && ry < imgContentHeight {
-				// Convert relative (rx, ry) to absolute coordinates for img.At()
-				absX := imgBounds.Min.X + rx
-				absY := imgBounds.Min.Y + ry
-
-				pixelColor := img.At(absX, absY)
-				r, g, b, _ := pixelColor.RGBA() // Returns values in [0, 0xffff] range
-
-				// Convert to 0-255 range
-				r8 := uint8(r >> 8)
-				g8 := uint8(g >> 8)
-				b8 := uint8(b >> 8)
-
-				// Calculate brightness for this pixel (simple average)
-				brightness := (float64(r8) + float64(g8) + float64(b8)) / 3.0
-				totalBrightness += brightness
-				count++
-			}
-		}
-	}
-
-	if count == 0 {
-		return 0.0 // Avoid division by zero; or handle as an error
-	}
-	return totalBrightness / float64(count)
-}
-
 // handleDetectRoadFromClipboard attempts to read an image from the clipboard
 // and then process it for road detection.
 func (g *Game) handleDetectRoadFromClipboard() {
@@ -1489,28 +1637,43 @@ func (g *Game) handleDetectRoadFromClipboard() {
 		return
 	}
 
-	// Read image data from clipboard. This expects PNG encoded bytes.
+	// Read image data from clipboard. The bytes are decoded by content (detect.Decode), so any
+	// format the detector reads works, not only PNG.
 	// clipboard.Read does not return an error itself; errors should be caught by Init().
 	imgBytes := clipboard.Read(clipboard.FmtImage)
 
 	if len(imgBytes) == 0 {
-		log.Println("Clipboard is empty or contains no image data in a recognized format (PNG), or Init failed previously.")
-		g.calculationStatus = "Error: Clipboard empty or no PNG image."
+		log.Println("Clipboard is empty or contains no image data, or Init failed previously.")
+		g.calculationStatus = "Error: Clipboard empty or no image."
 		g.updateCalculationStatus()
 		return
 	}
 
-	imgReader := bytes.NewReader(imgBytes)
-	clipboardImage, format, err := image.Decode(imgReader)
+	result, err := detect.DetectBytes(imgBytes, g.detectOptions())
 	if err != nil {
-		log.Printf("Error decoding image from clipboard: %v. Length of data: %d", err, len(imgBytes))
-		g.calculationStatus = "Error: Failed to decode clipboard image (was it PNG?)."
-		g.updateCalculationStatus()
+		log.Printf("Error detecting road from clipboard image: %v. Length of data: %d", err, len(imgBytes))
+		g.calculationStatus = fmt.Sprintf("Grid Detect Err from clipboard: %v", err)
 		return
 	}
+	g.processDetectedImage(result, "clipboard (golang.design)")
+}
 
-	log.Printf("Successfully read and decoded image from clipboard. Format: %s", format)
-	g.processDetectedImage(clipboardImage, "clipboard (golang.design)")
+// handleCaptureGameWindow grabs the Loop Hero window through X11 (see the capture package)
+// and runs the road detection on its pixels.
+func (g *Game) handleCaptureGameWindow() {
+	img, err := capture.Window(capture.Options{Title: capture.DefaultWindowTitle})
+	if err != nil {
+		log.Printf("Error capturing the game window: %v", err)
+		g.calculationStatus = fmt.Sprintf("Capture Err: %v", err)
+		return
+	}
+	result, err := detect.Detect(img, g.detectOptions())
+	if err != nil {
+		log.Printf("Error detecting road from the game window: %v", err)
+		g.calculationStatus = fmt.Sprintf("Grid Detect Err from game window: %v", err)
+		return
+	}
+	g.processDetectedImage(result, "game window")
 }
 
 // min helper function (if not already present elsewhere)
@@ -1522,6 +1685,26 @@ func min(a, b int) int {
 }
 
 func main() {
+	// Subcommands ("riverplan solve", "batch", "serve", "rpc", "tui", "detect", "capture") run without opening a window
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "solve":
+			os.Exit(runSolveCommand(os.Args[2:]))
+		case "batch":
+			os.Exit(runBatchCommand(os.Args[2:]))
+		case "serve":
+			os.Exit(runServeCommand(os.Args[2:]))
+		case "rpc":
+			os.Exit(runRPCCommand(os.Args[2:]))
+		case "tui":
+			os.Exit(runTUICommand(os.Args[2:]))
+		case "detect":
+			os.Exit(runDetectCommand(os.Args[2:]))
+		case "capture":
+			os.Exit(runCaptureCommand(os.Args[2:]))
+		}
+	}
+
 	// Set GOMAXPROCS
 	numCPU := runtime.NumCPU()
 	gomaxprocs := numCPU / 2
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"riverplan/detect"
+	"riverplan/game"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+	"golang.org/x/image/font/basicfont"
+)
+
+const (
+	// detectionSamplesDir is where accepted detections with hand corrections are saved as
+	// labelled samples, ready for "riverplan detect -check" and "riverplan detect -train".
+	detectionSamplesDir = "detection-samples"
+	// detectionDebugDir is where the debug dumps of detection runs go while they are turned on.
+	detectionDebugDir = "detection-debug"
+	// reviewOverlayAlpha is the opacity of the classification drawn over the screenshot.
+	reviewOverlayAlpha = 110
+	// confidenceStripHeight is the height of the confidence bar at the bottom of every reviewed tile.
+	confidenceStripHeight = 5
+)
+
+// reviewTileCycle is the order in which clicking a tile during the review steps through the
+// tile types. Forbidden tiles and Mountain Peaks follow from the road and the rocks, so they
+// are not part of the cycle.
+var reviewTileCycle = []game.TileType{game.Empty, game.Road, game.River, game.Forest, game.Thicket, game.Rock, game.Mountain, game.Meadow}
+
+// detectionReview is a detection run waiting for the user to check it (StateReviewingDetection).
+type detectionReview struct {
+	result    detect.Result
+	source    string                                // Where the screenshot came from, for the status text
+	image     *ebiten.Image                         // result.Cropped, created on the first Draw
+	corrected [game.GridHeight][game.GridWidth]bool // Tiles changed by hand
+	previous  GameState                             // State to return to when the review is discarded
+}
+
+// processDetectedImage shows a detection run (see the detect package) for review: the cropped
+// screenshot with the classified tiles on top. Nothing is loaded until the review is accepted.
+// NOTE: g.mu is assumed to be HELD by the caller
+func (g *Game) processDetectedImage(result detect.Result, sourceDescription string) {
+	g.review = &detectionReview{result: result, source: sourceDescription, previous: g.gameState}
+	g.gameState = StateReviewingDetection
+	g.panelPage = PageMain
+	log.Printf("Detected %d road tiles from %s (lowest tile confidence %.2f), waiting for review.", len(result.RoadTiles()), sourceDescription, result.MinConfidence())
+	g.updateButtonsForState()
+	g.updateCalculationStatus()
+}
+
+// reviewStatus returns the status text shown while a detection is reviewed.
+func (g *Game) reviewStatus() string {
+	doubtful, corrected := 0, 0
+	for y := 0; y < game.GridHeight; y++ {
+		for x := 0; x < game.GridWidth; x++ {
+			if g.review.corrected[y][x] {
+				corrected++
+			} else if g.review.result.Confidence[y][x] < detect.LowConfidence {
+				doubtful++
+			}
+		}
+	}
+	status := fmt.Sprintf("Review detection from %s.\nGrid found by %s.\n%d road tiles, %d doubtful tile(s) (red bar), %d corrected.\nLMB/RMB cycles a tile's type. Accept to load, Esc to discard. If the tiles are misaligned, calibrate the grid.",
+		g.review.source, g.review.result.CropMethod, len(g.review.result.RoadTiles()), doubtful, corrected)
+	if g.review.result.DebugDump != "" {
+		status += "\nDebug dump: " + g.review.result.DebugDump
+	}
+	return status
+}
+
+// cycleReviewTile changes tile (x, y) of the reviewed detection to the next (or previous) type
+// of reviewTileCycle, then redoes the Forbidden tiles around the road.
+// NOTE: g.mu is assumed to be HELD by the caller
+func (g *Game) cycleReviewTile(x, y int, backwards bool) {
+	if x < 0 || x >= game.GridWidth || y < 0 || y >= game.GridHeight {
+		return
+	}
+	tiles := &g.review.result.Tiles
+	current := tiles[y][x]
+	switch current {
+	case game.Forbidden:
+		current = game.Empty
+	case game.MountainPeak:
+		current = game.Rock
+	}
+	index := 0
+	for i, t := range reviewTileCycle {
+		if t == current {
+			index = i
+		}
+	}
+	if backwards {
+		index = (index + len(reviewTileCycle) - 1) % len(reviewTileCycle)
+	} else {
+		index = (index + 1) % len(reviewTileCycle)
+	}
+	tiles[y][x] = reviewTileCycle[index]
+	tiles.SetRoad(tiles.RoadTiles()) // Keeps the cards, redoes Forbidden around the road
+	g.review.corrected[y][x] = true
+	g.review.result.Confidence[y][x] = 1
+	fmt.Printf("[DEBUG] Review: tile (%d, %d) changed to %c\n", x, y, game.TileSymbol(tiles[y][x]))
+	g.updateCalculationStatus()
+}
+
+// acceptDetectionReview loads the reviewed board into the road editor. Cards already on the
+// board (rivers, forests, rocks...) are kept, so the planner works around them. If tiles were
+// corrected, the screenshot and the corrected board are saved as a labelled sample.
+// NOTE: g.mu is assumed to be HELD by the caller
+func (g *Game) acceptDetectionReview() {
+	review := g.review
+	g.review = nil
+	tiles := review.result.Tiles
+
+	detectedRoadTiles := tiles.RoadTiles()
+	detectedCards, corrections := 0, 0
+	for y := 0; y < game.GridHeight; y++ {
+		for x := 0; x < game.GridWidth; x++ {
+			if t := tiles[y][x]; t != game.Empty && t != game.Road && t != game.Forbidden {
+				detectedCards++
+			}
+			if review.corrected[y][x] {
+				corrections++
+			}
+		}
+	}
+
+	g.gameState = StatePlacingRoad
+	g.grid = tiles
+	g.roadLayoutGrid = g.grid
+	g.finalBestSolution.Grid = g.grid
+	g.finalBestSolution.Profit = -1.0
+	g.finalBestSolution.Path = nil
+	g.absoluteBestOverallSolution = game.RiverPathSolution{Grid: g.grid, Profit: -1.0, Path: nil}
+	g.validRiverStarts = nil
+	g.selectedRiverStart = game.Coordinate{}
+
+	log.Printf("Accepted %d road tiles and %d cards from %s with %d correction(s).", len(detectedRoadTiles), detectedCards, review.source, corrections)
+	g.updateButtonsForState()
+	g.updateCalculationStatus()
+	g.calculationStatus = fmt.Sprintf("Loaded %d road tiles and %d cards from %s. Finalize or edit.", len(detectedRoadTiles), detectedCards, review.source)
+
+	if corrections == 0 {
+		return
+	}
+	screenshot := review.result.Source
+	if screenshot == nil {
+		screenshot = review.result.Cropped
+	}
+	samplePath, err := detect.SaveSample(detectionSamplesDir, "sample-"+time.Now().Format("20060102-150405"), screenshot, tiles)
+	if err != nil {
+		log.Printf("Error saving detection sample: %v", err)
+		g.calculationStatus += fmt.Sprintf("\nSample not saved: %v", err)
+		return
+	}
+	log.Printf("Saved labelled detection sample %s", samplePath)
+	g.calculationStatus += fmt.Sprintf("\n%d correction(s) saved as sample %s.", corrections, samplePath)
+}
+
+// discardDetectionReview drops the reviewed detection and goes back to where the user was.
+// NOTE: g.mu is assumed to be HELD by the caller
+func (g *Game) discardDetectionReview() {
+	g.gameState = g.review.previous
+	g.review = nil
+	fmt.Println("Detection discarded.")
+	g.updateButtonsForState()
+	g.updateCalculationStatus()
+}
+
+// toggleDetectDebugDumps turns the debug dumps of detection runs on or off (see
+// detect.WriteDebugDump). Turning them on also dumps the run under review.
+// NOTE: g.mu is assumed to be HELD by the caller
+func (g *Game) toggleDetectDebugDumps() {
+	g.detectDebugDumps = !g.detectDebugDumps
+	if g.detectDebugDumps && g.review != nil && g.review.result.DebugDump == "" {
+		folder, err := detect.WriteDebugDump(detectionDebugDir, g.review.result)
+		if err != nil {
+			log.Printf("Error writing detection debug dump: %v", err)
+		} else {
+			log.Printf("Wrote detection debug dump %s", folder)
+			g.review.result.DebugDump = folder
+		}
+	}
+	g.updateButtonsForState()
+	g.updateCalculationStatus()
+}
+
+// appendReviewButtons adds the buttons shown while a detection is reviewed.
+func (g *Game) appendReviewButtons(buttonMinX, buttonMaxX int) {
+	g.buttons = append(g.buttons, Button{
+		Rect:    image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+		Text:    "Accept Detection",
+		OnClick: func(g *Game) { g.acceptDetectionReview() },
+	})
+	g.buttons = append(g.buttons, Button{
+		Rect:    image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+		Text:    "Discard Detection",
+		OnClick: func(g *Game) { g.discardDetectionReview() },
+	})
+	debugText := "Debug Dumps: OFF"
+	if g.detectDebugDumps {
+		debugText = "Debug Dumps: ON"
+	}
+	g.buttons = append(g.buttons, Button{
+		Rect:    image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+		Text:    debugText,
+		OnClick: func(g *Game) { g.toggleDetectDebugDumps() },
+	})
+	if g.review.result.Source != nil {
+		g.buttons = append(g.buttons, Button{
+			Rect:    image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+			Text:    "Calibrate Grid...",
+			OnClick: func(g *Game) { g.startGridCalibration() },
+		})
+	}
+}
+
+// confidenceColor goes from red (confidence 0) over yellow to green (confidence 1).
+func confidenceColor(confidence float64) color.Color {
+	red, green := 255.0, 255.0
+	if confidence > 0.5 {
+		red = 255 * (1 - confidence) * 2
+	} else {
+		green = 255 * confidence * 2
+	}
+	return color.RGBA{R: uint8(red), G: uint8(green), A: 255}
+}
+
+// drawDetectionReview draws the reviewed screenshot into the game area with the classified
+// tiles on top: a translucent tile colour and symbol, a confidence bar, and a white frame
+// around corrected tiles.
+// NOTE: g.mu is assumed to be HELD by the caller
+func (g *Game) drawDetectionReview(gameArea *ebiten.Image) {
+	review := g.review
+	if review.image == nil {
+		review.image = ebiten.NewImageFromImage(review.result.Cropped)
+	}
+	bounds := review.image.Bounds()
+	op := &ebiten.DrawImageOptions{}
+	op.GeoM.Scale(float64(gameAreaWidth)/float64(bounds.Dx()), float64(game.GridHeight*tileSize)/float64(bounds.Dy()))
+	op.Filter = ebiten.FilterLinear
+	gameArea.DrawImage(review.image, op)
+
+	for y := 0; y < game.GridHeight; y++ {
+		for x := 0; x < game.GridWidth; x++ {
+			tileX, tileY := float64(x*tileSize), float64(y*tileSize)
+			tileType := review.result.Tiles[y][x]
+			if tileType != game.Empty {
+				c := review.result.Tiles.TileColor(game.Coordinate{X: x, Y: y})
+				ebitenutil.DrawRect(gameArea, tileX, tileY, float64(tileSize-1), float64(tileSize-1), color.NRGBA{R: c.R, G: c.G, B: c.B, A: reviewOverlayAlpha})
+				text.Draw(gameArea, string(game.TileSymbol(tileType)), basicfont.Face7x13, x*tileSize+tileSize/2-3, y*tileSize+tileSize/2+4, color.White)
+			}
+			ebitenutil.DrawRect(gameArea, tileX, tileY+float64(tileSize-1-confidenceStripHeight), float64(tileSize-1), confidenceStripHeight, confidenceColor(review.result.Confidence[y][x]))
+			if review.corrected[y][x] {
+				size := float64(tileSize - 2)
+				ebitenutil.DrawLine(gameArea, tileX+1, tileY+1, tileX+size, tileY+1, color.White)
+				ebitenutil.DrawLine(gameArea, tileX+size, tileY+1, tileX+size, tileY+size, color.White)
+				ebitenutil.DrawLine(gameArea, tileX+size, tileY+size, tileX+1, tileY+size, color.White)
+				ebitenutil.DrawLine(gameArea, tileX+1, tileY+size, tileX+1, tileY+1, color.White)
+			}
+		}
+	}
+}
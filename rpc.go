@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"riverplan/game"
+	"sync"
+	"time"
+)
+
+// JSON-RPC 2.0 error codes used by the rpc command.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcSolverError    = -32000 // The request was valid but the game package rejected it
+)
+
+// rpcProgressInterval limits how often progress notifications are sent for one solve
+// (new best solutions are always sent).
+const rpcProgressInterval = 200 * time.Millisecond
+
+// rpcRequest is one line read from stdin. Requests without an ID are notifications and get no response.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// rpcMessage is one line written to stdout: a response (ID set) or a notification (Method set).
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  any             `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// rpcRules are the rules accepted by solve and evaluatePath; they default to the window's defaults.
+type rpcRules struct {
+	Rules game.PlanRules `json:"rules"`
+}
+
+// rpcProgress is the params of a "progress" notification.
+type rpcProgress struct {
+	ID       json.RawMessage         `json:"id"` // ID of the solve request
+	Done     int                     `json:"done"`
+	Total    int                     `json:"total"`
+	Improved bool                    `json:"improved"`
+	Best     *game.RiverPathSolution `json:"best,omitempty"` // Only sent when Improved
+}
+
+// rpcSolveResult is the result of solve.
+type rpcSolveResult struct {
+	game.RiverPathSolution
+	Complete bool `json:"complete"` // Every (start, length) search finished (false after cancel or timeout)
+}
+
+// rpcSession is the state of one rpc command: the road layout and the running solves.
+type rpcSession struct {
+	out    *json.Encoder
+	outMu  sync.Mutex // Serializes writes to out
+	mu     sync.Mutex // Guards roads and solves
+	roads  game.Grid
+	solves map[string]chan struct{} // Stop channels of running solves by request ID
+	wg     sync.WaitGroup
+
+	searchLog io.Writer // Debug output of the searches (see searchLog)
+}
+
+// send writes one message as a line of JSON.
+func (s *rpcSession) send(msg rpcMessage) {
+	msg.JSONRPC = "2.0"
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.out.Encode(msg) // Encode ends every message with a newline
+}
+
+// reply answers a request; requests without an ID (notifications) get no answer.
+func (s *rpcSession) reply(id json.RawMessage, result any, err *rpcError) {
+	if len(id) == 0 {
+		return
+	}
+	s.send(rpcMessage{ID: id, Result: result, Error: err})
+}
+
+// decodeParams decodes the params of a request into v. Missing params leave v unchanged.
+func decodeParams(params json.RawMessage, v any) *rpcError {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// handle runs one request. solve runs in the background so cancel can reach it.
+func (s *rpcSession) handle(req rpcRequest) {
+	switch req.Method {
+	case "setRoad":
+		var params struct {
+			Roads []game.Coordinate `json:"roads"`
+		}
+		if err := decodeParams(req.Params, &params); err != nil {
+			s.reply(req.ID, nil, err)
+			return
+		}
+		plan := game.PlanFile{Version: game.PlanFileVersion, Roads: params.Roads}
+		for _, road := range params.Roads { // RoadGrid does not check the coordinates itself
+			if road.X < 0 || road.X >= game.GridWidth || road.Y < 0 || road.Y >= game.GridHeight {
+				s.reply(req.ID, nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("road tile (%d, %d) is outside the grid", road.X, road.Y)})
+				return
+			}
+		}
+		s.mu.Lock()
+		s.roads = plan.RoadGrid()
+		roads := s.roads
+		s.mu.Unlock()
+		s.reply(req.ID, map[string]any{"grid": roads, "valid_starts": roads.GetValidRiverStarts()}, nil)
+
+	case "validStarts":
+		s.mu.Lock()
+		roads := s.roads
+		s.mu.Unlock()
+		starts := roads.GetValidRiverStarts()
+		if starts == nil {
+			starts = []game.Coordinate{}
+		}
+		s.reply(req.ID, starts, nil)
+
+	case "evaluatePath":
+		var params struct {
+			Path []game.Coordinate `json:"path"`
+			rpcRules
+		}
+		if err := decodeParams(req.Params, &params); err != nil {
+			s.reply(req.ID, nil, err)
+			return
+		}
+		s.mu.Lock()
+		roads := s.roads
+		s.mu.Unlock()
+		solution, err := roads.EvaluatePath(params.Path, params.Rules.DisableCrossRiverAdjacency, params.Rules.SearchOptions)
+		if err != nil {
+			s.reply(req.ID, nil, &rpcError{Code: rpcSolverError, Message: err.Error()})
+			return
+		}
+		s.reply(req.ID, solution, nil)
+
+	case "solve":
+		params := struct {
+			Starts         []game.Coordinate `json:"starts"`
+			MaxLength      int               `json:"max_length"`
+			TimeoutSeconds float64           `json:"timeout_seconds"`
+			rpcRules
+		}{}
+		if err := decodeParams(req.Params, &params); err != nil {
+			s.reply(req.ID, nil, err)
+			return
+		}
+		maxLength, err := riverMaxLength(params.MaxLength, 0)
+		if err != nil {
+			s.reply(req.ID, nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()})
+			return
+		}
+		if len(req.ID) == 0 {
+			return // A solve without an ID could never be cancelled or answered
+		}
+		stopChannel := make(chan struct{})
+		s.mu.Lock()
+		if _, running := s.solves[string(req.ID)]; running {
+			s.mu.Unlock()
+			s.reply(req.ID, nil, &rpcError{Code: rpcInvalidRequest, Message: fmt.Sprintf("a solve with id %s is already running", req.ID)})
+			return
+		}
+		s.solves[string(req.ID)] = stopChannel
+		roads := s.roads
+		s.mu.Unlock()
+
+		opts := game.SolveOptions{
+			Starts:                     params.Starts,
+			MaxLength:                  maxLength,
+			DisableCrossRiverAdjacency: params.Rules.DisableCrossRiverAdjacency,
+			Search:                     params.Rules.SearchOptions,
+		}
+		opts.Search.Log = s.searchLog
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			if params.TimeoutSeconds > 0 {
+				timer := time.AfterFunc(time.Duration(params.TimeoutSeconds*float64(time.Second)), func() { s.cancelSolve(string(req.ID)) })
+				defer timer.Stop()
+			}
+			complete := false
+			lastNotification := time.Time{}
+			best, err := game.Solve(roads, opts, func(p game.SolveProgress) {
+				complete = p.Done == p.Total
+				if !p.Improved && time.Since(lastNotification) < rpcProgressInterval && !complete {
+					return
+				}
+				lastNotification = time.Now()
+				notification := rpcProgress{ID: req.ID, Done: p.Done, Total: p.Total, Improved: p.Improved}
+				if p.Improved {
+					notification.Best = &p.Best
+				}
+				s.send(rpcMessage{Method: "progress", Params: notification})
+			}, stopChannel)
+
+			s.mu.Lock()
+			delete(s.solves, string(req.ID))
+			s.mu.Unlock()
+			if err != nil {
+				s.reply(req.ID, nil, &rpcError{Code: rpcSolverError, Message: err.Error()})
+				return
+			}
+			s.reply(req.ID, rpcSolveResult{RiverPathSolution: best, Complete: complete}, nil)
+		}()
+
+	case "cancel":
+		var params struct {
+			ID json.RawMessage `json:"id"` // Request ID of the solve to stop; omitted stops every solve
+		}
+		if err := decodeParams(req.Params, &params); err != nil {
+			s.reply(req.ID, nil, err)
+			return
+		}
+		var cancelled int
+		if len(params.ID) == 0 {
+			s.mu.Lock()
+			var ids []string
+			for id := range s.solves {
+				ids = append(ids, id)
+			}
+			s.mu.Unlock()
+			for _, id := range ids {
+				if s.cancelSolve(id) {
+					cancelled++
+				}
+			}
+		} else if s.cancelSolve(string(params.ID)) {
+			cancelled++
+		}
+		s.reply(req.ID, map[string]int{"cancelled": cancelled}, nil)
+
+	default:
+		s.reply(req.ID, nil, &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("unknown method %q", req.Method)})
+	}
+}
+
+// cancelSolve stops the running solve with the given request ID and reports whether there was one.
+// The solve still answers its request, with the best solution found so far.
+func (s *rpcSession) cancelSolve(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stopChannel, ok := s.solves[id]
+	if !ok {
+		return false
+	}
+	delete(s.solves, id) // The solve's own delete after finishing becomes a no-op
+	close(stopChannel)
+	return true
+}
+
+// serveRPC reads line-delimited JSON-RPC 2.0 requests from r until EOF and writes responses and
+// notifications to w. Running solves are waited for before it returns. Their debug output goes
+// to searchLog.
+func serveRPC(r io.Reader, w io.Writer, searchLog io.Writer) error {
+	s := &rpcSession{out: json.NewEncoder(w), roads: game.NewGrid(), solves: map[string]chan struct{}{}, searchLog: searchLog}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var req rpcRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			s.send(rpcMessage{ID: json.RawMessage("null"), Error: &rpcError{Code: rpcParseError, Message: err.Error()}})
+			continue
+		}
+		if req.JSONRPC != "2.0" || req.Method == "" {
+			id := req.ID
+			if len(id) == 0 {
+				id = json.RawMessage("null")
+			}
+			s.send(rpcMessage{ID: id, Error: &rpcError{Code: rpcInvalidRequest, Message: `expected "jsonrpc": "2.0" and a method`}})
+			continue
+		}
+		s.handle(req)
+	}
+	s.wg.Wait()
+	return scanner.Err()
+}
+
+// runRPCCommand implements "riverplan rpc": line-delimited JSON-RPC 2.0 over stdin and stdout,
+// for tools that run the planner as a child process. It returns the process exit code.
+func runRPCCommand(args []string) int {
+	fs := flag.NewFlagSet("rpc", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	verbose := fs.Bool("v", false, "print the search's debug output to stderr")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := serveRPC(os.Stdin, os.Stdout, searchLog(*verbose)); err != nil {
+		fmt.Fprintf(os.Stderr, "rpc: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"riverplan/game"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// finishedJobTTL is how long a finished job stays available after it ended.
+	finishedJobTTL = time.Hour
+	// maxFinishedJobs is how many finished jobs are kept at most; the oldest go first.
+	maxFinishedJobs = 100
+)
+
+// Job states reported by the HTTP API.
+const (
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobCancelled = "cancelled"
+	jobFailed    = "failed"
+)
+
+// jobRequest is the body of POST /jobs. Exactly one of Plan, Map and Code holds the layout;
+// its rules are used for the search. The other fields override the layout.
+type jobRequest struct {
+	Plan           *game.PlanFile    `json:"plan,omitempty"` // JSON plan file document
+	Map            string            `json:"map,omitempty"`  // ASCII map text
+	Code           string            `json:"code,omitempty"` // Layout code
+	Starts         []game.Coordinate `json:"starts,omitempty"`
+	AllStarts      bool              `json:"all_starts,omitempty"` // Search every valid start, even if the layout has one
+	MaxLength      int               `json:"max_length,omitempty"`
+	TimeoutSeconds float64           `json:"timeout_seconds,omitempty"`
+}
+
+// jobStatus is the JSON view of a job (GET /jobs/{id} and the SSE events).
+type jobStatus struct {
+	ID          string           `json:"id"`
+	State       string           `json:"state"`
+	Done        int              `json:"done"`  // (start, length) searches finished
+	Total       int              `json:"total"` // (start, length) searches in total
+	Profit      float64          `json:"profit"`
+	Score       string           `json:"score,omitempty"`
+	RiverLength int              `json:"river_length"`
+	Start       *game.Coordinate `json:"start,omitempty"`
+	Elapsed     float64          `json:"elapsed_seconds"`
+	Error       string           `json:"error,omitempty"`
+}
+
+// solveJob is one solver run started through the API.
+type solveJob struct {
+	id          string
+	plan        game.PlanFile // Layout and rules the job was started with
+	search      game.SearchOptions
+	stopChannel chan struct{}
+	stopOnce    sync.Once // cancel can be called by a client, the timeout and the job itself
+	started     time.Time
+
+	mu       sync.Mutex // Guards everything below
+	state    string
+	done     int
+	total    int
+	best     game.RiverPathSolution
+	finished time.Time
+	err      error
+	changed  chan struct{} // Closed and replaced on every update, so SSE streams can wait for the next one
+}
+
+// status returns a snapshot of the job.
+func (j *solveJob) status() jobStatus {
+	// NOTE: j.mu is assumed to be HELD by the caller
+	s := jobStatus{ID: j.id, State: j.state, Done: j.done, Total: j.total, Profit: -1.0}
+	end := time.Now()
+	if !j.finished.IsZero() {
+		end = j.finished
+	}
+	s.Elapsed = end.Sub(j.started).Seconds()
+	if j.best.Profit >= 0 && len(j.best.Path) > 0 {
+		s.Profit = j.best.Profit
+		s.Score = j.search.FormatScore(j.best)
+		s.RiverLength = len(j.best.Path)
+		start := j.best.Path[0]
+		s.Start = &start
+	}
+	if j.err != nil {
+		s.Error = j.err.Error()
+	}
+	return s
+}
+
+// notify wakes everyone waiting for the next update.
+func (j *solveJob) notify() {
+	// NOTE: j.mu is assumed to be HELD by the caller
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// cancel stops the job if it is still running.
+func (j *solveJob) cancel() {
+	j.stopOnce.Do(func() { close(j.stopChannel) })
+}
+
+// jobServer keeps the jobs of the HTTP API in memory.
+type jobServer struct {
+	slots     chan struct{} // CPU budget shared by all jobs (see game.SolveOptions.Slots)
+	searchLog io.Writer     // Debug output of the searches (see searchLog)
+
+	mu     sync.Mutex
+	jobs   map[string]*solveJob
+	order  []string // Job IDs in submission order
+	nextID int
+}
+
+// evictFinishedJobs drops the finished jobs that ended more than finishedJobTTL ago and the
+// oldest ones beyond maxFinishedJobs. Running jobs are always kept.
+// NOTE: s.mu is assumed to be HELD by the caller
+func (s *jobServer) evictFinishedJobs(now time.Time) {
+	var finished []string // Finished jobs that stay, newest first
+	evicted := map[string]bool{}
+	for i := len(s.order) - 1; i >= 0; i-- {
+		id := s.order[i]
+		j := s.jobs[id]
+		j.mu.Lock()
+		ended := j.finished
+		j.mu.Unlock()
+		switch {
+		case ended.IsZero():
+		case now.Sub(ended) > finishedJobTTL || len(finished) >= maxFinishedJobs:
+			evicted[id] = true
+		default:
+			finished = append(finished, id)
+		}
+	}
+	if len(evicted) == 0 {
+		return
+	}
+	order := s.order[:0]
+	for _, id := range s.order {
+		if evicted[id] {
+			delete(s.jobs, id)
+		} else {
+			order = append(order, id)
+		}
+	}
+	s.order = order
+	log.Printf("[Serve] Evicted %d finished job(s).", len(evicted))
+}
+
+// newJobServer returns a server whose jobs together run at most cpus searches at a time.
+func newJobServer(cpus int, searchLog io.Writer) *jobServer {
+	return &jobServer{slots: make(chan struct{}, cpus), searchLog: searchLog, jobs: map[string]*solveJob{}}
+}
+
+// isLocalOrigin reports whether origin, the Origin header of a request, names a page on this
+// machine (localhost or a loopback address). Requests without the header do not come from a
+// browser page and are allowed.
+func isLocalOrigin(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// checkOrigin answers 403 to requests from a web page on another machine, so a site open in
+// the user's browser cannot start or stop jobs.
+func checkOrigin(w http.ResponseWriter, r *http.Request) bool {
+	if !isLocalOrigin(r.Header.Get("Origin")) {
+		writeError(w, http.StatusForbidden, "requests from origin %q are not allowed", r.Header.Get("Origin"))
+		return false
+	}
+	return true
+}
+
+// routes returns the HTTP handler of the API.
+func (s *jobServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", s.handleSubmit)
+	mux.HandleFunc("GET /jobs", s.handleList)
+	mux.HandleFunc("GET /jobs/{id}", s.handleStatus)
+	mux.HandleFunc("GET /jobs/{id}/events", s.handleEvents)
+	mux.HandleFunc("POST /jobs/{id}/cancel", s.handleCancel)
+	mux.HandleFunc("DELETE /jobs/{id}", s.handleCancel)
+	mux.HandleFunc("GET /jobs/{id}/result", s.handleResult)
+	mux.HandleFunc("GET /jobs/{id}/result.png", s.handleResultPNG)
+	return mux
+}
+
+// writeJSON writes v as the JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// writeError writes {"error": message} with the given status code.
+func writeError(w http.ResponseWriter, code int, format string, args ...any) {
+	writeJSON(w, code, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+// job looks up the job named by the {id} path segment, answering 404 if there is none.
+func (s *jobServer) job(w http.ResponseWriter, r *http.Request) *solveJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[r.PathValue("id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "no job %q", r.PathValue("id"))
+		return nil
+	}
+	return j
+}
+
+// planFromRequest returns the layout of a job request with its overrides applied.
+func planFromRequest(req jobRequest) (game.PlanFile, error) {
+	var plan game.PlanFile
+	var err error
+	layouts := 0
+	if req.Plan != nil {
+		layouts++
+		plan = *req.Plan
+		if plan.Version == 0 {
+			plan.Version = game.PlanFileVersion
+		}
+		// Round-trip through the file format so the same validation as for files applies
+		var sb strings.Builder
+		if err = game.WritePlanFile(&sb, plan); err == nil {
+			plan, err = game.ReadPlanFile(strings.NewReader(sb.String()))
+		}
+	}
+	if req.Map != "" {
+		layouts++
+		plan, err = game.ReadASCIIMap(strings.NewReader(req.Map))
+	}
+	if req.Code != "" {
+		layouts++
+		plan, err = game.DecodeLayoutCode(req.Code)
+	}
+	if layouts != 1 {
+		return plan, errors.New("give exactly one of plan, map and code")
+	}
+	if err != nil {
+		return plan, err
+	}
+	plan.MaxLength, err = riverMaxLength(req.MaxLength, plan.MaxLength)
+	return plan, err
+}
+
+// handleSubmit starts a job: POST /jobs with a jobRequest body. Answers 202 with the job status.
+// The body must be sent as application/json; other types answer 415.
+func (s *jobServer) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	if !checkOrigin(w, r) {
+		return
+	}
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, "the request body must be application/json")
+		return
+	}
+	var req jobRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: %v", err)
+		return
+	}
+	plan, err := planFromRequest(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid layout: %v", err)
+		return
+	}
+	opts := game.SolveOptions{
+		Starts:                     req.Starts,
+		MaxLength:                  plan.MaxLength,
+		DisableCrossRiverAdjacency: plan.Rules.DisableCrossRiverAdjacency,
+		Search:                     plan.Rules.SearchOptions,
+		Workers:                    cap(s.slots),
+		Slots:                      s.slots,
+	}
+	opts.Search.Log = s.searchLog
+	if len(opts.Starts) == 0 && !req.AllStarts && plan.Start != nil {
+		opts.Starts = []game.Coordinate{*plan.Start}
+	}
+
+	s.mu.Lock()
+	s.evictFinishedJobs(time.Now())
+	s.nextID++
+	j := &solveJob{
+		id:          strconv.Itoa(s.nextID),
+		plan:        plan,
+		search:      opts.Search,
+		stopChannel: make(chan struct{}),
+		started:     time.Now(),
+		state:       jobRunning,
+		best:        game.RiverPathSolution{Grid: plan.RoadGrid(), Profit: -1.0},
+		changed:     make(chan struct{}),
+	}
+	s.jobs[j.id] = j
+	s.order = append(s.order, j.id)
+	s.mu.Unlock()
+
+	if req.TimeoutSeconds > 0 {
+		time.AfterFunc(time.Duration(req.TimeoutSeconds*float64(time.Second)), j.cancel)
+	}
+	log.Printf("[Serve] Job %s started: %d road tiles, max length %d, %d start(s) (0 = all)", j.id, len(plan.Roads), opts.MaxLength, len(opts.Starts))
+	go func() {
+		best, err := game.Solve(plan.RoadGrid(), opts, func(p game.SolveProgress) {
+			j.mu.Lock()
+			j.done, j.total = p.Done, p.Total
+			if p.Improved {
+				j.best = p.Best
+			}
+			j.notify()
+			j.mu.Unlock()
+		}, j.stopChannel)
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		j.finished = time.Now()
+		if best.BetterThan(j.best) {
+			j.best = best
+		}
+		select {
+		case <-j.stopChannel:
+			j.state = jobCancelled // Timeouts end up here too; the best solution so far is kept
+		default:
+			j.state = jobDone
+			if err != nil {
+				j.state = jobFailed
+				j.err = err
+			}
+		}
+		j.cancel() // A timeout firing later has nothing left to stop
+		j.notify()
+		log.Printf("[Serve] Job %s %s after %s.", j.id, j.state, j.finished.Sub(j.started).Round(time.Millisecond))
+	}()
+
+	j.mu.Lock()
+	status := j.status()
+	j.mu.Unlock()
+	w.Header().Set("Location", "/jobs/"+j.id)
+	writeJSON(w, http.StatusAccepted, status)
+}
+
+// handleList answers GET /jobs with the status of every job, oldest first.
+func (s *jobServer) handleList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.evictFinishedJobs(time.Now())
+	var jobs []*solveJob
+	for _, id := range s.order {
+		jobs = append(jobs, s.jobs[id])
+	}
+	s.mu.Unlock()
+	statuses := []jobStatus{}
+	for _, j := range jobs {
+		j.mu.Lock()
+		statuses = append(statuses, j.status())
+		j.mu.Unlock()
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// handleStatus answers GET /jobs/{id}.
+func (s *jobServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	j := s.job(w, r)
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	status := j.status()
+	j.mu.Unlock()
+	writeJSON(w, http.StatusOK, status)
+}
+
+// handleEvents streams the job status as Server-Sent Events: a "progress" event on every update
+// (updates that arrive while a client is still reading are merged), then one final "end" event.
+func (s *jobServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	j := s.job(w, r)
+	if j == nil {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		j.mu.Lock()
+		status := j.status()
+		changed := j.changed
+		j.mu.Unlock()
+
+		event := "progress"
+		if status.State != jobRunning {
+			event = "end"
+		}
+		data, _ := json.Marshal(status)
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return
+		}
+		flusher.Flush()
+		if event == "end" {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleCancel stops a job (POST /jobs/{id}/cancel or DELETE /jobs/{id}). The best solution
+// found so far stays available.
+func (s *jobServer) handleCancel(w http.ResponseWriter, r *http.Request) {
+	if !checkOrigin(w, r) {
+		return
+	}
+	j := s.job(w, r)
+	if j == nil {
+		return
+	}
+	j.cancel()
+	j.mu.Lock()
+	status := j.status()
+	j.mu.Unlock()
+	writeJSON(w, http.StatusAccepted, status)
+}
+
+// solvedJobPlan returns the job's plan with its best solution so far, answering 404 if there is none yet.
+func (s *jobServer) solvedJobPlan(w http.ResponseWriter, r *http.Request) (game.PlanFile, game.RiverPathSolution, bool) {
+	j := s.job(w, r)
+	if j == nil {
+		return game.PlanFile{}, game.RiverPathSolution{}, false
+	}
+	j.mu.Lock()
+	plan, best := j.plan, j.best
+	j.mu.Unlock()
+	if best.Profit < 0 || len(best.Path) == 0 {
+		writeError(w, http.StatusNotFound, "job %s has no solution yet", j.id)
+		return game.PlanFile{}, game.RiverPathSolution{}, false
+	}
+	solvedPlan(&plan, best)
+	return plan, best, true
+}
+
+// handleResult answers GET /jobs/{id}/result with the plan file of the best solution so far.
+func (s *jobServer) handleResult(w http.ResponseWriter, r *http.Request) {
+	plan, _, ok := s.solvedJobPlan(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	game.WritePlanFile(w, plan)
+}
+
+// handleResultPNG answers GET /jobs/{id}/result.png with the rendered best solution so far.
+// The optional "tile" query parameter sets the tile size in pixels.
+func (s *jobServer) handleResultPNG(w http.ResponseWriter, r *http.Request) {
+	plan, best, ok := s.solvedJobPlan(w, r)
+	if !ok {
+		return
+	}
+	opts := game.RenderOptions{Landscape: plan.Rules.Landscape}
+	if tile := r.URL.Query().Get("tile"); tile != "" {
+		size, err := strconv.Atoi(tile)
+		if err != nil || size < 8 || size > 128 {
+			writeError(w, http.StatusBadRequest, "tile must be a number from 8 to 128")
+			return
+		}
+		opts.TileSize = size
+	}
+	w.Header().Set("Content-Type", "image/png")
+	game.WriteSolutionPNG(w, best, opts)
+}
+
+// runServeCommand implements "riverplan serve [flags]": an HTTP JSON API for the solver on
+// localhost. It only returns on error.
+func runServeCommand(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	addr := fs.String("addr", "127.0.0.1:8090", "address to listen on")
+	cpus := fs.Int("cpus", runtime.NumCPU(), "searches running at the same time over all jobs (shared CPU budget)")
+	verbose := fs.Bool("v", false, "also print the search's debug output to stderr")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 || *cpus < 1 {
+		fs.Usage()
+		return 2
+	}
+	server := newJobServer(*cpus, searchLog(*verbose))
+	log.Printf("[Serve] Listening on http://%s (CPU budget %d)", *addr, *cpus)
+	if err := http.ListenAndServe(*addr, server.routes()); err != nil {
+		log.Printf("[Serve] %v", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,976 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"riverplan/game"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Terminal control sequences used by the terminal UI.
+const (
+	tuiEnterScreen = "\x1b[?1049h\x1b[?25l" // Alternate screen, hidden cursor
+	tuiLeaveScreen = "\x1b[0m\x1b[?25h\x1b[?1049l"
+	tuiHome        = "\x1b[H"
+	tuiResetColor  = "\x1b[0m"
+	tuiEndOfLine   = "\x1b[K\r\n" // Clears the rest of a line from the previous frame
+	tuiEndOfScreen = "\x1b[J"
+)
+
+// tuiTickInterval is how often the screen is redrawn while a calculation runs (for the timer).
+const tuiTickInterval = 250 * time.Millisecond
+
+// tuiPrompt is a line of text being typed at the bottom of the screen (e.g. a file name).
+type tuiPrompt struct {
+	label  string
+	text   string
+	submit func(m *tuiModel, text string)
+}
+
+// tuiModel is the state of the terminal UI. It follows the same states as the window (GameState)
+// and drives the same game package solver and planners.
+type tuiModel struct {
+	mu                   sync.Mutex
+	state                GameState
+	grid                 game.Grid // What is shown: the road being edited, the road layout, or a (best so far) result
+	roadLayoutGrid       game.Grid // The finalized road layout
+	cursor               game.Coordinate
+	drawing              bool // Moving the cursor lays road tiles
+	selectedStart        *game.Coordinate
+	validStarts          []game.Coordinate
+	maxLength            int
+	disableCrossRiverAdj bool
+	endPresetIndex       int
+	endTarget            *game.Coordinate
+	landscapePresetIndex int
+	objectivePresetIndex int
+	peaksNearRiver       bool
+	isPlanningLandscape  bool
+
+	best                game.RiverPathSolution // Best solution of the current or last calculation
+	solveStarts         []game.Coordinate      // Starts of the last calculation (nil means all), for recalculating
+	solveOptions        game.SearchOptions     // Options the current/last calculation was started with
+	solveMaxLength      int                    // Max length the current/last calculation was started with
+	solveDone           int
+	solveTotal          int
+	solveStartTime      time.Time
+	solveElapsed        time.Duration
+	solveComplete       bool
+	solveID             int // Incremented for every calculation; results of older ones are discarded
+	stopCalcChannel     chan struct{}
+	calculationStopping bool
+
+	message string     // One line of feedback shown under the panel
+	prompt  *tuiPrompt // Text input in progress, if any
+	redraw  chan struct{}
+	log     io.Writer // Debug output of the UI, the search and the planners (the -log file or io.Discard)
+}
+
+// newTUIModel returns a terminal UI in the road placement state with the window's default rules.
+func newTUIModel() *tuiModel {
+	return &tuiModel{
+		state:     StatePlacingRoad,
+		cursor:    game.Coordinate{X: game.GridWidth / 2, Y: game.GridHeight / 2},
+		maxLength: defaultInitialRiverLength,
+		best:      game.RiverPathSolution{Profit: -1.0},
+		redraw:    make(chan struct{}, 1),
+		log:       io.Discard,
+	}
+}
+
+// requestRedraw asks the main loop to draw the screen again. It never blocks.
+func (m *tuiModel) requestRedraw() {
+	select {
+	case m.redraw <- struct{}{}:
+	default:
+	}
+}
+
+// searchOptions builds the game.SearchOptions for the next calculation from the rule presets.
+func (m *tuiModel) searchOptions() game.SearchOptions {
+	// NOTE: m.mu is assumed to be HELD by the caller
+	opts := presetSearchOptions(m.endPresetIndex, m.endTarget, m.landscapePresetIndex, m.objectivePresetIndex)
+	opts.Log = m.log
+	return opts
+}
+
+// planFile collects the road layout, rules, start and result into a game.PlanFile,
+// like currentPlanFile does for the window.
+func (m *tuiModel) planFile() game.PlanFile {
+	// NOTE: m.mu is assumed to be HELD by the caller
+	roadGrid := m.roadLayoutGrid
+	if m.state == StatePlacingRoad {
+		roadGrid = m.grid // The road is still being edited and not finalized yet
+	}
+	plan := game.PlanFile{
+		Roads:     roadGrid.RoadTiles(),
+		Rules:     game.PlanRules{DisableCrossRiverAdjacency: m.disableCrossRiverAdj, SearchOptions: m.searchOptions()},
+		MaxLength: m.maxLength,
+	}
+	if m.selectedStart != nil {
+		start := *m.selectedStart
+		plan.Start = &start
+	}
+	if m.state == StateShowingResult && len(m.best.Path) > 0 {
+		plan.Rules.SearchOptions = m.solveOptions
+		plan.MaxLength = m.solveMaxLength
+		solvedPlan(&plan, m.best)
+	}
+	return plan
+}
+
+// applyPlan restores the terminal UI from a loaded layout, like applyPlanFile does for the window.
+func (m *tuiModel) applyPlan(plan game.PlanFile) {
+	// NOTE: m.mu is assumed to be HELD by the caller
+	m.stopCalculation()
+	m.stopCalcChannel, m.calculationStopping = nil, false
+	m.disableCrossRiverAdj = plan.Rules.DisableCrossRiverAdjacency
+	m.endPresetIndex, m.endTarget, m.landscapePresetIndex, m.objectivePresetIndex = matchRulePresets(plan.Rules)
+	if plan.MaxLength >= minRiverLength && plan.MaxLength <= maxRiverLengthCap {
+		m.maxLength = plan.MaxLength
+	}
+	m.roadLayoutGrid = plan.RoadGrid()
+	m.validStarts = m.roadLayoutGrid.GetValidRiverStarts()
+	m.selectedStart = nil
+	if plan.Start != nil {
+		start := *plan.Start
+		m.selectedStart = &start
+	}
+	m.best = game.RiverPathSolution{Grid: m.roadLayoutGrid, Profit: -1.0}
+	m.solveID++ // Results of planners still running for the old result are discarded
+	m.drawing = false
+
+	switch {
+	case plan.Solution != nil:
+		m.best = game.RiverPathSolution{Path: plan.Solution.Path, Profit: plan.Solution.Profit, Stats: plan.Solution.Stats, Grid: plan.Solution.Grid}
+		m.solveOptions = m.searchOptions()
+		m.solveMaxLength = m.maxLength
+		m.solveComplete = false
+		m.solveDone, m.solveTotal, m.solveElapsed = 0, 0, 0
+		m.grid = plan.Solution.Grid
+		m.state = StateShowingResult
+	case plan.Start != nil:
+		m.grid = m.roadLayoutGrid
+		m.state = StatePlacingRiverSource
+	default:
+		m.grid = m.roadLayoutGrid
+		m.state = StatePlacingRoad
+	}
+}
+
+// toggleRoad adds or removes a road tile at c while the road is being placed.
+func (m *tuiModel) toggleRoad(c game.Coordinate, add bool) {
+	// NOTE: m.mu is assumed to be HELD by the caller
+	roads := m.grid.RoadTiles()
+	isRoad := m.grid[c.Y][c.X] == game.Road
+	switch {
+	case isRoad && !add:
+		var remaining []game.Coordinate
+		for _, road := range roads {
+			if road != c {
+				remaining = append(remaining, road)
+			}
+		}
+		roads = remaining
+	case !isRoad && (m.grid[c.Y][c.X] == game.Empty || m.grid[c.Y][c.X] == game.Forbidden):
+		roads = append(roads, c)
+	default:
+		return
+	}
+	m.grid.SetRoad(roads)
+}
+
+// finalizeRoad stores the edited road as the road layout and moves on to picking the river start.
+func (m *tuiModel) finalizeRoad() {
+	// NOTE: m.mu is assumed to be HELD by the caller
+	m.roadLayoutGrid = m.grid
+	m.validStarts = m.roadLayoutGrid.GetValidRiverStarts()
+	m.selectedStart = nil
+	m.drawing = false
+	m.state = StatePlacingRiverSource
+	fmt.Fprintf(m.log, "[DEBUG] TUI finalized road. Number of valid river starts: %d\n", len(m.validStarts))
+	if len(m.validStarts) == 0 {
+		m.message = "The road has no valid river starts (they are empty tiles touching the road's forbidden zone)."
+	} else {
+		m.message = fmt.Sprintf("%d valid starts. Space picks one, Enter calculates it, A calculates all.", len(m.validStarts))
+	}
+}
+
+// isValidStart reports whether c is one of the valid river starts of the road layout.
+func (m *tuiModel) isValidStart(c game.Coordinate) bool {
+	// NOTE: m.mu is assumed to be HELD by the caller
+	for _, start := range m.validStarts {
+		if start == c {
+			return true
+		}
+	}
+	return false
+}
+
+// startCalculation runs game.Solve for starts (nil means every valid start) in the background.
+// Progress and the best solution so far are shown while it runs.
+func (m *tuiModel) startCalculation(starts []game.Coordinate) {
+	// NOTE: m.mu is assumed to be HELD by the caller
+	opts := game.SolveOptions{
+		Starts:                     starts,
+		MaxLength:                  m.maxLength,
+		DisableCrossRiverAdjacency: m.disableCrossRiverAdj,
+		Search:                     m.searchOptions(),
+	}
+	m.solveID++
+	solveID := m.solveID
+	stopChannel := make(chan struct{})
+	m.stopCalcChannel = stopChannel
+	m.calculationStopping = false
+	m.solveStarts = starts
+	m.solveOptions = opts.Search
+	m.solveMaxLength = opts.MaxLength
+	m.solveDone, m.solveTotal, m.solveComplete = 0, 0, false
+	m.solveStartTime = time.Now()
+	m.best = game.RiverPathSolution{Grid: m.roadLayoutGrid, Profit: -1.0}
+	m.grid = m.roadLayoutGrid
+	m.state = StateCalculating
+	m.message = ""
+	fmt.Fprintf(m.log, "[DEBUG] TUI calculation %d started: %d start(s) (0 = all), max length %d\n", solveID, len(starts), opts.MaxLength)
+
+	roads := m.roadLayoutGrid
+	go func() {
+		best, err := game.Solve(roads, opts, func(p game.SolveProgress) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if solveID != m.solveID {
+				return // Outdated calculation (reset or new layout)
+			}
+			m.solveDone, m.solveTotal = p.Done, p.Total
+			m.solveComplete = p.Done == p.Total
+			if p.Improved {
+				m.best = p.Best
+				m.grid = p.Best.Grid
+			}
+			m.requestRedraw()
+		}, stopChannel)
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if solveID != m.solveID {
+			fmt.Fprintf(m.log, "[DEBUG] TUI calculation %d finished after it was replaced. Discarding.\n", solveID)
+			return
+		}
+		m.stopCalcChannel = nil
+		m.solveElapsed = time.Since(m.solveStartTime)
+		defer m.requestRedraw()
+		if err != nil {
+			log.Printf("TUI calculation failed: %v", err)
+			m.state = StatePlacingRiverSource
+			m.grid = m.roadLayoutGrid
+			m.message = fmt.Sprintf("Error: %v", err)
+			return
+		}
+		m.best = best
+		m.grid = best.Grid
+		m.state = StateShowingResult
+		if m.solveComplete {
+			m.message = fmt.Sprintf("Done in %s.", m.solveElapsed.Round(time.Millisecond))
+		} else {
+			m.message = fmt.Sprintf("Stopped after %s; showing the best solution so far.", m.solveElapsed.Round(time.Millisecond))
+		}
+	}()
+}
+
+// stopCalculation signals a running calculation to stop. It keeps its best solution so far.
+func (m *tuiModel) stopCalculation() {
+	// NOTE: m.mu is assumed to be HELD by the caller
+	if m.stopCalcChannel != nil && !m.calculationStopping {
+		close(m.stopCalcChannel)
+		m.calculationStopping = true
+		fmt.Fprintln(m.log, "[DEBUG] TUI calculation stop signal sent.")
+	}
+}
+
+// backToStartSelection leaves the result and shows the road layout again.
+func (m *tuiModel) backToStartSelection() {
+	// NOTE: m.mu is assumed to be HELD by the caller
+	m.solveID++ // Planners still running for the result are discarded
+	m.state = StatePlacingRiverSource
+	m.grid = m.roadLayoutGrid
+	m.best = game.RiverPathSolution{Grid: m.roadLayoutGrid, Profit: -1.0}
+	m.message = ""
+}
+
+// reset returns to an empty road with the default rules, like the window's full reset.
+func (m *tuiModel) reset() {
+	// NOTE: m.mu is assumed to be HELD by the caller
+	m.stopCalculation()
+	m.stopCalcChannel, m.calculationStopping = nil, false
+	m.solveID++ // The stopped calculation and running planners are discarded
+	m.state = StatePlacingRoad
+	m.grid, m.roadLayoutGrid = game.NewGrid(), game.NewGrid()
+	m.drawing, m.selectedStart, m.validStarts = false, nil, nil
+	m.maxLength, m.disableCrossRiverAdj = defaultInitialRiverLength, false
+	m.endPresetIndex, m.endTarget, m.landscapePresetIndex, m.objectivePresetIndex = 0, nil, 0, 0
+	m.peaksNearRiver = false
+	m.best, m.solveStarts = game.RiverPathSolution{Profit: -1.0}, nil
+	m.message = "Reset."
+}
+
+// planMountainPeaks runs the mountain peak planner on the result in the background,
+// like handlePlanMountainPeaks does for the window.
+func (m *tuiModel) planMountainPeaks() {
+	// NOTE: m.mu is assumed to be HELD by the caller
+	if m.isPlanningLandscape || len(m.best.Path) == 0 {
+		return
+	}
+	m.isPlanningLandscape = true
+	m.message = "Planning mountain peaks..."
+
+	baseGrid := m.best.Grid.WithoutLandscapes()
+	riverPath := m.best.Path
+	landscape := m.solveOptions.Landscape
+	opts := game.PeakPlanOptions{Rocks: peakPlanRockCards, Mountains: peakPlanMountainCards, NearRiver: m.peaksNearRiver, Log: m.log}
+	planSolveID := m.solveID
+
+	go func() {
+		plan, err := baseGrid.PlanMountainPeaks(opts, nil, nil)
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		defer m.requestRedraw()
+		m.isPlanningLandscape = false
+		if m.state != StateShowingResult || planSolveID != m.solveID {
+			fmt.Fprintln(m.log, "[DEBUG] TUI mountain peak plan finished for an outdated result. Discarding.")
+			return
+		}
+		if err != nil {
+			log.Printf("Error planning mountain peaks: %v", err)
+			m.message = fmt.Sprintf("Peak Plan Err: %v", err)
+			return
+		}
+		profit, stats := plan.Grid.PlaceLandscapes(riverPath, landscape)
+		m.best.Grid = plan.Grid
+		m.best.Profit = profit
+		m.best.Stats = stats
+		m.grid = plan.Grid
+		m.message = fmt.Sprintf("Peaks: %d (HP +%.2f%%)", len(plan.Peaks), plan.HP*100)
+	}()
+}
+
+// placeMeadows runs the meadow planner on the result, like handlePlaceMeadows does for the window.
+func (m *tuiModel) placeMeadows() {
+	// NOTE: m.mu is assumed to be HELD by the caller
+	if m.isPlanningLandscape || len(m.best.Path) == 0 {
+		return
+	}
+	plan, err := m.best.Grid.PlanMeadows(meadowPlanCards, m.log, nil, nil)
+	if err != nil {
+		log.Printf("Error placing meadows: %v", err)
+		m.message = fmt.Sprintf("Meadow Plan Err: %v", err)
+		return
+	}
+	m.best.Grid = plan.Grid
+	m.best.Stats = plan.Grid.TotalStats()
+	m.best.Profit = m.solveOptions.Landscape.Profit(m.best.Stats)
+	m.grid = plan.Grid
+	m.message = fmt.Sprintf("Meadows: %d (%d blooming)", len(plan.Meadows), plan.Blooming)
+}
+
+// askPath opens a prompt for a file name; submit runs with the typed text.
+func (m *tuiModel) askPath(label string, submit func(m *tuiModel, path string)) {
+	// NOTE: m.mu is assumed to be HELD by the caller
+	m.prompt = &tuiPrompt{label: label, submit: func(m *tuiModel, text string) {
+		path := strings.TrimSpace(text)
+		if path == "" {
+			m.message = "Cancelled."
+			return
+		}
+		submit(m, path)
+	}}
+}
+
+// loadFile loads a JSON plan, ASCII map or screenshot (see loadLayout).
+func (m *tuiModel) loadFile(path string) {
+	// NOTE: m.mu is assumed to be HELD by the caller
+	plan, ignoredCards, err := loadLayout(path)
+	if err != nil {
+		log.Printf("Error loading '%s': %v", path, err)
+		m.message = fmt.Sprintf("Load Err: %v", err)
+		return
+	}
+	m.applyPlan(plan)
+	log.Printf("Loaded %s (%d road tiles, %d detected cards ignored, solution: %t)", path, len(plan.Roads), ignoredCards, plan.Solution != nil)
+	m.message = loadedMessage(path, plan, ignoredCards)
+}
+
+// loadedMessage is the status line after loading path (see loadLayout).
+func loadedMessage(path string, plan game.PlanFile, ignoredCards int) string {
+	if ignoredCards > 0 {
+		return fmt.Sprintf("Loaded %s (%d road tiles; %d detected cards ignored, only the road is kept).", filepath.Base(path), len(plan.Roads), ignoredCards)
+	}
+	return fmt.Sprintf("Loaded %s (%d road tiles).", filepath.Base(path), len(plan.Roads))
+}
+
+// saveFile saves the plan as JSON or, with asciiMap, as an ASCII map.
+func (m *tuiModel) saveFile(path string, asciiMap bool) {
+	// NOTE: m.mu is assumed to be HELD by the caller
+	save, extension := game.SavePlanFile, ".json"
+	if asciiMap {
+		save, extension = game.SaveASCIIMap, ".txt"
+	}
+	if filepath.Ext(path) == "" {
+		path += extension
+	}
+	if err := save(path, m.planFile()); err != nil {
+		log.Printf("Error saving '%s': %v", path, err)
+		m.message = fmt.Sprintf("Error: Failed to save %s: %v", filepath.Base(path), err)
+		return
+	}
+	log.Printf("Saved %s", path)
+	m.message = fmt.Sprintf("Saved %s", path)
+}
+
+// exportImage renders the result (or the road layout) to a PNG, SVG or GIF file.
+func (m *tuiModel) exportImage(path string) {
+	// NOTE: m.mu is assumed to be HELD by the caller
+	if filepath.Ext(path) == "" {
+		path += ".png"
+	}
+	sol := game.RiverPathSolution{Grid: m.grid, Profit: -1.0}
+	landscape := landscapePresets[m.landscapePresetIndex].Options
+	if m.state == StateShowingResult {
+		sol = m.best
+		landscape = m.solveOptions.Landscape
+	}
+	if err := saveSolutionImage(path, sol, landscape); err != nil {
+		log.Printf("Error exporting '%s': %v", path, err)
+		m.message = fmt.Sprintf("Error: %v", err)
+		return
+	}
+	log.Printf("Exported %s", path)
+	m.message = fmt.Sprintf("Exported %s", path)
+}
+
+// handleKey applies one key press and reports whether the UI should quit.
+func (m *tuiModel) handleKey(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.prompt != nil {
+		switch key {
+		case "enter":
+			prompt := m.prompt
+			m.prompt = nil
+			prompt.submit(m, prompt.text)
+		case "esc", "ctrl-c":
+			m.prompt = nil
+			m.message = "Cancelled."
+		case "backspace":
+			if len(m.prompt.text) > 0 {
+				m.prompt.text = m.prompt.text[:len(m.prompt.text)-1]
+			}
+		default:
+			if len(key) == 1 {
+				m.prompt.text += key
+			}
+		}
+		return false
+	}
+
+	// Keys that work in every state
+	switch key {
+	case "q", "ctrl-c":
+		m.stopCalculation()
+		return true
+	case "up", "k":
+		m.moveCursor(0, -1)
+		return false
+	case "down", "j":
+		m.moveCursor(0, 1)
+		return false
+	case "left", "h":
+		m.moveCursor(-1, 0)
+		return false
+	case "right", "l":
+		m.moveCursor(1, 0)
+		return false
+	case "R":
+		m.reset()
+		return false
+	}
+
+	if m.state == StateCalculating {
+		if key == "esc" || key == "x" {
+			m.stopCalculation()
+			m.message = "Stopping calculation..."
+		}
+		return false
+	}
+
+	// Rules and files (every state except calculating)
+	switch key {
+	case "+", "=", "pgup":
+		if m.maxLength < maxRiverLengthCap {
+			m.maxLength++
+		}
+		return false
+	case "-", "pgdn":
+		if m.maxLength > minRiverLength {
+			m.maxLength--
+		}
+		return false
+	case "c":
+		m.disableCrossRiverAdj = !m.disableCrossRiverAdj
+		return false
+	case "n":
+		m.endPresetIndex = (m.endPresetIndex + 1) % len(endConstraintPresets)
+		if endConstraintPresets[m.endPresetIndex].UseTarget && m.endTarget == nil {
+			m.message = "Set the end target with t while picking the start."
+		}
+		return false
+	case "L":
+		m.landscapePresetIndex = (m.landscapePresetIndex + 1) % len(landscapePresets)
+		return false
+	case "g":
+		m.objectivePresetIndex = (m.objectivePresetIndex + 1) % len(objectivePresets)
+		return false
+	case "P":
+		m.peaksNearRiver = !m.peaksNearRiver
+		return false
+	case "o":
+		m.askPath("Load (plan .json, map .txt or screenshot)", (*tuiModel).loadFile)
+		return false
+	case "w":
+		m.askPath("Save plan as (.json)", func(m *tuiModel, path string) { m.saveFile(path, false) })
+		return false
+	case "W":
+		m.askPath("Save ASCII map as (.txt)", func(m *tuiModel, path string) { m.saveFile(path, true) })
+		return false
+	case "i":
+		m.askPath("Export image as (.png, .svg, .gif)", (*tuiModel).exportImage)
+		return false
+	case "y":
+		code, err := game.EncodeLayoutCode(m.planFile())
+		if err != nil {
+			m.message = fmt.Sprintf("Code Err: %v", err)
+		} else {
+			m.message = "Layout code: " + code
+		}
+		return false
+	case "Y":
+		m.prompt = &tuiPrompt{label: "Layout code", submit: func(m *tuiModel, text string) {
+			plan, err := game.DecodeLayoutCode(strings.TrimSpace(text))
+			if err != nil {
+				m.message = fmt.Sprintf("Code Err: %v", err)
+				return
+			}
+			m.applyPlan(plan)
+			m.message = fmt.Sprintf("Layout code loaded (%d road tiles).", len(plan.Roads))
+		}}
+		return false
+	}
+
+	switch m.state {
+	case StatePlacingRoad:
+		switch key {
+		case " ":
+			m.toggleRoad(m.cursor, false)
+		case "d":
+			m.drawing = !m.drawing
+			if m.drawing {
+				m.toggleRoad(m.cursor, true)
+			}
+		case "enter":
+			m.finalizeRoad()
+		}
+
+	case StatePlacingRiverSource:
+		switch key {
+		case " ":
+			if !m.isValidStart(m.cursor) {
+				m.message = fmt.Sprintf("(%d, %d) is not a valid river start (Tab jumps to one).", m.cursor.X, m.cursor.Y)
+				break
+			}
+			start := m.cursor
+			m.selectedStart = &start
+			m.message = ""
+		case "tab":
+			if len(m.validStarts) > 0 {
+				next := 0
+				for i, start := range m.validStarts {
+					if start == m.cursor {
+						next = (i + 1) % len(m.validStarts)
+					}
+				}
+				m.cursor = m.validStarts[next]
+			}
+		case "t":
+			if m.roadLayoutGrid[m.cursor.Y][m.cursor.X] != game.Empty {
+				m.message = "The river end target must be an empty tile."
+				break
+			}
+			target := m.cursor
+			m.endTarget = &target
+			m.endPresetIndex = targetEndPresetIndex
+		case "enter":
+			if m.selectedStart == nil {
+				m.message = "Pick a start with Space first, or press A to calculate every start."
+				break
+			}
+			m.startCalculation([]game.Coordinate{*m.selectedStart})
+		case "A":
+			m.startCalculation(nil)
+		case "esc", "x":
+			m.state = StatePlacingRoad
+			m.grid = m.roadLayoutGrid
+			m.selectedStart = nil
+			m.validStarts = nil
+			m.message = ""
+		}
+
+	case StateShowingResult:
+		switch key {
+		case "enter":
+			m.startCalculation(m.solveStarts)
+		case "p":
+			m.planMountainPeaks()
+		case "m":
+			m.placeMeadows()
+		case "esc", "x":
+			m.backToStartSelection()
+		}
+	}
+	return false
+}
+
+// moveCursor moves the cursor, laying road on the way while drawing.
+func (m *tuiModel) moveCursor(dx, dy int) {
+	// NOTE: m.mu is assumed to be HELD by the caller
+	next := game.Coordinate{X: m.cursor.X + dx, Y: m.cursor.Y + dy}
+	if next.X < 0 || next.X >= game.GridWidth || next.Y < 0 || next.Y >= game.GridHeight {
+		return
+	}
+	m.cursor = next
+	if m.drawing && m.state == StatePlacingRoad {
+		m.toggleRoad(m.cursor, true)
+	}
+}
+
+// render draws the whole screen: the grid on the left, the panel on the right and the
+// message or prompt line at the bottom.
+func (m *tuiModel) render() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var left []string
+	frame := "+" + strings.Repeat("-", game.GridWidth*2) + "+"
+	left = append(left, frame)
+	for y := 0; y < game.GridHeight; y++ {
+		var row strings.Builder
+		row.WriteString("|")
+		for x := 0; x < game.GridWidth; x++ {
+			row.WriteString(m.renderCell(game.Coordinate{X: x, Y: y}))
+		}
+		row.WriteString(tuiResetColor + "|")
+		left = append(left, row.String())
+	}
+	left = append(left, frame)
+	gridWidth := len(frame)
+
+	panel := m.panelLines()
+	var b strings.Builder
+	b.WriteString(tuiHome)
+	for i := 0; i < len(left) || i < len(panel); i++ {
+		if i < len(left) {
+			b.WriteString(left[i])
+		} else {
+			b.WriteString(strings.Repeat(" ", gridWidth))
+		}
+		if i < len(panel) {
+			b.WriteString("  " + panel[i])
+		}
+		b.WriteString(tuiEndOfLine)
+	}
+	b.WriteString(tuiEndOfLine)
+	if m.prompt != nil {
+		b.WriteString(fmt.Sprintf("%s: %s_  (Enter: OK, Esc: cancel)", m.prompt.label, m.prompt.text))
+	} else {
+		b.WriteString(m.message)
+	}
+	b.WriteString(tuiEndOfLine + tuiEndOfScreen)
+	return b.String()
+}
+
+// renderCell returns the two terminal columns of one tile: the tile colour as background and
+// its plan file symbol, or a marker for the cursor, start, end target and valid starts.
+func (m *tuiModel) renderCell(c game.Coordinate) string {
+	// NOTE: m.mu is assumed to be HELD by the caller
+	background := m.grid.TileColor(c)
+	text := string(game.TileSymbol(m.grid[c.Y][c.X])) + " "
+	switch {
+	case c == m.cursor:
+		text = "[]"
+	case m.selectedStart != nil && c == *m.selectedStart && m.state != StatePlacingRoad:
+		text = "S "
+	case m.state == StateShowingResult && len(m.best.Path) > 0 && c == m.best.Path[0]:
+		text = "S~"
+	case m.endTarget != nil && c == *m.endTarget && endConstraintPresets[m.endPresetIndex].UseTarget && m.state != StatePlacingRoad:
+		text = "E "
+	case m.state == StatePlacingRiverSource && m.isValidStart(c):
+		text = "::"
+	}
+	// Dark text on light tiles, light text on dark ones
+	foreground := "255;255;255"
+	if 299*int(background.R)+587*int(background.G)+114*int(background.B) > 140*1000 {
+		foreground = "0;0;0"
+	}
+	return fmt.Sprintf("\x1b[48;2;%d;%d;%dm\x1b[38;2;%sm%s", background.R, background.G, background.B, foreground, text)
+}
+
+// panelLines returns the side panel: state, rules, progress or result, and the keys of the current state.
+func (m *tuiModel) panelLines() []string {
+	// NOTE: m.mu is assumed to be HELD by the caller
+	lines := []string{"River Plan Optimizer (terminal)", ""}
+	switch m.state {
+	case StatePlacingRoad:
+		mode := ""
+		if m.drawing {
+			mode = " [drawing]"
+		}
+		lines = append(lines, "State: placing road"+mode, fmt.Sprintf("Road tiles: %d", len(m.grid.RoadTiles())))
+	case StatePlacingRiverSource:
+		start := "none (Space on a :: tile)"
+		if m.selectedStart != nil {
+			start = fmt.Sprintf("(%d, %d)", m.selectedStart.X, m.selectedStart.Y)
+		}
+		lines = append(lines, "State: picking the river start", fmt.Sprintf("Valid starts: %d, selected: %s", len(m.validStarts), start))
+	case StateCalculating:
+		scan := "all starts"
+		if len(m.solveStarts) > 0 {
+			scan = fmt.Sprintf("start (%d, %d)", m.solveStarts[0].X, m.solveStarts[0].Y)
+		}
+		status := "State: calculating " + scan
+		if m.calculationStopping {
+			status += " (stopping)"
+		}
+		lines = append(lines, status, fmt.Sprintf("Progress: %d/%d searches, %.1fs", m.solveDone, m.solveTotal, time.Since(m.solveStartTime).Seconds()))
+		if m.best.Profit >= 0 && len(m.best.Path) > 0 {
+			lines = append(lines, fmt.Sprintf("Best so far: %.2f%%, river %d from (%d, %d)", m.best.Profit*100, len(m.best.Path), m.best.Path[0].X, m.best.Path[0].Y))
+		} else {
+			lines = append(lines, "Best so far: none yet")
+		}
+	case StateShowingResult:
+		complete := "search stopped early"
+		if m.solveComplete {
+			complete = "search complete"
+		}
+		lines = append(lines, "State: result ("+complete+")")
+		if m.best.Profit >= 0 {
+			lines = append(lines,
+				fmt.Sprintf("Profit: %.2f%%, river %d tiles, %d cards", m.best.Profit*100, len(m.best.Path), m.best.Cards),
+				"Stats: "+m.best.Stats.String())
+			if m.solveOptions.Objective != game.ObjectiveProfit {
+				lines = append(lines, "Score: "+m.solveOptions.FormatScore(m.best))
+			}
+		}
+	}
+	if m.isPlanningLandscape {
+		lines = append(lines, "Planning landscapes...")
+	}
+
+	cross := "allowed"
+	if m.disableCrossRiverAdj {
+		cross = "forbidden"
+	}
+	peaks := "anywhere"
+	if m.peaksNearRiver {
+		peaks = "near river"
+	}
+	lines = append(lines, "",
+		fmt.Sprintf("Cursor: (%d, %d)", m.cursor.X, m.cursor.Y),
+		fmt.Sprintf("Max length: %d (+/-, %d-%d)", m.maxLength, minRiverLength, maxRiverLengthCap),
+		"Cross adjacency: "+cross+" (c)",
+		"End: "+endConstraintPresets[m.endPresetIndex].Label+" (n) = "+m.searchOptions().End.String(),
+		"Land: "+landscapePresets[m.landscapePresetIndex].Label+" (L)",
+		"Goal: "+objectivePresets[m.objectivePresetIndex].Label+" (g)",
+		"Peaks: "+peaks+" (P)",
+		"")
+
+	switch m.state {
+	case StatePlacingRoad:
+		lines = append(lines, "Arrows/hjkl move, Space toggles road,", "d draw mode, Enter finalize the road")
+	case StatePlacingRiverSource:
+		lines = append(lines, "Space pick start, Tab next start, t end target,", "Enter calculate selected, A calculate all, Esc back")
+	case StateCalculating:
+		lines = append(lines, "Esc/x stop (keeps the best so far)")
+	case StateShowingResult:
+		lines = append(lines, "Enter recalculate, p plan peaks, m meadows,", "Esc back to start selection")
+	}
+	if m.state != StateCalculating {
+		lines = append(lines, "o load, w save plan, W save map, i image,", "y show / Y enter layout code")
+	}
+	lines = append(lines, "R reset, q quit")
+	return lines
+}
+
+// parseTUIKeys splits raw terminal input into key names: printable characters as themselves,
+// and "up", "down", "left", "right", "pgup", "pgdn", "enter", "tab", "backspace", "esc", "ctrl-c".
+// Unknown escape sequences are dropped.
+func parseTUIKeys(data []byte) []string {
+	var keys []string
+	for i := 0; i < len(data); {
+		b := data[i]
+		switch {
+		case b == 0x1b:
+			if i+2 < len(data) && (data[i+1] == '[' || data[i+1] == 'O') {
+				j := i + 2
+				for j < len(data) && (data[j] >= '0' && data[j] <= '9' || data[j] == ';') {
+					j++
+				}
+				if j < len(data) {
+					if key := csiKeyName(string(data[i+2:j]), data[j]); key != "" {
+						keys = append(keys, key)
+					}
+					i = j + 1
+					continue
+				}
+			}
+			keys = append(keys, "esc")
+		case b == '\r' || b == '\n':
+			keys = append(keys, "enter")
+		case b == '\t':
+			keys = append(keys, "tab")
+		case b == 0x7f || b == 0x08:
+			keys = append(keys, "backspace")
+		case b == 0x03:
+			keys = append(keys, "ctrl-c")
+		case b >= 0x20 && b < 0x7f:
+			keys = append(keys, string(b))
+		}
+		i++
+	}
+	return keys
+}
+
+// csiKeyName names the key of an escape sequence "ESC [ params final", or returns "".
+func csiKeyName(params string, final byte) string {
+	switch final {
+	case 'A':
+		return "up"
+	case 'B':
+		return "down"
+	case 'C':
+		return "right"
+	case 'D':
+		return "left"
+	case '~':
+		switch params {
+		case "5":
+			return "pgup"
+		case "6":
+			return "pgdn"
+		}
+	}
+	return ""
+}
+
+// readTUIKeys sends the keys read from r to keys until r fails, then closes keys.
+func readTUIKeys(r io.Reader, keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		for _, key := range parseTUIKeys(buf[:n]) {
+			keys <- key
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// runTUICommand implements "riverplan tui [flags] [layout]": a full-screen terminal version of
+// the window for SSH sessions and machines without a display. It returns the process exit code.
+func runTUICommand(args []string) int {
+	stdout, stderr := os.Stdout, os.Stderr
+
+	fs := flag.NewFlagSet("tui", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: riverplan tui [flags] [layout.json | layout.txt | screenshot.png]")
+		fmt.Fprintln(stderr, "Needs a terminal with 24-bit colour of at least 100x32 characters.")
+		fs.PrintDefaults()
+	}
+	logPath := fs.String("log", "", "write the debug output of the search and the UI to this file")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+
+	m := newTUIModel()
+	if fs.NArg() == 1 {
+		plan, ignoredCards, err := loadLayout(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintf(stderr, "tui: %v\n", err)
+			return 1
+		}
+		m.applyPlan(plan)
+		m.message = loadedMessage(fs.Arg(0), plan, ignoredCards)
+	}
+
+	// Debug output would scribble over the screen, so it goes to the -log file or nowhere (see tuiModel.log)
+	if *logPath != "" {
+		logFile, err := os.OpenFile(*logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			fmt.Fprintf(stderr, "tui: %v\n", err)
+			return 1
+		}
+		defer logFile.Close()
+		m.log = logFile
+	}
+	log.SetOutput(m.log)
+	defer log.SetOutput(stderr)
+
+	restore, err := enableRawTerminal()
+	if err != nil {
+		fmt.Fprintf(stderr, "tui: %v\n", err)
+		return 1
+	}
+	defer restore()
+	fmt.Fprint(stdout, tuiEnterScreen)
+	defer fmt.Fprint(stdout, tuiLeaveScreen)
+
+	keys := make(chan string, 64)
+	go readTUIKeys(os.Stdin, keys)
+	ticker := time.NewTicker(tuiTickInterval)
+	defer ticker.Stop()
+
+	for redraw := true; ; {
+		if redraw {
+			io.WriteString(stdout, m.render())
+		}
+		select {
+		case key, ok := <-keys:
+			if !ok || m.handleKey(key) {
+				m.mu.Lock()
+				m.stopCalculation()
+				m.mu.Unlock()
+				return 0
+			}
+			redraw = true
+		case <-m.redraw:
+			redraw = true
+		case <-ticker.C:
+			// Only the calculation timer changes on its own
+			m.mu.Lock()
+			redraw = m.state == StateCalculating
+			m.mu.Unlock()
+		}
+	}
+}
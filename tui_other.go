@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import "errors"
+
+// enableRawTerminal is not implemented on this platform.
+func enableRawTerminal() (func(), error) {
+	return nil, errors.New("the terminal UI is not supported on this platform")
+}
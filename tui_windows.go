@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// enableRawTerminal switches the console to raw input with VT escape sequences (Windows 10 and
+// later) for the terminal UI and returns a function that restores the old modes.
+func enableRawTerminal() (func(), error) {
+	in, out := windows.Handle(os.Stdin.Fd()), windows.Handle(os.Stdout.Fd())
+	var inMode, outMode uint32
+	if err := windows.GetConsoleMode(in, &inMode); err != nil {
+		return nil, fmt.Errorf("stdin is not a console: %w", err)
+	}
+	if err := windows.GetConsoleMode(out, &outMode); err != nil {
+		return nil, fmt.Errorf("stdout is not a console: %w", err)
+	}
+	rawIn := inMode&^(windows.ENABLE_ECHO_INPUT|windows.ENABLE_LINE_INPUT|windows.ENABLE_PROCESSED_INPUT) | windows.ENABLE_VIRTUAL_TERMINAL_INPUT
+	if err := windows.SetConsoleMode(in, rawIn); err != nil {
+		return nil, fmt.Errorf("failed to switch the console to raw input: %w", err)
+	}
+	if err := windows.SetConsoleMode(out, outMode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING); err != nil {
+		windows.SetConsoleMode(in, inMode)
+		return nil, fmt.Errorf("the console does not support escape sequences: %w", err)
+	}
+	return func() {
+		windows.SetConsoleMode(in, inMode)
+		windows.SetConsoleMode(out, outMode)
+	}, nil
+}
@@ -14,8 +14,8 @@ import (
 // UI Button constants
 const (
 	panelWidth    = 240 // Increased for more space
-	buttonHeight  = 30
-	buttonMargin  = 10
+	buttonHeight  = 26
+	buttonMargin  = 8
 	buttonPadding = 5
 	textOffsetY   = 5 // Small offset for text within buttons
 )
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"riverplan/detect"
+	"time"
+
+	"github.com/sqweek/dialog"
+)
+
+const (
+	// screenshotWatchFile is where the screenshot watcher settings are stored between runs.
+	screenshotWatchFile = "screenshot-watch.json"
+	// screenshotWatchInterval is how often the watched folder is scanned for new screenshots.
+	screenshotWatchInterval = time.Second
+)
+
+// WatchMode is what the screenshot watcher does with a new screenshot.
+type WatchMode int
+
+const (
+	WatchOff           WatchMode = iota // No watching
+	WatchImport                         // Detect the road and open the review
+	WatchAutoCalculate                  // Detect, load and calculate all starts (doubtful detections still open the review)
+)
+
+// watchModeLabels are the button texts of the watch modes, in WatchMode order.
+var watchModeLabels = []string{"Watch Folder: OFF", "Watch Folder: Import", "Watch Folder: Calculate"}
+
+// screenshotWatchSettings is the content of screenshotWatchFile.
+type screenshotWatchSettings struct {
+	Dir  string    `json:"dir"`
+	Mode WatchMode `json:"mode"` // 0 off, 1 import, 2 import and calculate
+}
+
+// loadScreenshotWatchSettings reads the watcher settings. A missing file means no watching;
+// other problems are logged and also give no watching.
+func loadScreenshotWatchSettings() screenshotWatchSettings {
+	var settings screenshotWatchSettings
+	data, err := os.ReadFile(screenshotWatchFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Error loading screenshot watch settings: %v", err)
+		}
+		return settings
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		log.Printf("Error loading screenshot watch settings: %v", err)
+		return screenshotWatchSettings{}
+	}
+	if settings.Mode < WatchOff || settings.Mode > WatchAutoCalculate {
+		settings.Mode = WatchOff
+	}
+	return settings
+}
+
+// saveScreenshotWatchSettings stores the watcher settings.
+func saveScreenshotWatchSettings(settings screenshotWatchSettings) error {
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(screenshotWatchFile, append(data, '\n'), 0644)
+}
+
+// fileStamp identifies one version of a file.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// screenshotWatcher scans a folder for new screenshots. A file counts as new once it was not
+// there (or was different) when the watcher started, and it has stopped changing between two
+// scans, so a screenshot the game is still writing is not read half-way.
+type screenshotWatcher struct {
+	dir     string
+	seen    map[string]fileStamp // Versions from the previous scan
+	handled map[string]fileStamp // Versions that were there at the start or were already imported
+	stop    chan struct{}
+}
+
+// newScreenshotWatcher starts watching dir. The screenshots already in it are not imported.
+func newScreenshotWatcher(dir string) (*screenshotWatcher, error) {
+	w := &screenshotWatcher{dir: dir, handled: map[string]fileStamp{}, stop: make(chan struct{})}
+	stamps, err := w.scan()
+	if err != nil {
+		return nil, err
+	}
+	for name, stamp := range stamps {
+		w.handled[name] = stamp
+	}
+	w.seen = stamps
+	return w, nil
+}
+
+// scan returns the screenshots in the folder.
+func (w *screenshotWatcher) scan() (map[string]fileStamp, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+	stamps := map[string]fileStamp{}
+	for _, entry := range entries {
+		if entry.IsDir() || !isScreenshotFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // Removed since ReadDir
+		}
+		stamps[entry.Name()] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+	}
+	return stamps, nil
+}
+
+// newScreenshots returns the new screenshots that stopped changing since the previous scan.
+func (w *screenshotWatcher) newScreenshots() (map[string]fileStamp, error) {
+	stamps, err := w.scan()
+	if err != nil {
+		return nil, err
+	}
+	settled := map[string]fileStamp{}
+	for name, stamp := range stamps {
+		if stamp != w.handled[name] && stamp == w.seen[name] && stamp.size > 0 {
+			settled[name] = stamp
+		}
+	}
+	w.seen = stamps
+	return settled, nil
+}
+
+// canImportScreenshot reports whether a watched screenshot may replace what the window shows
+// now: not during a calculation, a review, a calibration or a landscape planner run.
+// NOTE: g.mu is assumed to be HELD by the caller
+func (g *Game) canImportScreenshot() bool {
+	switch g.gameState {
+	case StatePlacingRoad, StatePlacingRiverSource, StateShowingResult:
+		return !g.isPlanningLandscape
+	}
+	return false
+}
+
+// runScreenshotWatcher scans the watched folder until the watcher is stopped. When new
+// screenshots appear, the newest is detected and imported (see importWatchedScreenshot); the
+// others are skipped. While the window is busy, new screenshots wait for the next scan.
+func (g *Game) runScreenshotWatcher(w *screenshotWatcher) {
+	ticker := time.NewTicker(screenshotWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+
+		g.mu.Lock()
+		busy := !g.canImportScreenshot()
+		opts := g.detectOptions()
+		g.mu.Unlock()
+		if busy {
+			continue
+		}
+
+		screenshots, err := w.newScreenshots()
+		if err != nil {
+			log.Printf("Error scanning screenshot folder %s: %v", w.dir, err)
+			continue
+		}
+		newest := ""
+		for name, stamp := range screenshots {
+			w.handled[name] = stamp
+			if newest == "" || stamp.modTime.After(screenshots[newest].modTime) {
+				newest = name
+			}
+		}
+		if newest == "" {
+			continue
+		}
+		if len(screenshots) > 1 {
+			log.Printf("%d new screenshots in %s, importing only the newest (%s).", len(screenshots), w.dir, newest)
+		}
+
+		// Detection runs without the lock, so the window stays responsive
+		result, err := detect.DetectFile(filepath.Join(w.dir, newest), opts)
+
+		g.mu.Lock()
+		if g.screenshotWatcher != w || !g.canImportScreenshot() {
+			g.mu.Unlock() // Stopped, or the user started something else meanwhile
+			continue
+		}
+		if err != nil {
+			log.Printf("Error detecting road from watched screenshot '%s': %v", newest, err)
+			g.calculationStatus = fmt.Sprintf("Grid Detect Err from %s: %v", newest, err)
+		} else {
+			g.importWatchedScreenshot(result, newest)
+		}
+		g.mu.Unlock()
+	}
+}
+
+// importWatchedScreenshot shows a detected screenshot from the watched folder for review. With
+// WatchAutoCalculate, a detection without doubtful tiles is accepted right away and all valid
+// river starts are calculated with the current max length and rules, the ones the last
+// calculation used unless they were changed since.
+// NOTE: g.mu is assumed to be HELD by the caller
+func (g *Game) importWatchedScreenshot(result detect.Result, name string) {
+	log.Printf("Importing new screenshot %s from %s", name, g.watchSettings.Dir)
+	g.processDetectedImage(result, name)
+	if g.watchSettings.Mode != WatchAutoCalculate {
+		return
+	}
+	if result.MinConfidence() < detect.LowConfidence {
+		log.Printf("Screenshot %s has doubtful tiles, waiting for review instead of calculating.", name)
+		return
+	}
+	g.acceptDetectionReview()
+	g.startGlobalCalculation()
+}
+
+// startScreenshotWatcher starts watching the folder of the watcher settings if a watch mode is
+// set, stopping the previous watcher. Problems turn the watching off and are returned.
+// NOTE: g.mu is assumed to be HELD by the caller
+func (g *Game) startScreenshotWatcher() error {
+	g.stopScreenshotWatcher()
+	if g.watchSettings.Mode == WatchOff || g.watchSettings.Dir == "" {
+		return nil
+	}
+	w, err := newScreenshotWatcher(g.watchSettings.Dir)
+	if err != nil {
+		g.watchSettings.Mode = WatchOff
+		return fmt.Errorf("watching %s: %w", g.watchSettings.Dir, err)
+	}
+	g.screenshotWatcher = w
+	go g.runScreenshotWatcher(w)
+	log.Printf("Watching %s for new screenshots (%d already there are skipped).", w.dir, len(w.handled))
+	return nil
+}
+
+// stopScreenshotWatcher stops the running watcher, if any.
+// NOTE: g.mu is assumed to be HELD by the caller
+func (g *Game) stopScreenshotWatcher() {
+	if g.screenshotWatcher != nil {
+		close(g.screenshotWatcher.stop)
+		g.screenshotWatcher = nil
+	}
+}
+
+// applyScreenshotWatchSettings (re)starts the watcher with the current settings, stores them
+// and reports the outcome in the status text.
+// NOTE: g.mu is assumed to be HELD by the caller
+func (g *Game) applyScreenshotWatchSettings() {
+	err := g.startScreenshotWatcher()
+	if saveErr := saveScreenshotWatchSettings(g.watchSettings); saveErr != nil {
+		log.Printf("Error saving screenshot watch settings: %v", saveErr)
+	}
+	g.updateButtonsForState()
+	switch {
+	case err != nil:
+		log.Printf("Error starting screenshot watcher: %v", err)
+		g.calculationStatus = fmt.Sprintf("Watch Err: %v", err)
+	case g.screenshotWatcher != nil:
+		g.calculationStatus = fmt.Sprintf("Watching %s for new screenshots.", g.watchSettings.Dir)
+	default:
+		g.calculationStatus = "Screenshot folder not watched."
+	}
+}
+
+// handleCycleWatchMode switches to the next watch mode. Watching without a folder asks for one first.
+// NOTE: g.mu is assumed to be HELD by the caller
+func (g *Game) handleCycleWatchMode() {
+	next := (g.watchSettings.Mode + 1) % WatchMode(len(watchModeLabels))
+	if next != WatchOff && g.watchSettings.Dir == "" && !g.chooseScreenshotFolder() {
+		return
+	}
+	g.watchSettings.Mode = next
+	g.applyScreenshotWatchSettings()
+}
+
+// handleChooseScreenshotFolder asks for the folder to watch and restarts the watcher on it.
+// NOTE: g.mu is assumed to be HELD by the caller
+func (g *Game) handleChooseScreenshotFolder() {
+	if g.chooseScreenshotFolder() {
+		if g.watchSettings.Mode == WatchOff {
+			g.watchSettings.Mode = WatchImport // Picking a folder means watching it
+		}
+		g.applyScreenshotWatchSettings()
+	}
+}
+
+// chooseScreenshotFolder shows the folder dialog and stores the pick in the watcher settings.
+// NOTE: g.mu is assumed to be HELD by the caller
+func (g *Game) chooseScreenshotFolder() bool {
+	browser := dialog.Directory().Title("Screenshot Folder to Watch")
+	if g.watchSettings.Dir != "" {
+		browser = browser.SetStartDir(g.watchSettings.Dir)
+	}
+	dir, err := browser.Browse()
+	if err != nil {
+		if err == dialog.Cancelled {
+			log.Println("Folder selection cancelled.")
+		} else {
+			log.Printf("Error opening folder dialog: %v", err)
+			g.calculationStatus = "Error: Could not choose folder."
+		}
+		return false
+	}
+	g.watchSettings.Dir = dir
+	return true
+}
+
+// appendWatchButtons adds the screenshot watcher buttons of the files page.
+func (g *Game) appendWatchButtons(buttonMinX, buttonMaxX int) {
+	g.buttons = append(g.buttons, Button{
+		Rect:    image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+		Text:    watchModeLabels[g.watchSettings.Mode],
+		OnClick: func(g *Game) { g.handleCycleWatchMode() },
+	})
+	g.buttons = append(g.buttons, Button{
+		Rect:    image.Rect(buttonMinX, 0, buttonMaxX, 0), // Y will be set in Draw
+		Text:    "Screenshot Folder...",
+		OnClick: func(g *Game) { g.handleChooseScreenshotFolder() },
+	})
+}